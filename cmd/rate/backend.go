@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/georgemac/rate/pkg/logging"
+	"github.com/georgemac/rate/pkg/persistent"
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/georgemac/rate/pkg/redis"
+	"github.com/georgemac/rate/pkg/sync"
+	"github.com/go-kit/kit/metrics"
+	goredis "github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/clientv3"
+)
+
+const (
+	// fixedWindow refills the full limit at every period boundary
+	fixedWindow = "fixed-window"
+	// slidingLog permits the limit within any rolling period
+	slidingLog = "sliding-log"
+	// slidingWindow approximates the limit within any rolling period
+	// by weighting the count of the previous period
+	slidingWindow = "sliding-window"
+	// gcra spaces requests evenly across the period while
+	// permitting bursts of up to the configured burst
+	gcra = "gcra"
+	// tokenBucket refills tokens continuously across the period
+	// up to a capacity of the configured burst
+	tokenBucket = "token-bucket"
+	// leakyBucket queues requests and releases them evenly
+	// across the period
+	leakyBucket = "leaky-bucket"
+)
+
+// inflightDelay is how long a request denied by the inflight limit
+// waits before attempting again, as a token is regained as soon as
+// any inflight request finishes rather than at the next period
+const inflightDelay = 20 * time.Millisecond
+
+// backend constructs acquirers using in-memory semaphores unless
+// a client for etcd or Redis is configured in which case it constructs
+// the shared implementations backed by them
+// Every acquirer is decorated with logging
+type backend struct {
+	cli       *clientv3.Client
+	redis     goredis.UniversalClient
+	algorithm string
+	// burst is the number of requests the gcra and token-bucket
+	// algorithms permit at once and the number of requests the
+	// leaky-bucket algorithm queues (the limit is used when <= 0)
+	burst int
+	// prefetch is the most tokens per key the fixed-window and
+	// sliding-window algorithms claim from etcd at once (disabled <= 1)
+	prefetch int
+	// schemaVersion is the version of the keys written to etcd
+	schemaVersion int
+	// prefetched tracks semaphores which prefetch from etcd
+	prefetched *prefetched
+	// redisNamespace prefixes every key written to redis
+	redisNamespace string
+	logger         logrus.FieldLogger
+
+	// leaseGrants and leaseGrantsSaved count the etcd leases
+	// granted and the grants avoided by sharing them
+	leaseGrants, leaseGrantsSaved metrics.Counter
+	// conflicts and retries count the etcd transactions which
+	// conflicted with concurrent updates and were attempted again
+	conflicts, retries metrics.Counter
+}
+
+// acquirer constructs an Acquirer which permits limit tokens per key
+// every period using the configured algorithm
+func (b backend) acquirer(limit int, period time.Duration) (rate.Acquirer, error) {
+	switch b.algorithm {
+	case fixedWindow:
+		if b.redis != nil {
+			acquirer, err := redis.NewSemaphore(b.redis, limit, period, b.redisOptions()...)
+			return b.log(acquirer), err
+		}
+
+		if b.cli != nil {
+			return b.log(b.semaphore(limit, b.persistentOptions(period)...)), nil
+		}
+
+		acquirer, err := sync.NewKeyedSemaphore(limit, period)
+		return b.log(acquirer), err
+	case slidingLog:
+		if err := b.inMemoryOnly(); err != nil {
+			return nil, err
+		}
+
+		acquirer, err := sync.NewSlidingWindowLog(limit, period)
+		return b.log(acquirer), err
+	case slidingWindow:
+		if b.redis != nil {
+			acquirer, err := redis.NewSemaphore(b.redis, limit, period, b.redisOptions(redis.WithSlidingWindow())...)
+			return b.log(acquirer), err
+		}
+
+		if b.cli == nil {
+			return nil, fmt.Errorf("algorithm %q requires etcd or redis", b.algorithm)
+		}
+
+		opts := append(b.persistentOptions(period), persistent.WithSlidingWindow(period))
+		return b.log(b.semaphore(limit, opts...)), nil
+	case gcra:
+		if b.redis != nil {
+			return nil, fmt.Errorf("algorithm %q is not supported by redis", b.algorithm)
+		}
+
+		if b.cli != nil {
+			acquirer, err := persistent.NewGCRA(b.cli.KV, limit, period, b.burstFor(limit), b.etcdOptions()...)
+			return b.log(acquirer), err
+		}
+
+		acquirer, err := sync.NewGCRA(limit, period, b.burstFor(limit))
+		return b.log(acquirer), err
+	case tokenBucket:
+		if b.redis != nil {
+			acquirer, err := redis.NewSemaphore(b.redis, limit, period, b.redisOptions(redis.WithTokenBucket(b.burstFor(limit)))...)
+			return b.log(acquirer), err
+		}
+
+		if b.cli != nil {
+			return nil, fmt.Errorf("algorithm %q is not supported by etcd", b.algorithm)
+		}
+
+		acquirer, err := sync.NewKeyedSemaphore(limit, period, sync.WithTokenBucket(b.burstFor(limit)))
+		return b.log(acquirer), err
+	case leakyBucket:
+		if err := b.inMemoryOnly(); err != nil {
+			return nil, err
+		}
+
+		scheduler, err := sync.NewLeakyBucket(limit, period, b.burstFor(limit))
+		return b.log(rate.Pace(scheduler)), err
+	}
+
+	return nil, fmt.Errorf("unknown algorithm %q", b.algorithm)
+}
+
+// hierarchical constructs an Acquirer which permits limits[i] tokens
+// for level i of a hierarchical key every period
+func (b backend) hierarchical(limits []int, period time.Duration) (rate.Acquirer, error) {
+	if b.redis != nil {
+		return nil, fmt.Errorf("tenant quotas are not supported by redis")
+	}
+
+	if b.cli != nil {
+		return b.log(persistent.NewHierarchicalSemaphore(b.cli.KV, limits, b.persistentOptions(period)...)), nil
+	}
+
+	acquirer, err := sync.NewHierarchicalSemaphore(limits, period)
+	return b.log(acquirer), err
+}
+
+// inflight constructs an Acquirer which permits limit tokens
+// per key to be held at once, each of which is returned when
+// the request it permits has finished
+func (b backend) inflight(limit int) (rate.Acquirer, error) {
+	if b.redis != nil {
+		return nil, fmt.Errorf("inflight limits are not supported by redis")
+	}
+
+	if b.cli != nil {
+		return b.log(persistent.NewInflightSemaphore(b.cli.KV, limit, persistent.WithLease(b.cli.Lease), persistent.WithSchemaVersion(b.schemaVersion))), nil
+	}
+
+	return b.log(sync.NewInflightSemaphore(limit)), nil
+}
+
+// waiter constructs a Waiter suited to the configured algorithm
+// Algorithms which regain capacity continuously wait for a single
+// token to be regained rather than the next period boundary
+func (b backend) waiter(limit int, period time.Duration) rate.Waiter {
+	switch b.algorithm {
+	case gcra, tokenBucket, leakyBucket:
+		if limit > 0 {
+			return rate.DelayWaiter(period / time.Duration(limit))
+		}
+	}
+
+	return rate.NextIntervalWaiter(period)
+}
+
+// retryAfter constructs a RetryAfterFunc suited to the configured
+// algorithm which estimates when a rejected request can next succeed
+func (b backend) retryAfter(limit int, period time.Duration) rate.RetryAfterFunc {
+	switch b.algorithm {
+	case gcra, tokenBucket, leakyBucket:
+		if limit > 0 {
+			return rate.FixedRetryAfter(period / time.Duration(limit))
+		}
+	}
+
+	return rate.NextInterval(period)
+}
+
+// burstFor returns the configured burst or limit when no burst is configured
+func (b backend) burstFor(limit int) int {
+	if b.burst <= 0 {
+		return limit
+	}
+
+	return b.burst
+}
+
+// inMemoryOnly returns an error given a shared backend is configured
+// as the configured algorithm is only implemented in-memory
+func (b backend) inMemoryOnly() error {
+	switch {
+	case b.cli != nil:
+		return fmt.Errorf("algorithm %q is not supported by etcd", b.algorithm)
+	case b.redis != nil:
+		return fmt.Errorf("algorithm %q is not supported by redis", b.algorithm)
+	}
+
+	return nil
+}
+
+// semaphore constructs an etcd backed semaphore which claims tokens in
+// batches when prefetching is enabled
+func (b backend) semaphore(limit int, opts ...persistent.Option) rate.Acquirer {
+	if b.prefetch > 1 {
+		return b.prefetched.add(persistent.NewPrefetchingSemaphore(b.cli.KV, limit, b.prefetch, opts...))
+	}
+
+	return persistent.NewSemaphore(b.cli.KV, limit, opts...)
+}
+
+func (b backend) persistentOptions(period time.Duration) []persistent.Option {
+	return append(b.etcdOptions(), persistent.WithKeyer(persistent.IntervalKeyer(period)))
+}
+
+// etcdOptions configures persistent acquirers to expire their keys
+// using leases, to count leases and transaction conflicts using
+// the configured counters and to write the configured schema version
+func (b backend) etcdOptions() []persistent.Option {
+	return []persistent.Option{
+		persistent.WithLease(b.cli.Lease),
+		persistent.WithLeaseCounters(b.leaseGrants, b.leaseGrantsSaved),
+		persistent.WithContentionCounters(b.conflicts, b.retries),
+		persistent.WithSchemaVersion(b.schemaVersion),
+	}
+}
+
+// redisOptions configures redis semaphores to write the configured
+// namespace along with the provided options
+func (b backend) redisOptions(opts ...redis.Option) []redis.Option {
+	return append([]redis.Option{redis.WithNamespace(b.redisNamespace)}, opts...)
+}
+
+func (b backend) log(acquirer rate.Acquirer) rate.Acquirer {
+	return logging.New(acquirer, b.logger)
+}
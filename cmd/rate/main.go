@@ -1,28 +1,39 @@
 package main
 
 import (
+	"context"
 	"expvar"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/georgemac/rate/pkg/logging"
+	"github.com/georgemac/rate/pkg/adaptive"
+	"github.com/georgemac/rate/pkg/check"
 	"github.com/georgemac/rate/pkg/metrics"
 	"github.com/georgemac/rate/pkg/persistent"
+	"github.com/georgemac/rate/pkg/policy"
 	"github.com/georgemac/rate/pkg/rate"
-	"github.com/georgemac/rate/pkg/sync"
+	"github.com/georgemac/rate/pkg/rls"
 	"github.com/go-kit/kit/metrics/provider"
+	goredis "github.com/go-redis/redis"
 	"github.com/sirupsen/logrus"
 	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/namespace"
+	"google.golang.org/grpc"
 )
 
 func printHelp() {
 	fmt.Println("rate [flags] <proxied_url>")
+	fmt.Println("rate check-config <policy_file>")
+	fmt.Println("rate [flags] rls <rls_config_file>")
+	fmt.Println("rate [flags] check")
 }
 
 func checkError(err error) {
@@ -33,10 +44,42 @@ func checkError(err error) {
 
 func main() {
 	var (
-		port  = flag.String("port", "4040", "port on which to service rate limiter")
-		rpm   = flag.Int("rpm", 100, "requests per minute")
-		addrs = flag.String("etcd-addresses", "", "addresses for etcd cluster (if left blank an in-memory semaphore is used instead)")
-		level = flag.String("log-level", "debug", "logging level")
+		port       = flag.String("port", "4040", "port on which to service rate limiter")
+		rpm        = flag.Int("rpm", 100, "requests per minute")
+		addrs      = flag.String("etcd-addresses", "", "addresses for etcd cluster (if left blank an in-memory semaphore is used instead)")
+		etcdNS     = flag.String("etcd-namespace", "", "prefix of every key written to etcd, which separates the keys of the limiter from those of other applications (e.g. rate/)")
+		etcdSchema = flag.Int("etcd-schema-version", 0, fmt.Sprintf("version of the keys written to etcd, between 0 (the unversioned layout) and %d, which every replica sharing a limit must agree on", persistent.SchemaVersion))
+		prefetch   = flag.Int("etcd-prefetch", 0, "maximum number of tokens per key the fixed-window and sliding-window algorithms claim from etcd at once and then serve locally (disabled when <= 1)")
+		redisNS    = flag.String("redis-namespace", "", "prefix of every key written to redis, which separates the keys of the limiter from those of other applications (e.g. rate/)")
+		redisAddrs = flag.String("redis-addresses", "", "comma separated addresses for a redis server or cluster (fixed-window, sliding-window and token-bucket only, if left blank an in-memory semaphore is used instead)")
+		algorithm  = flag.String("algorithm", fixedWindow, "limiting algorithm (fixed-window, sliding-log, sliding-window, gcra, token-bucket or leaky-bucket, sliding-log and leaky-bucket are in-memory only, token-bucket is in-memory or redis only, gcra is in-memory or etcd only and sliding-window requires etcd or redis)")
+		burst      = flag.Int("burst", 0, "number of requests permitted at once by the gcra and token-bucket algorithms or queued by the leaky-bucket algorithm (defaults to the limit when <= 0)")
+		level      = flag.String("log-level", "debug", "logging level")
+		routes     = flag.String("routes", "", "comma separated route patterns used to normalize request paths (e.g. /users/{user}/posts/{post})")
+		normalize  = flag.Bool("normalize-paths", false, "collapse request paths into route templates before deriving keys (enabled when -routes is set)")
+		key        = flag.String("key", "{path}", "template for the key requests are limited by (placeholders: {path}, {method}, {host}, {remote_addr}, {header:<name>}, {cookie:<name>}, {query:<name>})")
+		policyFile = flag.String("policy", "", "path to a JSON policy file describing per-route limits (requests matching no rule are limited by -rpm)")
+		methodCost = flag.String("method-costs", "", "comma separated request methods and their cost in tokens (e.g. POST=5,DELETE=2)")
+		lengthCost = flag.String("content-length-costs", "", "comma separated maximum content-lengths in bytes and their cost in tokens (e.g. 1024=1,1048576=10)")
+		tenantKey  = flag.String("tenant-key", "", "template for the tenant key which owns each request (enables hierarchical limits when set, see -key for placeholders)")
+		tenantRPM  = flag.Int("tenant-rpm", 1000, "requests per minute per tenant shared by all the keys of the tenant")
+		adapt      = flag.String("adaptive", "", "algorithm used to adapt the concurrency permitted to the upstream given its latency and errors (aimd or gradient, disabled if left blank)")
+		adaptQueue = flag.Int("adaptive-queue", 0, "number of requests in excess of the adaptive limit which are queued rather than shed")
+		adaptTTL   = flag.Duration("adaptive-timeout", time.Second, "latency beyond which the aimd algorithm treats a request as failed")
+		inflight   = flag.Int("max-inflight", 0, "maximum number of requests inflight per key at once, in addition to -rpm (disabled when <= 0)")
+		maxWait    = flag.Duration("max-wait", 0, "maximum time a request waits before being rejected with 429 Too Many Requests (unbounded when <= 0)")
+		maxQueue   = flag.Int("max-queue", 0, "maximum number of requests waiting per key before further requests are rejected (unbounded when <= 0)")
+		waitHdr    = flag.String("max-wait-header", "X-Max-Wait", "name of a request header clients can set to a shorter maximum wait e.g. 500ms or 2 (disabled if left blank)")
+		prioHdr    = flag.String("priority-header", "", "name of a request header which classifies waiting requests by priority e.g. a client tier (disabled if left blank)")
+		prioClass  = flag.String("priority-classes", "", "comma separated -priority-header values and their class, higher classes are served first (e.g. paid=2,free=0, other values are class 1)")
+		prioAging  = flag.Duration("priority-aging", 10*time.Second, "time a waiting request waits before being promoted by one class (disabled when <= 0)")
+		reject     = flag.Bool("reject", false, "reject requests with 429 Too Many Requests rather than waiting")
+		ruleHdr    = flag.String("rule-header", "", "name of a response header set to the policy rule which matched (disabled if left blank)")
+		statusHdrs = flag.Bool("ratelimit-headers", true, "set RateLimit-* and X-RateLimit-* response headers describing the remaining budget of each key")
+		keyHdr     = flag.String("upstream-key-header", "", "name of a request header used to forward the key of each request to the upstream (disabled if left blank)")
+		trustHops  = flag.Int("trusted-hops", 1, "number of trusted proxies in front of the check endpoint which append to X-Forwarded-For, the client address is taken that many entries from the right when X-Real-IP is not trusted or set")
+		realIP     = flag.Bool("trust-real-ip", false, "take the client address from X-Real-IP when set, only enable when the proxy in front of the check endpoint always overwrites the header")
+		remainHdr  = flag.String("upstream-remaining-header", "", "name of a request header used to forward the remaining budget of each key to the upstream (disabled if left blank)")
 	)
 
 	flag.Parse()
@@ -47,6 +90,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	if target == "check-config" {
+		checkConfig(flag.Arg(1))
+		return
+	}
+
 	var (
 		logger        = logrus.New()
 		logLevel, err = logrus.ParseLevel(*level)
@@ -55,38 +103,308 @@ func main() {
 
 	logger.SetLevel(logLevel)
 
-	url, err := url.Parse(target)
+	var (
+		provider = provider.NewExpvarProvider()
+		backend  = backend{
+			algorithm:        *algorithm,
+			burst:            *burst,
+			prefetch:         *prefetch,
+			schemaVersion:    *etcdSchema,
+			prefetched:       &prefetched{},
+			redisNamespace:   *redisNS,
+			logger:           logger,
+			leaseGrants:      provider.NewCounter("etcd_lease_grants"),
+			leaseGrantsSaved: provider.NewCounter("etcd_lease_grants_saved"),
+			conflicts:        provider.NewCounter("etcd_txn_conflicts"),
+			retries:          provider.NewCounter("etcd_txn_retries"),
+		}
+	)
+
+	if *addrs != "" {
+		if *etcdSchema < 0 || *etcdSchema > persistent.SchemaVersion {
+			checkError(fmt.Errorf("-etcd-schema-version must be between 0 and %d", persistent.SchemaVersion))
+		}
+
+		backend.cli, err = clientv3.New(clientv3.Config{Endpoints: strings.Split(*addrs, ",")})
+		checkError(err)
+
+		if *etcdNS != "" {
+			backend.cli.KV = namespace.NewKV(backend.cli.KV, *etcdNS)
+			backend.cli.Lease = namespace.NewLease(backend.cli.Lease, *etcdNS)
+			backend.cli.Watcher = namespace.NewWatcher(backend.cli.Watcher, *etcdNS)
+		}
+	}
+
+	if *redisAddrs != "" {
+		if *addrs != "" {
+			checkError(fmt.Errorf("only one of -etcd-addresses and -redis-addresses may be set"))
+		}
+
+		// multiple addresses are treated as the nodes of a cluster
+		backend.redis = goredis.NewUniversalClient(&goredis.UniversalOptions{Addrs: strings.Split(*redisAddrs, ",")})
+	}
+
+	if target == "rls" {
+		serveRLS(flag.Arg(1), *port, backend)
+		return
+	}
+
+	// in check mode requests are decided upon rather than proxied
+	decide := target == "check"
+
+	var proxy http.Handler = check.Allow
+	if decide {
+		logger.Infof("Serving decisions on /check\n")
+	} else {
+		url, err := url.Parse(target)
+		checkError(err)
+
+		logger.Infof("Proxying requests to %q\n", url)
+
+		proxy = httputil.NewSingleHostReverseProxy(url)
+	}
+
+	keyFunc, err := rate.ParseKeyTemplate(*key)
 	checkError(err)
 
-	logger.Infof("Proxying requests to %q\n", url)
+	limiterOptions := rate.Options{
+		rate.WithWaiter(backend.waiter(*rpm, time.Minute)),
+		rate.WithRetryAfter(backend.retryAfter(*rpm, time.Minute)),
+		rate.WithKeyFunc(keyFunc),
+		rate.WithMaxWait(*maxWait),
+		rate.WithMaxQueue(*maxQueue),
+		rate.WithMaxWaitHeader(*waitHdr),
+		rate.WithUpstreamHeaders(*keyHdr, *remainHdr),
+	}
 
-	var (
-		proxy    = httputil.NewSingleHostReverseProxy(url)
-		acquirer rate.Acquirer
-	)
+	if !*statusHdrs {
+		limiterOptions = append(limiterOptions, rate.WithoutStatusHeaders())
+	}
+
+	if *reject {
+		limiterOptions = append(limiterOptions, rate.WithReject())
+	}
 
-	acquirer, err = sync.NewKeyedSemaphore(*rpm, time.Minute)
+	if *prioHdr != "" {
+		classes := map[string]int{}
+		checkError(parsePairs(*prioClass, func(value string, class int) error {
+			classes[value] = class
+			return nil
+		}))
+
+		limiterOptions = append(limiterOptions, rate.WithPriority(rate.HeaderPriority(*prioHdr, classes, 1), *prioAging))
+	}
+
+	cost, err := parseCost(*methodCost, *lengthCost)
 	checkError(err)
 
-	if *addrs != "" {
-		// if addresses for etcd are configured then construct
-		// a client and replace the acquirer with the persistent
-		// etcd back implementation
-		cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(*addrs, ",")})
+	limiterOptions = append(limiterOptions, rate.WithCost(cost.Func()))
+
+	if *normalize || *routes != "" {
+		var patterns []string
+		if *routes != "" {
+			patterns = strings.Split(*routes, ",")
+		}
+
+		normalizer, err := rate.NewRouteNormalizer(patterns...)
+		checkError(err)
+
+		limiterOptions = append(limiterOptions, rate.WithNormalizer(normalizer))
+	}
+
+	var acquirer rate.Acquirer
+	if *tenantKey != "" {
+		// limit each tenant by tenant-rpm which is shared
+		// by all the keys requested by the tenant
+		tenantKeyFunc, err := rate.ParseKeyTemplate(*tenantKey)
 		checkError(err)
 
-		acquirer = persistent.NewSemaphore(cli.KV, *rpm, persistent.WithLease(cli.Lease))
+		limiterOptions = append(limiterOptions, rate.WithKeyFunc(rate.HierarchicalKey(tenantKeyFunc, keyFunc)))
+
+		acquirer, err = backend.hierarchical([]int{*tenantRPM, *rpm}, time.Minute)
+		checkError(err)
+	} else {
+		acquirer, err = backend.acquirer(*rpm, time.Minute)
+		checkError(err)
+	}
+
+	defaultOptions := limiterOptions
+	if *inflight > 0 {
+		// tokens are returned to the inflight limit as soon
+		// as each request has been proxied
+		perRequest, err := backend.inflight(*inflight)
+		checkError(err)
+
+		// prepended to a new slice so that policy rules remain
+		// unaffected by the inflight limit
+		defaultOptions = append(rate.Options{rate.WithInflight(perRequest, rate.DelayWaiter(inflightDelay))}, limiterOptions...)
 	}
 
 	var (
-		provider     = provider.NewExpvarProvider()
-		waiterOption = rate.WithWaiter(rate.NextIntervalWaiter(time.Minute))
-		limiter      = rate.NewLimiter(proxy, logging.New(acquirer, logger), waiterOption)
-		mux          = http.NewServeMux()
+		handler http.Handler
+		mux     = http.NewServeMux()
 	)
 
+	if *adapt != "" && !decide {
+		algorithm, err := adaptiveAlgorithm(*adapt, *adaptTTL)
+		checkError(err)
+
+		// protect the upstream by adapting the concurrency it is
+		// permitted once requests have passed the per key limits
+		proxy = adaptive.New(proxy, algorithm,
+			adaptive.WithQueue(*adaptQueue),
+			adaptive.WithGauge(provider.NewGauge("adaptive_limit")))
+	}
+
+	handler = rate.NewLimiter(proxy, acquirer, defaultOptions...)
+
+	if *policyFile != "" {
+		config, err := policy.LoadFile(*policyFile)
+		checkError(err)
+
+		opts := []policy.RouterOption{policy.WithLimiterOptions(limiterOptions...)}
+		if *ruleHdr != "" {
+			opts = append(opts, policy.WithRuleHeader(*ruleHdr))
+		}
+
+		handler, err = policy.NewRouter(config, proxy, handler, backend.acquirer, opts...)
+		checkError(err)
+	}
+
 	mux.Handle("/debug/vars", expvar.Handler())
-	mux.Handle("/", metrics.Handler(limiter, provider))
 
-	checkError(http.ListenAndServe(":"+*port, mux))
+	if decide {
+		checkOptions := check.HandlerOptions{check.WithTrustedHops(*trustHops)}
+		if *realIP {
+			checkOptions = append(checkOptions, check.WithRealIPHeader())
+		}
+
+		mux.Handle("/check", metrics.Handler(check.Handler(handler, checkOptions...), provider))
+	} else {
+		mux.Handle("/", metrics.Handler(handler, provider))
+	}
+
+	server := &http.Server{Addr: ":" + *port, Handler: mux}
+	done := shutdownOnSignal(logger, backend, server.Shutdown)
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		checkError(err)
+	}
+
+	<-done
+}
+
+// adaptiveAlgorithm returns the adaptive.Algorithm identified by name
+func adaptiveAlgorithm(name string, timeout time.Duration) (adaptive.Algorithm, error) {
+	switch name {
+	case "aimd":
+		return adaptive.NewAIMD(timeout), nil
+	case "gradient":
+		return adaptive.NewGradient(), nil
+	}
+
+	return nil, fmt.Errorf("unknown adaptive algorithm %q", name)
+}
+
+// serveRLS serves the Envoy rate limit service on port using
+// the limits of the config found at path
+func serveRLS(path, port string, backend backend) {
+	if path == "" {
+		printHelp()
+		os.Exit(1)
+	}
+
+	if backend.algorithm == leakyBucket {
+		// decisions are made immediately so requests cannot be paced
+		checkError(fmt.Errorf("algorithm %q is not supported by the rate limit service", backend.algorithm))
+	}
+
+	config, err := rls.LoadFile(path)
+	checkError(err)
+
+	server, err := rls.NewServer(config, backend.acquirer)
+	checkError(err)
+
+	lis, err := net.Listen("tcp", ":"+port)
+	checkError(err)
+
+	srv := grpc.NewServer()
+	rls.RegisterRateLimitServiceServer(srv, server)
+
+	backend.logger.Infof("Serving the rate limit service on %q\n", lis.Addr())
+
+	done := shutdownOnSignal(backend.logger, backend, func(context.Context) error {
+		srv.GracefulStop()
+		return nil
+	})
+
+	checkError(srv.Serve(lis))
+
+	<-done
+}
+
+// checkConfig validates the policy file found at path
+// and exits non-zero if it is invalid
+func checkConfig(path string) {
+	if path == "" {
+		printHelp()
+		os.Exit(1)
+	}
+
+	config, err := policy.LoadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s: %d rule(s) OK\n", path, len(config.Rules))
+}
+
+// parseCost parses the cost flags into a policy.Cost
+// Both flags are lists of comma separated <key>=<cost> pairs
+func parseCost(methods, lengths string) (cost policy.Cost, err error) {
+	cost.Methods = map[string]int{}
+	if err = parsePairs(methods, func(method string, n int) error {
+		cost.Methods[strings.ToUpper(method)] = n
+		return nil
+	}); err != nil {
+		return
+	}
+
+	err = parsePairs(lengths, func(length string, n int) error {
+		maxBytes, err := strconv.ParseInt(length, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		cost.ContentLength = append(cost.ContentLength, policy.ContentLengthBucket{MaxBytes: maxBytes, Cost: n})
+
+		return nil
+	})
+
+	return
+}
+
+func parsePairs(pairs string, fn func(string, int) error) error {
+	if pairs == "" {
+		return nil
+	}
+
+	for _, pair := range strings.Split(pairs, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%q must be of the form <key>=<n>", pair)
+		}
+
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("%q: %v", pair, err)
+		}
+
+		if err := fn(parts[0], n); err != nil {
+			return fmt.Errorf("%q: %v", pair, err)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	gosync "sync"
+	"syscall"
+	"time"
+
+	"github.com/georgemac/rate/pkg/persistent"
+	"github.com/sirupsen/logrus"
+)
+
+// shutdownTimeout bounds how long inflight requests are given to finish
+// and prefetched tokens to be handed back once a signal is received
+const shutdownTimeout = 10 * time.Second
+
+// prefetched tracks the prefetching semaphores constructed by a backend
+// so that their tokens can be handed back on shutdown
+type prefetched struct {
+	mu   gosync.Mutex
+	sems []*persistent.PrefetchingSemaphore
+}
+
+// add tracks sem and returns it
+func (p *prefetched) add(sem *persistent.PrefetchingSemaphore) *persistent.PrefetchingSemaphore {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sems = append(p.sems, sem)
+
+	return sem
+}
+
+// flush hands back the tokens prefetched by every tracked semaphore
+// It attempts every semaphore and returns the first error encountered
+func (p *prefetched) flush(ctxt context.Context) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, sem := range p.sems {
+		if ferr := sem.Flush(ctxt); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+
+	return err
+}
+
+// shutdownOnSignal calls stop once SIGINT or SIGTERM is received and then
+// hands back the tokens prefetched by backend, so that other replicas can
+// acquire them for the rest of the interval
+// The returned channel is closed once shutdown has completed
+func shutdownOnSignal(logger logrus.FieldLogger, backend backend, stop func(context.Context) error) <-chan struct{} {
+	var (
+		done    = make(chan struct{})
+		signals = make(chan os.Signal, 1)
+	)
+
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		defer close(done)
+
+		sig := <-signals
+		logger.Infof("Received %v, shutting down\n", sig)
+
+		ctxt, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		// stop serving first so no more tokens are acquired
+		if err := stop(ctxt); err != nil {
+			logger.WithError(err).Error("stopping server")
+		}
+
+		if err := backend.prefetched.flush(ctxt); err != nil {
+			logger.WithError(err).Error("handing back prefetched tokens")
+		}
+	}()
+
+	return done
+}
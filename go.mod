@@ -2,6 +2,8 @@ module github.com/georgemac/rate
 
 require (
 	github.com/VividCortex/gohistogram v1.0.0 // indirect
+	github.com/alicebob/gopher-json v0.0.0-20180125190556-5a6b3ba71ee6 // indirect
+	github.com/alicebob/miniredis v2.5.0+incompatible
 	github.com/c2h5oh/datasize v0.0.0-20171227191756-4eba002a5eae // indirect
 	github.com/coreos/etcd v3.3.12+incompatible // indirect
 	github.com/coreos/go-semver v0.3.0 // indirect
@@ -13,8 +15,11 @@ require (
 	github.com/elgs/gosplitargs v0.0.0-20161028071935-a491c5eeb3c8 // indirect
 	github.com/go-kit/kit v0.8.0
 	github.com/go-logfmt/logfmt v0.4.0 // indirect
+	github.com/go-redis/redis v6.15.9+incompatible
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/golang/protobuf v1.2.0
 	github.com/golangci/golangci-lint v1.16.0 // indirect
+	github.com/gomodule/redigo v2.0.0+incompatible // indirect
 	github.com/influxdata/influxdb v1.7.6 // indirect
 	github.com/influxdata/tdigest v0.0.0-20181121200506-bf2b5ad3c0a9 // indirect
 	github.com/mailru/easyjson v0.0.0-20190403194419-1ea4449da983 // indirect
@@ -28,7 +33,8 @@ require (
 	github.com/tsenart/vegeta v12.3.0+incompatible // indirect
 	github.com/ugorji/go v1.1.4 // indirect
 	github.com/wcharczuk/go-chart v2.0.1+incompatible // indirect
+	github.com/yuin/gopher-lua v0.0.0-20190514113301-1cd887cd7036 // indirect
 	go.etcd.io/etcd v3.3.12+incompatible
 	golang.org/x/image v0.0.0-20190501045829-6d32002ffd75 // indirect
-	google.golang.org/grpc v1.20.1 // indirect
+	google.golang.org/grpc v1.20.1
 )
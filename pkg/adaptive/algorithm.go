@@ -0,0 +1,117 @@
+package adaptive
+
+import (
+	"math"
+	"time"
+)
+
+// Sample is an observation of a single request handled by the upstream
+type Sample struct {
+	// RTT is the time taken for the upstream to respond
+	RTT time.Duration
+	// Inflight is the number of requests inflight when the request began
+	Inflight int
+	// Dropped is true when the request failed e.g. the upstream
+	// responded with a server error
+	Dropped bool
+}
+
+// Algorithm computes the next concurrency limit given the
+// current limit and a new sample
+// Calls to Update are serialized by the Limiter
+type Algorithm interface {
+	Update(limit float64, sample Sample) float64
+}
+
+// AlgorithmFunc is a function which implements the Algorithm interface
+type AlgorithmFunc func(float64, Sample) float64
+
+// Update delegates to the wrapped AlgorithmFunc
+func (fn AlgorithmFunc) Update(limit float64, sample Sample) float64 {
+	return fn(limit, sample)
+}
+
+// AIMD is an additive increase multiplicative decrease Algorithm
+// The limit grows by Increase for every successful sample taken
+// while at least half the limit was in use, and shrinks by a
+// factor of Backoff whenever a sample is dropped or exceeds Timeout
+type AIMD struct {
+	Increase float64
+	Backoff  float64
+	Timeout  time.Duration
+}
+
+// NewAIMD returns an AIMD which increases by 1 and backs off by
+// 10% when a request fails or takes longer than timeout
+func NewAIMD(timeout time.Duration) AIMD {
+	return AIMD{Increase: 1, Backoff: 0.9, Timeout: timeout}
+}
+
+// Update returns the next limit given the provided sample
+func (a AIMD) Update(limit float64, sample Sample) float64 {
+	if sample.Dropped || (a.Timeout > 0 && sample.RTT > a.Timeout) {
+		return limit * a.Backoff
+	}
+
+	// only grow when the limit is being exercised
+	if float64(sample.Inflight)*2 >= limit {
+		return limit + a.Increase
+	}
+
+	return limit
+}
+
+// Gradient is an Algorithm which adjusts the limit by the ratio
+// of a long term average of the RTT to the RTT of the latest sample
+// similar to TCP Vegas
+// When the upstream slows down the RTT grows beyond the long term
+// average and the limit shrinks in proportion, otherwise the limit
+// grows by the square root of the limit to allow for queueing
+type Gradient struct {
+	// Tolerance is the ratio of the RTT to the long term average
+	// which is tolerated before the limit is reduced
+	Tolerance float64
+	// Smoothing is the weight given to the newly computed limit
+	Smoothing float64
+	// Window is the number of samples over which the long term
+	// average RTT is computed
+	Window int
+
+	longRTT float64
+}
+
+// NewGradient returns a Gradient which tolerates a RTT of up to
+// twice the long term average computed over the last 600 samples
+func NewGradient() *Gradient {
+	return &Gradient{Tolerance: 2, Smoothing: 0.2, Window: 600}
+}
+
+// Update returns the next limit given the provided sample
+func (g *Gradient) Update(limit float64, sample Sample) float64 {
+	rtt := float64(sample.RTT)
+	if rtt <= 0 {
+		return limit
+	}
+
+	if g.longRTT == 0 {
+		g.longRTT = rtt
+	} else {
+		// exponentially weighted moving average over window samples
+		g.longRTT += (rtt - g.longRTT) / float64(g.Window)
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.Tolerance*g.longRTT/rtt))
+	if sample.Dropped {
+		gradient = 0.5
+	}
+
+	// allow for queueing when the limit is being exercised
+	var queue float64
+	if float64(sample.Inflight)*2 >= limit {
+		queue = math.Sqrt(limit)
+	}
+
+	next := limit*gradient + queue
+
+	return limit*(1-g.Smoothing) + next*g.Smoothing
+}
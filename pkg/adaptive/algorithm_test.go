@@ -0,0 +1,48 @@
+package adaptive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AIMD(t *testing.T) {
+	aimd := NewAIMD(time.Second)
+
+	for _, test := range []struct {
+		name     string
+		sample   Sample
+		expected float64
+	}{
+		{"increase when exercised", Sample{RTT: time.Millisecond, Inflight: 5}, 11},
+		{"hold when not exercised", Sample{RTT: time.Millisecond, Inflight: 4}, 10},
+		{"backoff when dropped", Sample{RTT: time.Millisecond, Inflight: 10, Dropped: true}, 9},
+		{"backoff when timed out", Sample{RTT: 2 * time.Second, Inflight: 10}, 9},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.InDelta(t, test.expected, aimd.Update(10, test.sample), 0.0001)
+		})
+	}
+}
+
+func Test_Gradient(t *testing.T) {
+	var (
+		gradient = NewGradient()
+		limit    = 100.0
+	)
+
+	// steady latency grows the limit while it is exercised
+	for i := 0; i < 10; i++ {
+		next := gradient.Update(limit, Sample{RTT: 10 * time.Millisecond, Inflight: int(limit)})
+		assert.True(t, next > limit, "expected %f to be greater than %f", next, limit)
+		limit = next
+	}
+
+	// a slow down beyond the tolerance shrinks the limit
+	for i := 0; i < 10; i++ {
+		next := gradient.Update(limit, Sample{RTT: 100 * time.Millisecond, Inflight: int(limit)})
+		assert.True(t, next < limit, "expected %f to be less than %f", next, limit)
+		limit = next
+	}
+}
@@ -0,0 +1,206 @@
+package adaptive
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+var now = time.Now
+
+// Limiter is a http.Handler which limits the number of requests
+// inflight to the wrapped handler, typically the proxy to the upstream
+// Rather than a static limit, the limit is adjusted by an Algorithm
+// using the latency and failures observed for every request handled
+// Requests in excess of the limit are queued up to a configured depth
+// and otherwise shed with 503 service unavailable
+type Limiter struct {
+	handler   http.Handler
+	algorithm Algorithm
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	// queue holds a channel per queued request which
+	// is closed when the request is granted a slot
+	queue *list.List
+
+	min, max   int
+	queueDepth int
+
+	gauge metrics.Gauge
+}
+
+// New constructs a newly configured Limiter which adapts the concurrency
+// permitted to handler using the provided algorithm
+// By default the limit starts at 20 and adapts between 1 and 1000
+func New(handler http.Handler, algorithm Algorithm, opts ...Option) *Limiter {
+	l := &Limiter{
+		handler:   handler,
+		algorithm: algorithm,
+		limit:     20,
+		queue:     list.New(),
+		min:       1,
+		max:       1000,
+		gauge:     discard.NewGauge(),
+	}
+
+	Options(opts).Apply(l)
+
+	l.gauge.Set(l.limit)
+
+	return l
+}
+
+// Limit returns the current concurrency limit
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
+
+// ServeHTTP delegates to the wrapped handler given the request is
+// within the current limit, otherwise the request is queued or shed
+func (l *Limiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	inflight, ok := l.acquire(r)
+	if !ok {
+		http.Error(w, "service overloaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	var (
+		start    = now()
+		recorder = &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		// a panic is treated as a failed request
+		dropped = true
+	)
+
+	defer func() {
+		// given the client went away the request was cut short, e.g.
+		// the proxy responds 502 Bad Gateway, so its outcome says
+		// nothing about the upstream and it is not sampled
+		l.release(Sample{
+			RTT:      now().Sub(start),
+			Inflight: inflight,
+			Dropped:  dropped,
+		}, r.Context().Err() == nil)
+	}()
+
+	l.handler.ServeHTTP(recorder, r)
+
+	dropped = recorder.status >= http.StatusInternalServerError
+}
+
+// acquire obtains a slot for the request, queueing when configured
+// It returns the number of requests inflight including the request
+// and false if the request should be shed
+func (l *Limiter) acquire(r *http.Request) (int, bool) {
+	l.mu.Lock()
+
+	if l.inflight < int(l.limit) {
+		l.inflight++
+		inflight := l.inflight
+		l.mu.Unlock()
+
+		return inflight, true
+	}
+
+	if l.queue.Len() >= l.queueDepth {
+		l.mu.Unlock()
+		return 0, false
+	}
+
+	var (
+		ready = make(chan struct{})
+		elem  = l.queue.PushBack(ready)
+	)
+
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		// the slot is handed over by release
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		return l.inflight, true
+	case <-r.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-ready:
+		// granted while giving up so return the slot
+		l.inflight--
+		l.next()
+	default:
+		l.queue.Remove(elem)
+	}
+
+	return 0, false
+}
+
+// release updates the limit given the sample was observed and returns
+// the slot of the request to the next queued request if any
+func (l *Limiter) release(sample Sample, observed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if observed {
+		l.update(sample)
+	}
+
+	l.inflight--
+	l.next()
+}
+
+// update adjusts the limit given the sample within the configured bounds
+// It must be called while holding the lock
+func (l *Limiter) update(sample Sample) {
+	l.limit = l.algorithm.Update(l.limit, sample)
+	if l.limit < float64(l.min) {
+		l.limit = float64(l.min)
+	}
+
+	if l.limit > float64(l.max) {
+		l.limit = float64(l.max)
+	}
+
+	l.gauge.Set(l.limit)
+}
+
+// next hands slots to queued requests while within the limit
+// It must be called while holding the lock
+func (l *Limiter) next() {
+	for l.queue.Len() > 0 && l.inflight < int(l.limit) {
+		ready := l.queue.Remove(l.queue.Front()).(chan struct{})
+		l.inflight++
+		close(ready)
+	}
+}
+
+// statusRecorder records the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush delegates to the wrapped ResponseWriter given it is a http.Flusher
+// so that streamed responses are still flushed by the proxy
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,165 @@
+package adaptive
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+// fixed is an Algorithm which never changes the limit
+var fixed = AlgorithmFunc(func(limit float64, _ Sample) float64 { return limit })
+
+func Test_Limiter_Shed(t *testing.T) {
+	var (
+		block   = make(chan struct{})
+		started = make(chan struct{})
+		handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			started <- struct{}{}
+			<-block
+		})
+		limiter = New(handler, fixed, WithLimits(1, 1, 1))
+		wg      sync.WaitGroup
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		rec := httptest.NewRecorder()
+		limiter.ServeHTTP(rec, httptest.NewRequest("GET", "/foo", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}()
+
+	<-started
+
+	// the limit is reached so the request is shed
+	rec := httptest.NewRecorder()
+	limiter.ServeHTTP(rec, httptest.NewRequest("GET", "/foo", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	close(block)
+	wg.Wait()
+}
+
+func Test_Limiter_Queue(t *testing.T) {
+	var (
+		block   = make(chan struct{})
+		started = make(chan struct{}, 2)
+		handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			started <- struct{}{}
+			<-block
+		})
+		limiter = New(handler, fixed, WithLimits(1, 1, 1), WithQueue(1))
+		wg      sync.WaitGroup
+	)
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rec := httptest.NewRecorder()
+			limiter.ServeHTTP(rec, httptest.NewRequest("GET", "/foo", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}()
+	}
+
+	<-started
+
+	// wait for the second request to be queued
+	for {
+		limiter.mu.Lock()
+		queued := limiter.queue.Len()
+		limiter.mu.Unlock()
+
+		if queued == 1 {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	// the queue is full so the request is shed
+	rec := httptest.NewRecorder()
+	limiter.ServeHTTP(rec, httptest.NewRequest("GET", "/foo", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// a queued request which is cancelled gives up its place
+	ctxt, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rec = httptest.NewRecorder()
+	limiter.ServeHTTP(rec, httptest.NewRequest("GET", "/foo", nil).WithContext(ctxt))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// releasing the first request hands its slot to the queued request
+	block <- struct{}{}
+	<-started
+	close(block)
+
+	wg.Wait()
+
+	assert.Equal(t, 0, limiter.inflight)
+}
+
+func Test_Limiter_Adapts(t *testing.T) {
+	var (
+		status  = http.StatusOK
+		handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		})
+		gauge   = generic.NewGauge("limit")
+		limiter = New(handler, NewAIMD(time.Second), WithLimits(2, 1, 3), WithGauge(gauge))
+	)
+
+	serve := func() {
+		limiter.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/foo", nil))
+	}
+
+	// a single request in flight exercises half the limit
+	serve()
+	assert.Equal(t, 3, limiter.Limit())
+
+	// the limit is capped at max
+	serve()
+	assert.Equal(t, 3, limiter.Limit())
+	assert.Equal(t, float64(3), gauge.Value())
+
+	// upstream failures reduce the limit down to min
+	status = http.StatusBadGateway
+	for i := 0; i < 20; i++ {
+		serve()
+	}
+
+	assert.Equal(t, 1, limiter.Limit())
+	assert.Equal(t, float64(1), gauge.Value())
+}
+
+func Test_Limiter_ClientGone(t *testing.T) {
+	var (
+		cancel  context.CancelFunc
+		handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			// the client goes away and the proxy fails the request
+			cancel()
+			w.WriteHeader(http.StatusBadGateway)
+		})
+		limiter = New(handler, NewAIMD(time.Second), WithLimits(3, 1, 3))
+	)
+
+	for i := 0; i < 20; i++ {
+		ctxt, cancelRequest := context.WithCancel(context.Background())
+		cancel = cancelRequest
+
+		limiter.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/foo", nil).WithContext(ctxt))
+		cancelRequest()
+	}
+
+	// requests cut short by the client are not sampled
+	assert.Equal(t, 3, limiter.Limit())
+}
@@ -0,0 +1,41 @@
+package adaptive
+
+import "github.com/go-kit/kit/metrics"
+
+// Option is a functional option for the Limiter
+type Option func(*Limiter)
+
+// Options is a slice of Option types
+type Options []Option
+
+// Apply calls each option in o on the provided Limiter
+func (o Options) Apply(l *Limiter) {
+	for _, opt := range o {
+		opt(l)
+	}
+}
+
+// WithLimits configures the initial limit of the Limiter and
+// the bounds within which it is adapted
+func WithLimits(initial, min, max int) Option {
+	return func(l *Limiter) {
+		l.limit = float64(initial)
+		l.min, l.max = min, max
+	}
+}
+
+// WithQueue configures the number of requests in excess of the
+// limit which are queued rather than shed
+func WithQueue(depth int) Option {
+	return func(l *Limiter) {
+		l.queueDepth = depth
+	}
+}
+
+// WithGauge configures a gauge which is set to the current limit
+// whenever it is adapted
+func WithGauge(gauge metrics.Gauge) Option {
+	return func(l *Limiter) {
+		l.gauge = gauge
+	}
+}
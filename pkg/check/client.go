@@ -0,0 +1,140 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+)
+
+// Decision is the outcome of checking a request
+type Decision struct {
+	// Allowed is true when the request is within its limits
+	Allowed bool
+	// Status is the budget of the key of the request and is
+	// only set when Reported is true
+	Status   rate.Status
+	Reported bool
+	// RetryAfter is how long to wait before retrying a request
+	// which is not allowed, given the service provided it
+	RetryAfter time.Duration
+}
+
+// ClientOption is a functional option for the Client
+type ClientOption func(*Client)
+
+// ClientOptions is a slice of ClientOption types
+type ClientOptions []ClientOption
+
+// Apply calls each option in o on the provided Client
+func (o ClientOptions) Apply(c *Client) {
+	for _, opt := range o {
+		opt(c)
+	}
+}
+
+// WithHTTPClient configures the http.Client used to call
+// the check endpoint, which defaults to http.DefaultClient
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// Client checks requests against the check endpoint of a rate
+// decision service, e.g. one started with `rate check`
+type Client struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewClient constructs a Client which calls the check endpoint
+// found at the provided URL e.g. http://localhost:4000/check
+func NewClient(endpoint string, opts ...ClientOption) *Client {
+	c := &Client{endpoint: endpoint, client: http.DefaultClient}
+
+	ClientOptions(opts).Apply(c)
+
+	return c
+}
+
+// Check asks the service whether r is within its limits
+// The method, URI, host and remote address of r are sent as the
+// X-Original-* and X-Forwarded-* headers along with the headers of r
+// The body of r is never sent nor read
+// An error is returned when the service responds with anything
+// other than 200 OK or 429 Too Many Requests
+func (c *Client) Check(ctxt context.Context, r *http.Request) (Decision, error) {
+	if r.URL == nil {
+		return Decision{}, errors.New("checking request: request has no url")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.endpoint, nil)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	req = req.WithContext(ctxt)
+
+	for name, values := range r.Header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	req.Header.Set(HeaderOriginalMethod, r.Method)
+	req.Header.Set(HeaderOriginalURI, r.URL.RequestURI())
+	req.Header.Set(HeaderForwardedHost, host)
+
+	if r.RemoteAddr != "" {
+		ip := r.RemoteAddr
+		if h, _, err := net.SplitHostPort(ip); err == nil {
+			ip = h
+		}
+
+		// the client is appended such that proxies which
+		// forwarded r remain after the original client
+		if forwarded := req.Header.Get(HeaderForwardedFor); forwarded != "" {
+			ip = forwarded + ", " + ip
+		}
+
+		req.Header.Set(HeaderForwardedFor, ip)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	defer func() {
+		// drain the body so that the connection may be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	var decision Decision
+	switch resp.StatusCode {
+	case http.StatusOK:
+		decision.Allowed = true
+	case http.StatusTooManyRequests:
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			decision.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	default:
+		return Decision{}, fmt.Errorf("checking request: unexpected status %q", resp.Status)
+	}
+
+	decision.Status, decision.Reported = rate.ParseStatus(resp.Header)
+
+	return decision, nil
+}
@@ -0,0 +1,78 @@
+package check
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/georgemac/rate/pkg/sync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Client_Check(t *testing.T) {
+	acquirer, err := sync.NewKeyedSemaphore(2, time.Minute)
+	require.Nil(t, err)
+
+	var (
+		keys    = make(chan string, 10)
+		keyFunc = func(r *http.Request) string {
+			key := r.Method + " " + r.Host + r.URL.Path + " " + rate.RemoteAddrKey(r)
+			keys <- key
+			return key
+		}
+		limiter = rate.NewLimiter(Allow, acquirer, rate.WithReject(), rate.WithKeyFunc(keyFunc))
+		server  = httptest.NewServer(Handler(limiter))
+		client  = NewClient(server.URL+"/check", WithHTTPClient(server.Client()))
+		ctxt    = context.Background()
+	)
+
+	defer server.Close()
+
+	r := httptest.NewRequest(http.MethodPut, "http://api.local/foo?bar=baz", nil)
+	r.RemoteAddr = "192.168.0.1:1234"
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		decision, err := client.Check(ctxt, r)
+		require.Nil(t, err)
+
+		assert.True(t, decision.Allowed)
+		assert.True(t, decision.Reported)
+		assert.Equal(t, 2, decision.Status.Limit)
+		assert.Equal(t, remaining, decision.Status.Remaining)
+		assert.Equal(t, "PUT api.local/foo 192.168.0.1", <-keys)
+	}
+
+	decision, err := client.Check(ctxt, r)
+	require.Nil(t, err)
+
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.Reported)
+	assert.Equal(t, 0, decision.Status.Remaining)
+	assert.True(t, decision.RetryAfter > 0)
+}
+
+func Test_Client_Check_Unavailable(t *testing.T) {
+	var (
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "service currently unavailable", http.StatusServiceUnavailable)
+		}))
+		client = NewClient(server.URL + "/check")
+	)
+
+	defer server.Close()
+
+	_, err := client.Check(context.Background(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.NotNil(t, err)
+}
+
+func Test_Client_Check_NoURL(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	r.URL = nil
+
+	_, err := NewClient("http://localhost/check").Check(context.Background(), r)
+	assert.NotNil(t, err)
+}
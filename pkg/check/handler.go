@@ -0,0 +1,167 @@
+package check
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Headers which describe the original request to the check endpoint
+// The X-Original-* headers are set by nginx auth_request configurations
+// whereas the X-Forwarded-* headers are set by Traefik and Caddy forward-auth
+const (
+	HeaderOriginalMethod  = "X-Original-Method"
+	HeaderOriginalURI     = "X-Original-URI"
+	HeaderForwardedMethod = "X-Forwarded-Method"
+	HeaderForwardedURI    = "X-Forwarded-Uri"
+	HeaderForwardedHost   = "X-Forwarded-Host"
+	HeaderForwardedProto  = "X-Forwarded-Proto"
+	HeaderForwardedFor    = "X-Forwarded-For"
+	HeaderRealIP          = "X-Real-IP"
+)
+
+// HandlerOption is a functional option for Handler and OriginalRequest
+type HandlerOption func(*config)
+
+// HandlerOptions is a slice of HandlerOption types
+type HandlerOptions []HandlerOption
+
+// Apply calls each option in o on the provided config
+func (o HandlerOptions) Apply(c *config) {
+	for _, opt := range o {
+		opt(c)
+	}
+}
+
+// config is the configuration of how original requests are rebuilt
+type config struct {
+	trustedHops int
+	realIP      bool
+}
+
+// WithTrustedHops configures the number of trusted proxies which append
+// to X-Forwarded-For in front of the check endpoint, which defaults to 1
+// The client address is taken that many entries from the right, as every
+// entry further left could have been sent by the client itself
+func WithTrustedHops(hops int) HandlerOption {
+	return func(c *config) {
+		if hops < 1 {
+			hops = 1
+		}
+
+		c.trustedHops = hops
+	}
+}
+
+// WithRealIPHeader configures the client address to be taken from
+// X-Real-IP given it is set, which is disabled by default as the
+// client can set the header itself
+// Only enable it when the proxy in front of the check endpoint always
+// overwrites the header, as nginx does with proxy_set_header
+func WithRealIPHeader() HandlerOption {
+	return func(c *config) {
+		c.realIP = true
+	}
+}
+
+// Allow is a http.Handler which responds 200 OK without a body
+// It takes the place of the proxy of a rate.Limiter or policy.Router
+// when they are used to decide on requests rather than proxy them
+var Allow = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// Handler returns a http.Handler which serves decisions for requests
+// described by the headers of each check request
+// The original request is rebuilt by OriginalRequest and served to
+// limiter, which is typically a rate.Limiter or policy.Router wrapping
+// Allow, so that it responds 200 or 429 along with RateLimit headers
+// Check requests which do not describe a valid request are rejected
+// with 400 bad request
+func Handler(limiter http.Handler, opts ...HandlerOption) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		original, err := OriginalRequest(r, opts...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limiter.ServeHTTP(w, original)
+	})
+}
+
+// OriginalRequest rebuilds the request described by the headers of r
+// The method is taken from X-Original-Method or X-Forwarded-Method,
+// the URI from X-Original-URI or X-Forwarded-Uri, the host from
+// X-Forwarded-Host and the remote address from X-Real-IP when trusted
+// (see WithRealIPHeader) or else the entry of X-Forwarded-For appended
+// by the furthest trusted proxy (see WithTrustedHops), falling back to
+// those of r when absent
+// The headers of r are retained as the headers of the client and the
+// body is always empty, given it is never sent to the check endpoint
+func OriginalRequest(r *http.Request, opts ...HandlerOption) (*http.Request, error) {
+	c := config{trustedHops: 1}
+	HandlerOptions(opts).Apply(&c)
+
+	uri := first(r.Header.Get(HeaderOriginalURI), r.Header.Get(HeaderForwardedURI), r.URL.RequestURI())
+
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parsing original uri %q: %v", uri, err)
+	}
+
+	u.Host = first(r.Header.Get(HeaderForwardedHost), r.Host)
+	u.Scheme = first(r.Header.Get(HeaderForwardedProto), "http")
+
+	original := r.WithContext(r.Context())
+	original.Method = strings.ToUpper(first(r.Header.Get(HeaderOriginalMethod), r.Header.Get(HeaderForwardedMethod), r.Method))
+	original.URL = u
+	original.RequestURI = uri
+	original.Host = u.Host
+	original.RemoteAddr = remoteAddr(r, c)
+	original.Body = http.NoBody
+	original.ContentLength = 0
+
+	return original, nil
+}
+
+// remoteAddr returns the address of the client which sent the original
+// request, which is X-Real-IP given it is trusted and set by the proxy
+// in front
+// Otherwise it is the address of X-Forwarded-For hops from the right,
+// given proxies append to the header and the client can set its
+// leading entries to anything
+func remoteAddr(r *http.Request, c config) string {
+	if c.realIP {
+		if addr := strings.TrimSpace(r.Header.Get(HeaderRealIP)); addr != "" {
+			return addr
+		}
+	}
+
+	hops := c.trustedHops
+
+	var addrs []string
+	for _, value := range r.Header[http.CanonicalHeaderKey(HeaderForwardedFor)] {
+		addrs = append(addrs, strings.Split(value, ",")...)
+	}
+
+	if len(addrs) >= hops {
+		if addr := strings.TrimSpace(addrs[len(addrs)-hops]); addr != "" {
+			return addr
+		}
+	}
+
+	return r.RemoteAddr
+}
+
+// first returns the first of values which is not empty
+func first(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,148 @@
+package check
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_OriginalRequest(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		opts       HandlerOptions
+		header     http.Header
+		method     string
+		uri        string
+		host       string
+		remoteAddr string
+	}{
+		{
+			name:       "without headers",
+			method:     http.MethodGet,
+			uri:        "/check",
+			host:       "rate.local",
+			remoteAddr: "10.0.0.1:1234",
+		},
+		{
+			name: "nginx auth_request",
+			opts: HandlerOptions{WithRealIPHeader()},
+			header: http.Header{
+				HeaderOriginalMethod: {"post"},
+				HeaderOriginalURI:    {"/foo?bar=baz"},
+				HeaderForwardedHost:  {"api.local"},
+				HeaderRealIP:         {"192.168.0.1"},
+			},
+			method:     http.MethodPost,
+			uri:        "/foo?bar=baz",
+			host:       "api.local",
+			remoteAddr: "192.168.0.1",
+		},
+		{
+			name: "forward-auth",
+			header: http.Header{
+				HeaderForwardedMethod: {"DELETE"},
+				HeaderForwardedURI:    {"/foo/1"},
+				HeaderForwardedHost:   {"api.local"},
+				HeaderForwardedFor:    {"192.168.0.1, 10.0.0.2"},
+				HeaderRealIP:          {"10.0.0.2"},
+			},
+			method:     http.MethodDelete,
+			uri:        "/foo/1",
+			host:       "api.local",
+			remoteAddr: "10.0.0.2",
+		},
+		{
+			name: "spoofed real ip",
+			header: http.Header{
+				HeaderForwardedURI: {"/foo"},
+				// set by the client and passed through by the proxy
+				HeaderRealIP:       {"1.2.3.4"},
+				HeaderForwardedFor: {"192.168.0.1"},
+			},
+			method:     http.MethodGet,
+			uri:        "/foo",
+			host:       "rate.local",
+			remoteAddr: "192.168.0.1",
+		},
+		{
+			name: "spoofed real ip without forwarded for",
+			header: http.Header{
+				HeaderForwardedURI: {"/foo"},
+				HeaderRealIP:       {"1.2.3.4"},
+			},
+			method:     http.MethodGet,
+			uri:        "/foo",
+			host:       "rate.local",
+			remoteAddr: "10.0.0.1:1234",
+		},
+		{
+			name: "spoofed forwarded for",
+			header: http.Header{
+				HeaderForwardedURI: {"/foo"},
+				// the leading entry is sent by the client
+				HeaderForwardedFor: {"1.2.3.4, 192.168.0.1"},
+			},
+			method:     http.MethodGet,
+			uri:        "/foo",
+			host:       "rate.local",
+			remoteAddr: "192.168.0.1",
+		},
+		{
+			name: "trusted hops",
+			opts: HandlerOptions{WithTrustedHops(2)},
+			header: http.Header{
+				HeaderForwardedURI: {"/foo"},
+				HeaderForwardedFor: {"1.2.3.4, 192.168.0.1", "10.0.0.2"},
+			},
+			method:     http.MethodGet,
+			uri:        "/foo",
+			host:       "rate.local",
+			remoteAddr: "192.168.0.1",
+		},
+		{
+			name: "fewer entries than trusted hops",
+			opts: HandlerOptions{WithTrustedHops(3)},
+			header: http.Header{
+				HeaderForwardedURI: {"/foo"},
+				HeaderForwardedFor: {"192.168.0.1, 10.0.0.2"},
+			},
+			method:     http.MethodGet,
+			uri:        "/foo",
+			host:       "rate.local",
+			remoteAddr: "10.0.0.1:1234",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://rate.local/check", nil)
+			r.RemoteAddr = "10.0.0.1:1234"
+			for name, values := range test.header {
+				r.Header[http.CanonicalHeaderKey(name)] = values
+			}
+
+			original, err := OriginalRequest(r, test.opts...)
+			require.Nil(t, err)
+
+			assert.Equal(t, test.method, original.Method)
+			assert.Equal(t, test.uri, original.URL.RequestURI())
+			assert.Equal(t, test.host, original.Host)
+			assert.Equal(t, test.remoteAddr, original.RemoteAddr)
+			assert.Equal(t, http.NoBody, original.Body)
+		})
+	}
+}
+
+func Test_Handler_BadRequest(t *testing.T) {
+	var (
+		r = httptest.NewRequest(http.MethodGet, "/check", nil)
+		w = httptest.NewRecorder()
+	)
+
+	r.Header.Set(HeaderOriginalURI, "not a uri")
+
+	Handler(Allow).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
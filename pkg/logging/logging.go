@@ -39,3 +39,139 @@ func (a Acquirer) Acquire(ctxt context.Context, key string) (acquired bool, err
 	acquired, err = a.Acquirer.Acquire(ctxt, key)
 	return
 }
+
+// AcquireN delegates to the embedded Acquirer via rate.AcquireN
+// It decorates the call to AcquireN with logging before and after it returns
+func (a Acquirer) AcquireN(ctxt context.Context, key string, n int) (acquired bool, err error) {
+	start := time.Now()
+
+	defer func() {
+		finish := time.Now()
+		a.logger.
+			WithField("finish", finish).
+			WithField("ellapsed", finish.Sub(start)).
+			WithField("acquired", acquired).
+			Debugf("AcquireN(%q, %d) returned", key, n)
+	}()
+
+	a.logger.WithField("start", start).Debugf("AcquireN(%q, %d)", key, n)
+
+	acquired, err = rate.AcquireN(ctxt, a.Acquirer, key, n)
+	return
+}
+
+// WaitN delegates to the embedded Acquirer given it is a rate.WaitAcquirer
+// It decorates the call to WaitN with logging before and after it returns
+func (a Acquirer) WaitN(ctxt context.Context, key string, n int) (err error) {
+	wacquirer, ok := a.Acquirer.(rate.WaitAcquirer)
+	if !ok {
+		return rate.ErrWaitNotSupported
+	}
+
+	start := time.Now()
+
+	defer func() {
+		finish := time.Now()
+		a.logger.
+			WithField("finish", finish).
+			WithField("ellapsed", finish.Sub(start)).
+			WithError(err).
+			Debugf("WaitN(%q, %d) returned", key, n)
+	}()
+
+	a.logger.WithField("start", start).Debugf("WaitN(%q, %d)", key, n)
+
+	return wacquirer.WaitN(ctxt, key, n)
+}
+
+// Lease delegates to the embedded Acquirer via rate.LeaseN
+// It decorates the call to Lease with logging before and after it returns
+func (a Acquirer) Lease(ctxt context.Context, key string, n int) (lease rate.Lease, acquired bool, err error) {
+	start := time.Now()
+
+	defer func() {
+		finish := time.Now()
+		a.logger.
+			WithField("finish", finish).
+			WithField("ellapsed", finish.Sub(start)).
+			WithField("acquired", acquired).
+			Debugf("Lease(%q, %d) returned", key, n)
+	}()
+
+	a.logger.WithField("start", start).Debugf("Lease(%q, %d)", key, n)
+
+	lease, acquired, err = rate.LeaseN(ctxt, a.Acquirer, key, n)
+	return
+}
+
+// ClaimN delegates to the embedded Acquirer via rate.ClaimN
+// It decorates the call to ClaimN with logging before and after it returns
+func (a Acquirer) ClaimN(ctxt context.Context, key string, n int) (refund rate.RefundFunc, acquired bool, err error) {
+	start := time.Now()
+
+	defer func() {
+		finish := time.Now()
+		a.logger.
+			WithField("finish", finish).
+			WithField("ellapsed", finish.Sub(start)).
+			WithField("acquired", acquired).
+			WithError(err).
+			Debugf("ClaimN(%q, %d) returned", key, n)
+	}()
+
+	a.logger.WithField("start", start).Debugf("ClaimN(%q, %d)", key, n)
+
+	return rate.ClaimN(ctxt, a.Acquirer, key, n)
+}
+
+// Refund delegates to the embedded Acquirer given it is a rate.Refunder
+// It decorates the call to Refund with logging before it returns
+func (a Acquirer) Refund(ctxt context.Context, key string, n int) (err error) {
+	refunder, ok := a.Acquirer.(rate.Refunder)
+	if !ok {
+		return nil
+	}
+
+	defer func() {
+		a.logger.WithError(err).Debugf("Refund(%q, %d) returned", key, n)
+	}()
+
+	return refunder.Refund(ctxt, key, n)
+}
+
+// Status delegates to the embedded Acquirer given it is a rate.Reporter
+// It decorates the call to Status with logging before it returns
+func (a Acquirer) Status(ctxt context.Context, key string) (status rate.Status, err error) {
+	reporter, ok := a.Acquirer.(rate.Reporter)
+	if !ok {
+		return rate.Status{}, rate.ErrStatusNotSupported
+	}
+
+	defer func() {
+		a.logger.
+			WithField("remaining", status.Remaining).
+			WithError(err).
+			Debugf("Status(%q) returned", key)
+	}()
+
+	return reporter.Status(ctxt, key)
+}
+
+// ReserveN delegates to the embedded Acquirer given it is a rate.Reserver
+// It decorates the call to ReserveN with logging before it returns
+func (a Acquirer) ReserveN(ctxt context.Context, key string, n int) (reservation rate.Reservation, err error) {
+	reserver, ok := a.Acquirer.(rate.Reserver)
+	if !ok {
+		return rate.Reservation{}, rate.ErrReserveNotSupported
+	}
+
+	defer func() {
+		a.logger.
+			WithField("ok", reservation.OK()).
+			WithField("delay", reservation.Delay()).
+			WithError(err).
+			Debugf("ReserveN(%q, %d) returned", key, n)
+	}()
+
+	return reserver.ReserveN(ctxt, key, n)
+}
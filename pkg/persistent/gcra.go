@@ -0,0 +1,220 @@
+package persistent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/georgemac/rate/pkg/rate"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// GCRA is backed by etcd and limits keys using the generic cell
+// rate algorithm (see rate.GCRA)
+// A single theoretical arrival time is stored per key, as unix
+// nanoseconds, which is updated using a compare-and-swap
+// When configured with a lease the key expires once the theoretical
+// arrival time has passed, as it is then equivalent to a missing key
+type GCRA struct {
+	sem  *Semaphore
+	gcra rate.GCRA
+}
+
+// NewGCRA returns a configured etcd backed GCRA which implements rate.Acquirer
+// It permits limit requests per key every period with bursts of up to burst requests
+// Of the provided options only WithLease applies
+// rate.ErrRateNotPermitted is returned if the limit or period is <= 0
+func NewGCRA(kv clientv3.KV, limit int, period time.Duration, burst int, opts ...Option) (*GCRA, error) {
+	gcra, err := rate.NewGCRA(limit, period, burst)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GCRA{
+		sem:  NewSemaphore(kv, limit, opts...),
+		gcra: gcra,
+	}, nil
+}
+
+// Acquire returns true if a request for key conforms
+func (g *GCRA) Acquire(ctxt context.Context, key string) (bool, error) {
+	return g.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN returns true if n requests for key conform at once
+func (g *GCRA) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	if !g.gcra.Permits(n) {
+		return false, ErrCostExceedsLimit
+	}
+
+	return g.update(ctxt, key, func(tat, now time.Time) (time.Time, bool) {
+		return g.gcra.Conform(tat, now, n)
+	})
+}
+
+// ClaimN returns true if n requests for key conform at once (see AcquireN)
+// The returned rate.RefundFunc returns the requests (see Refund), as the
+// theoretical arrival time has no intervals for them to expire with
+func (g *GCRA) ClaimN(ctxt context.Context, key string, n int) (rate.RefundFunc, bool, error) {
+	acquired, err := g.AcquireN(ctxt, key, n)
+
+	return func(ctxt context.Context) error {
+		return g.Refund(ctxt, key, n)
+	}, acquired, err
+}
+
+// Refund returns n previously acquired requests for key by
+// moving its theoretical arrival time back
+func (g *GCRA) Refund(ctxt context.Context, key string, n int) error {
+	_, err := g.update(ctxt, key, func(tat, _ time.Time) (time.Time, bool) {
+		return tat.Add(-g.gcra.Emission * time.Duration(n)), !tat.IsZero()
+	})
+
+	return err
+}
+
+// Reserve reserves a request for key (see ReserveN)
+func (g *GCRA) Reserve(ctxt context.Context, key string) (rate.Reservation, error) {
+	return g.ReserveN(ctxt, key, 1)
+}
+
+// ReserveN reserves n requests for key and returns the time
+// from which they conform
+// Cancelling the reservation moves the theoretical arrival
+// time back as if the requests were never made
+func (g *GCRA) ReserveN(ctxt context.Context, key string, n int) (rate.Reservation, error) {
+	if !g.gcra.Permits(n) {
+		return rate.Reservation{}, ErrCostExceedsLimit
+	}
+
+	var at time.Time
+	if _, err := g.update(ctxt, key, func(tat, now time.Time) (next time.Time, _ bool) {
+		next, at = g.gcra.Reserve(tat, now, n)
+		return next, true
+	}); err != nil {
+		return rate.Reservation{}, err
+	}
+
+	return rate.NewReservation(at, func(ctxt context.Context) error {
+		return g.Refund(ctxt, key, n)
+	}), nil
+}
+
+// update swaps the stored theoretical arrival time of key for the one
+// returned by fn given the current value and time
+// The stored value is only swapped if it is unchanged since it was read,
+// otherwise the value observed is returned by the same transaction and
+// the attempt is made again (see WithRetries)
+// It returns false when fn declines to swap the observed value
+func (g *GCRA) update(ctxt context.Context, key string, fn func(tat, now time.Time) (time.Time, bool)) (swapped bool, err error) {
+	var (
+		tatKey = gcraKey(key)
+		// the key is assumed to be missing until observed otherwise
+		tat      time.Time
+		cmp      = missing(tatKey)
+		observed = false
+	)
+
+	err = g.sem.retry(ctxt, key, func() (bool, error) {
+		for {
+			select {
+			case <-ctxt.Done():
+				return false, ctxt.Err()
+			default:
+			}
+
+			now := now()
+
+			next, ok := fn(tat, now)
+			if !ok {
+				if observed {
+					return true, nil
+				}
+
+				// fn can only decline given the current value
+				next = now
+			}
+
+			opts, err := g.sem.leaseOptions(ctxt, next.Sub(now))
+			if err != nil {
+				return false, err
+			}
+
+			// put a 2 second timeout on the transaction
+			tctxt, cancel := context.WithTimeout(ctxt, 2*time.Second)
+
+			resp, err := g.sem.kv.Txn(tctxt).
+				If(cmp).
+				Then(clientv3.OpPut(tatKey, strconv.FormatInt(next.UnixNano(), 10), opts...)).
+				Else(clientv3.OpGet(tatKey)).
+				Commit()
+			cancel()
+			if err != nil {
+				return false, g.sem.checkLease(err)
+			}
+
+			if resp.Succeeded {
+				swapped = ok
+				return true, nil
+			}
+
+			tat, cmp, err = tatAndCompare(resp.Responses[0].GetResponseRange().Kvs, tatKey)
+			if err != nil {
+				return false, err
+			}
+
+			if observed {
+				// the value changed since it was observed
+				return false, nil
+			}
+
+			// the key was assumed missing rather than observed
+			// so the first attempt is made again with its value
+			observed = true
+		}
+	})
+
+	return swapped, err
+}
+
+// Status returns the budget of key without consuming any of it
+func (g *GCRA) Status(ctxt context.Context, key string) (rate.Status, error) {
+	// put a 1 second timeout on the get operation
+	ctxt, cancel := context.WithTimeout(ctxt, 1*time.Second)
+	defer cancel()
+
+	tatKey := gcraKey(key)
+
+	resp, err := g.sem.kv.Get(ctxt, tatKey)
+	if err != nil {
+		return rate.Status{}, err
+	}
+
+	tat, _, err := tatAndCompare(resp.Kvs, tatKey)
+	if err != nil {
+		return rate.Status{}, err
+	}
+
+	return g.gcra.Status(tat, now()), nil
+}
+
+func gcraKey(key string) string {
+	return fmt.Sprintf("%s/gcra", key)
+}
+
+// tatAndCompare parses the theoretical arrival time found in kvs for the
+// provided key and returns a comparison which only holds while it is unchanged
+func tatAndCompare(kvs []*mvccpb.KeyValue, key string) (time.Time, clientv3.Cmp, error) {
+	if len(kvs) == 0 {
+		return time.Time{}, missing(key), nil
+	}
+
+	nanos, err := strconv.ParseInt(string(kvs[0].Value), 10, 64)
+	if err != nil {
+		return time.Time{}, clientv3.Cmp{}, err
+	}
+
+	return time.Unix(0, nanos).UTC(), clientv3.Compare(clientv3.Value(key), "=", string(kvs[0].Value)), nil
+}
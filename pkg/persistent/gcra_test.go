@@ -0,0 +1,17 @@
+package persistent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GCRA_BadRate(t *testing.T) {
+	_, err := NewGCRA(nil, 0, time.Minute, 1)
+	assert.Equal(t, rate.ErrRateNotPermitted, err)
+
+	_, err = NewGCRA(nil, 10, 0, 1)
+	assert.Equal(t, rate.ErrRateNotPermitted, err)
+}
@@ -0,0 +1,266 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/georgemac/rate/pkg/rate"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// ErrNoLevels is returned when a HierarchicalSemaphore
+// has been configured without any limits
+var ErrNoLevels = errors.New("at least one level limit is required")
+
+// HierarchicalSemaphore is backed by etcd and enforces a limit for
+// every level of a hierarchical key (see rate.JoinHierarchy) per interval
+// e.g. a tenant level limit which is shared by the endpoint level
+// keys beneath it
+// The counts for every level are claimed within a single transaction
+// so a token is either consumed from the whole chain or not at all
+type HierarchicalSemaphore struct {
+	sem    *Semaphore
+	limits []int
+}
+
+// NewHierarchicalSemaphore returns a configured etcd backed HierarchicalSemaphore
+// which implements rate.Acquirer
+// The limits are ordered from root to leaf
+// Keys with more levels than limits are limited at the leaf by their full key
+func NewHierarchicalSemaphore(kv clientv3.KV, limits []int, opts ...Option) *HierarchicalSemaphore {
+	return &HierarchicalSemaphore{
+		sem:    NewSemaphore(kv, 0, opts...),
+		limits: limits,
+	}
+}
+
+// Acquire attempts to acquire a "token" for every level of the key
+func (h *HierarchicalSemaphore) Acquire(ctxt context.Context, key string) (bool, error) {
+	return h.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN attempts to acquire n "tokens" for every level of the key
+// If any level has reached its limit for the current interval false
+// is returned and nothing is claimed
+func (h *HierarchicalSemaphore) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	acquired, _, err := h.acquire(ctxt, key, n)
+	return acquired, err
+}
+
+// ClaimN attempts to acquire n "tokens" for every level of the key (see AcquireN)
+// The returned rate.RefundFunc returns the tokens to the count of every
+// level for the interval they were acquired from rather than that of
+// the current interval, and does nothing once those counts have expired
+func (h *HierarchicalSemaphore) ClaimN(ctxt context.Context, key string, n int) (rate.RefundFunc, bool, error) {
+	acquired, intervals, err := h.acquire(ctxt, key, n)
+
+	return func(ctxt context.Context) error {
+		for _, interval := range intervals {
+			if !now().Before(interval.expires) {
+				// the tokens expired along with the count
+				continue
+			}
+
+			if err := h.sem.refund(ctxt, key, interval.prefix, n); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, acquired, err
+}
+
+// acquire attempts to acquire n "tokens" for every level of the key
+// and returns the interval of every level they were acquired from
+func (h *HierarchicalSemaphore) acquire(ctxt context.Context, key string, n int) (acquired bool, intervals []interval, err error) {
+	select {
+	case <-ctxt.Done():
+		return false, nil, ctxt.Err()
+	default:
+	}
+
+	if len(h.limits) == 0 {
+		return false, nil, ErrNoLevels
+	}
+
+	var (
+		prefixes = h.prefixes(key)
+		gets     = make([]clientv3.Op, 0, len(prefixes))
+	)
+
+	for i, prefix := range prefixes {
+		if n > h.limits[i] {
+			return false, nil, ErrCostExceedsLimit
+		}
+
+		gets = append(gets, clientv3.OpGet(prefix))
+	}
+
+	// every level shares an interval so a single lease will do
+	var (
+		now          = now()
+		_, expiresIn = h.sem.keyer.Key(key)
+	)
+
+	for _, prefix := range prefixes {
+		intervals = append(intervals, interval{prefix, now.Add(expiresIn)})
+	}
+
+	err = h.sem.retry(ctxt, key, func() (bool, error) {
+		// read every level within a single transaction to obtain a
+		// consistent view of the chain, with a 1 second timeout
+		tctxt, cancel := context.WithTimeout(ctxt, 1*time.Second)
+		counts, err := h.sem.kv.Txn(tctxt).Then(gets...).Commit()
+		cancel()
+		if err != nil {
+			return false, err
+		}
+
+		var (
+			cmps = make([]clientv3.Cmp, 0, len(prefixes))
+			vals = make([]int64, 0, len(prefixes))
+		)
+
+		for i, prefix := range prefixes {
+			count, cmp, err := countAndCompare(counts.Responses[i].GetResponseRange().Kvs, prefix)
+			if err != nil {
+				return false, err
+			}
+
+			if count+int64(n) > int64(h.limits[i]) {
+				return true, nil
+			}
+
+			cmps, vals = append(cmps, cmp), append(vals, count+int64(n))
+		}
+
+		opts, err := h.sem.leaseOptions(ctxt, expiresIn)
+		if err != nil {
+			return false, err
+		}
+
+		puts := make([]clientv3.Op, 0, len(prefixes))
+		for i, prefix := range prefixes {
+			puts = append(puts, clientv3.OpPut(prefix, fmt.Sprintf("%d", vals[i]), opts...))
+		}
+
+		// put a 2 second timeout on the put operation
+		tctxt, cancel = context.WithTimeout(ctxt, 2*time.Second)
+		defer cancel()
+
+		resp, err := h.sem.kv.Txn(tctxt).
+			If(cmps...).
+			Then(puts...).
+			Commit()
+		if err != nil {
+			return false, h.sem.checkLease(err)
+		}
+
+		// given a count somewhere in the chain changed the
+		// chain is read and attempted again
+		acquired = resp.Succeeded
+		return acquired, nil
+	})
+
+	return acquired, intervals, err
+}
+
+// Refund returns n previously acquired "tokens" to every level of the key
+// by decrementing their counts for the current interval
+// Tokens acquired from an interval which has since passed are returned
+// to the current interval instead, so ClaimN should be preferred
+func (h *HierarchicalSemaphore) Refund(ctxt context.Context, key string, n int) error {
+	if len(h.limits) == 0 {
+		return ErrNoLevels
+	}
+
+	for _, prefix := range h.prefixes(key) {
+		if err := h.sem.refund(ctxt, key, prefix, n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Status returns the budget of the most restrictive level of the key
+// for the current interval without consuming any of it
+func (h *HierarchicalSemaphore) Status(ctxt context.Context, key string) (rate.Status, error) {
+	if len(h.limits) == 0 {
+		return rate.Status{}, ErrNoLevels
+	}
+
+	var (
+		prefixes     = h.prefixes(key)
+		_, expiresIn = h.sem.keyer.Key(key)
+		gets         = make([]clientv3.Op, 0, len(prefixes))
+		result       rate.Status
+	)
+
+	for _, prefix := range prefixes {
+		gets = append(gets, clientv3.OpGet(prefix))
+	}
+
+	// put a 1 second timeout on the get operations
+	tctxt, cancel := context.WithTimeout(ctxt, 1*time.Second)
+	defer cancel()
+
+	counts, err := h.sem.kv.Txn(tctxt).Then(gets...).Commit()
+	if err != nil {
+		return rate.Status{}, err
+	}
+
+	for i, prefix := range prefixes {
+		count, _, err := countAndCompare(counts.Responses[i].GetResponseRange().Kvs, prefix)
+		if err != nil {
+			return rate.Status{}, err
+		}
+
+		level := status(h.limits[i], float64(count), expiresIn)
+		if i == 0 {
+			result = level
+			continue
+		}
+
+		result = result.Min(level)
+	}
+
+	return result, nil
+}
+
+// prefixes returns the interval key of every level of key
+func (h *HierarchicalSemaphore) prefixes(key string) []string {
+	keys := rate.SplitHierarchy(key)
+	if len(keys) > len(h.limits) {
+		// limit the leaf level by the full key
+		keys = append(keys[:len(h.limits)-1], key)
+	}
+
+	prefixes := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefix, _ := h.sem.keyer.Key(key)
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes
+}
+
+// countAndCompare parses the count found in kvs for the provided key
+// and returns a comparison which only holds while the count is unchanged
+func countAndCompare(kvs []*mvccpb.KeyValue, key string) (int64, clientv3.Cmp, error) {
+	if len(kvs) == 0 {
+		// a missing key is effectively zero
+		return 0, missing(key), nil
+	}
+
+	count, err := strconv.ParseInt(string(kvs[0].Value), 10, 64)
+	if err != nil {
+		return 0, clientv3.Cmp{}, err
+	}
+
+	return count, clientv3.Compare(clientv3.Value(key), "=", fmt.Sprintf("%d", count)), nil
+}
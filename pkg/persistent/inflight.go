@@ -0,0 +1,166 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// inflightTTL is the ttl in seconds of the lease granted per request
+// It is kept alive until the request is released so it only bounds
+// how long the token of a crashed instance is held
+const inflightTTL = 10
+
+// ErrLeaseRequired is returned by an InflightSemaphore which
+// has been configured without a lease (see WithLease)
+var ErrLeaseRequired = errors.New("lease required to limit inflight requests")
+
+// InflightSemaphore is backed by etcd and limits the number of
+// requests inflight per key rather than the number started per interval
+// Every request puts its own key beneath the limited key which is
+// attached to a lease granted for the request
+// The request is permitted when the sum of the costs of the requests
+// created at or before it is within the limit
+// Releasing the request revokes the lease, which deletes its key
+// and returns its tokens
+type InflightSemaphore struct {
+	sem *Semaphore
+}
+
+// NewInflightSemaphore returns a configured etcd backed InflightSemaphore
+// which implements rate.Leaser
+// It must be configured using WithLease, other options do not apply
+func NewInflightSemaphore(kv clientv3.KV, limit int, opts ...Option) *InflightSemaphore {
+	return &InflightSemaphore{sem: NewSemaphore(kv, limit, opts...)}
+}
+
+// Acquire attempts to acquire a "token" for the provided key
+// The token is held until its lease expires as it cannot be released
+func (s *InflightSemaphore) Acquire(ctxt context.Context, key string) (bool, error) {
+	return s.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN attempts to acquire n "tokens" for the provided key
+// The tokens are held until their lease expires as they cannot be released
+func (s *InflightSemaphore) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	_, acquired, err := s.claim(ctxt, key, n)
+	return acquired, err
+}
+
+// Lease attempts to acquire n "tokens" for the provided key
+// The lease of the request is kept alive until the returned
+// Lease is released
+func (s *InflightSemaphore) Lease(ctxt context.Context, key string, n int) (rate.Lease, bool, error) {
+	id, acquired, err := s.claim(ctxt, key, n)
+	if err != nil || !acquired {
+		return nil, acquired, err
+	}
+
+	// the lease must outlive the context of the acquisition
+	kctxt, cancel := context.WithCancel(context.Background())
+
+	responses, err := s.sem.lease.KeepAlive(kctxt, id)
+	if err != nil {
+		cancel()
+		s.revoke(id)
+		return nil, false, err
+	}
+
+	go func() {
+		// responses must be consumed until the keep alive is cancelled
+		for range responses {
+		}
+	}()
+
+	return rate.ReleaseOnce(func(ctxt context.Context) error {
+		cancel()
+
+		_, err := s.sem.lease.Revoke(ctxt, id)
+		return err
+	}), true, nil
+}
+
+// claim puts a key for the request attached to a newly granted lease
+// and returns true if the request is within the limit
+// Requests which are not within the limit have their lease revoked
+func (s *InflightSemaphore) claim(ctxt context.Context, key string, n int) (clientv3.LeaseID, bool, error) {
+	if n > s.sem.limit {
+		return 0, false, ErrCostExceedsLimit
+	}
+
+	if s.sem.lease == nil {
+		return 0, false, ErrLeaseRequired
+	}
+
+	select {
+	case <-ctxt.Done():
+		return 0, false, ctxt.Err()
+	default:
+	}
+
+	// put a 2 second timeout on claiming the request
+	tctxt, cancel := context.WithTimeout(ctxt, 2*time.Second)
+	defer cancel()
+
+	grant, err := s.sem.lease.Grant(tctxt, inflightTTL)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var (
+		prefix     = inflightPrefix(key)
+		requestKey = fmt.Sprintf("%s%x", prefix, int64(grant.ID))
+	)
+
+	put, err := s.sem.kv.Put(tctxt, requestKey, strconv.Itoa(n), clientv3.WithLease(grant.ID))
+	if err != nil {
+		s.revoke(grant.ID)
+		return 0, false, err
+	}
+
+	// only requests created at or before this one are
+	// considered so that later requests cannot deny it
+	resp, err := s.sem.kv.Get(tctxt, prefix,
+		clientv3.WithPrefix(),
+		clientv3.WithMaxCreateRev(put.Header.Revision))
+	if err != nil {
+		s.revoke(grant.ID)
+		return 0, false, err
+	}
+
+	var inflight int64
+	for _, kv := range resp.Kvs {
+		cost, err := strconv.ParseInt(string(kv.Value), 10, 64)
+		if err != nil {
+			s.revoke(grant.ID)
+			return 0, false, err
+		}
+
+		inflight += cost
+	}
+
+	if inflight > int64(s.sem.limit) {
+		return 0, false, s.revoke(grant.ID)
+	}
+
+	return grant.ID, true, nil
+}
+
+// revoke revokes the provided lease using a fresh context
+// as the context of the request may already be cancelled
+func (s *InflightSemaphore) revoke(id clientv3.LeaseID) error {
+	ctxt, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := s.sem.lease.Revoke(ctxt, id)
+	return err
+}
+
+func inflightPrefix(key string) string {
+	return fmt.Sprintf("%s/inflight/", key)
+}
@@ -0,0 +1,38 @@
+package persistent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IntervalKeyer(t *testing.T) {
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	var (
+		start = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		keyer = IntervalKeyer(time.Minute)
+	)
+
+	for _, testCase := range []struct {
+		name    string
+		elapsed time.Duration
+		ttl     time.Duration
+	}{
+		{name: "start of interval", elapsed: 0, ttl: time.Minute},
+		{name: "within interval", elapsed: 15 * time.Second, ttl: 45 * time.Second},
+		{name: "end of interval", elapsed: time.Minute - time.Nanosecond, ttl: time.Nanosecond},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			now = func() time.Time { return start.Add(testCase.elapsed) }
+
+			key, ttl := keyer.Key("foo")
+
+			assert.Equal(t, "foo/2019-05-03T12:00:00", key)
+			// the key expires at the start of the next interval
+			// rather than the start of the current one
+			assert.Equal(t, testCase.ttl, ttl)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package persistent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/go-kit/kit/metrics"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// minLeaseTTL is the minimum ttl etcd permits for a lease
+const minLeaseTTL = 5 * time.Second
+
+// leaseCache shares a lease between every key which expires within
+// the same second, rather than granting a lease for every put
+// Keys of the same interval expire at the same time so a single
+// lease is granted per interval and reused until it expires
+type leaseCache struct {
+	lease clientv3.Lease
+
+	mu sync.Mutex
+	// leases are keyed by the unix second in which they expire
+	leases map[int64]*cachedLease
+
+	granted, saved metrics.Counter
+}
+
+// cachedLease is a lease which is granted once and then shared
+// ready is closed once the grant has completed
+type cachedLease struct {
+	ready chan struct{}
+	id    clientv3.LeaseID
+	err   error
+}
+
+func newLeaseCache(lease clientv3.Lease, granted, saved metrics.Counter) *leaseCache {
+	return &leaseCache{
+		lease:   lease,
+		leases:  map[int64]*cachedLease{},
+		granted: granted,
+		saved:   saved,
+	}
+}
+
+// get returns the lease shared by keys which expire in ttl
+// The lease expires at most a second after ttl has passed and
+// never before, or after minLeaseTTL given ttl is shorter
+// Concurrent callers wait for the same lease to be granted, which is
+// granted independently of the context of any one caller
+// Callers whose shared grant failed attempt the grant themselves
+func (c *leaseCache) get(ctxt context.Context, ttl time.Duration) (clientv3.LeaseID, error) {
+	for {
+		var (
+			now    = now()
+			expiry = now.Add(ttl)
+		)
+
+		if min := now.Add(minLeaseTTL); expiry.Before(min) {
+			expiry = min
+		}
+
+		// round up so that keys never expire before they are due to
+		at := expiry.Unix()
+		if expiry.Nanosecond() > 0 {
+			at++
+		}
+
+		c.mu.Lock()
+		cached, ok := c.leases[at]
+		if !ok {
+			cached = &cachedLease{ready: make(chan struct{})}
+			c.leases[at] = cached
+			c.evict(now)
+		}
+		c.mu.Unlock()
+
+		if !ok {
+			return c.grant(cached, at, at-now.Unix())
+		}
+
+		select {
+		case <-cached.ready:
+		case <-ctxt.Done():
+			return 0, ctxt.Err()
+		}
+
+		if cached.err != nil {
+			// the failed grant has been forgotten
+			// so it is attempted again
+			continue
+		}
+
+		c.saved.Add(1)
+
+		return cached.id, nil
+	}
+}
+
+// grant grants the lease cached to expire at the unix second at
+// in ttl seconds and signals those waiting for it once granted
+// The lease is shared so it is granted using a context of its own
+// rather than that of the caller, which may be cancelled meanwhile
+func (c *leaseCache) grant(cached *cachedLease, at, ttl int64) (clientv3.LeaseID, error) {
+	// put a 2 second timeout on the grant
+	ctxt, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	resp, err := c.lease.Grant(ctxt, ttl)
+	if err != nil {
+		// forget the failed grant so that it is attempted again
+		c.mu.Lock()
+		if c.leases[at] == cached {
+			delete(c.leases, at)
+		}
+		c.mu.Unlock()
+
+		cached.err = err
+		close(cached.ready)
+
+		return 0, err
+	}
+
+	c.granted.Add(1)
+
+	cached.id = resp.ID
+	close(cached.ready)
+
+	return cached.id, nil
+}
+
+// evict forgets every lease which can no longer be returned by get
+// as it expires sooner than the minimum ttl from now
+// It must be called with the lock held
+func (c *leaseCache) evict(now time.Time) {
+	min := now.Add(minLeaseTTL).Unix()
+	for at := range c.leases {
+		if at < min {
+			delete(c.leases, at)
+		}
+	}
+}
+
+// check forgets every cached lease given err reports that a lease
+// could not be found, e.g. as it was revoked, so that subsequent
+// puts are attached to newly granted leases
+// It returns err unchanged
+func (c *leaseCache) check(err error) error {
+	if err == rpctypes.ErrLeaseNotFound {
+		c.mu.Lock()
+		c.leases = map[int64]*cachedLease{}
+		c.mu.Unlock()
+	}
+
+	return err
+}
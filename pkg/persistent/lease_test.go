@@ -0,0 +1,215 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// grantingLease is a clientv3.Lease which records the ttl of every grant
+type grantingLease struct {
+	clientv3.Lease
+
+	mu   gosync.Mutex
+	ttls []int64
+	err  error
+}
+
+func (g *grantingLease) Grant(_ context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	g.ttls = append(g.ttls, ttl)
+
+	return &clientv3.LeaseGrantResponse{ID: clientv3.LeaseID(len(g.ttls)), TTL: ttl}, nil
+}
+
+func Test_leaseCache(t *testing.T) {
+	when := time.Date(2019, 5, 3, 12, 0, 30, 500*int(time.Millisecond), time.UTC)
+	now = func() time.Time { return when }
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	var (
+		lease   = &grantingLease{}
+		granted = generic.NewCounter("granted")
+		saved   = generic.NewCounter("saved")
+		cache   = newLeaseCache(lease, granted, saved)
+		ctxt    = context.Background()
+	)
+
+	get := func(ttl time.Duration, expected clientv3.LeaseID) {
+		t.Helper()
+
+		id, err := cache.get(ctxt, ttl)
+		require.Nil(t, err)
+		assert.Equal(t, expected, id)
+	}
+
+	// the end of the current minute is shared by every key of the interval
+	// and the lease is rounded up to the second so it never expires early
+	get(29500*time.Millisecond, 1)
+	assert.Equal(t, []int64{30}, lease.ttls)
+
+	when = when.Add(10 * time.Second)
+	get(19500*time.Millisecond, 1)
+	get(19500*time.Millisecond, 1)
+
+	// ttls below the minimum are extended to it
+	// and then rounded up to the second
+	get(time.Second, 2)
+	get(2*time.Second, 2)
+	assert.Equal(t, []int64{30, 6}, lease.ttls)
+
+	assert.Equal(t, float64(2), granted.Value())
+	assert.Equal(t, float64(3), saved.Value())
+
+	// leases which expire sooner than the minimum are evicted
+	when = when.Add(17 * time.Second)
+	get(3*time.Second, 3)
+	assert.Len(t, cache.leases, 1)
+
+	// leases reported missing are forgotten
+	assert.Equal(t, rpctypes.ErrLeaseNotFound, cache.check(rpctypes.ErrLeaseNotFound))
+	get(3*time.Second, 4)
+
+	// failed grants are not cached
+	lease.err = errors.New("unavailable")
+	_, err := cache.get(ctxt, time.Minute)
+	assert.Equal(t, lease.err, err)
+
+	lease.err = nil
+	get(time.Minute, 5)
+}
+
+func Test_leaseCache_Concurrent(t *testing.T) {
+	var (
+		lease = &grantingLease{}
+		cache = newLeaseCache(lease, generic.NewCounter("granted"), generic.NewCounter("saved"))
+		wg    gosync.WaitGroup
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := cache.get(context.Background(), time.Hour)
+			assert.Nil(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	// concurrent puts which expire together wait for a single grant
+	assert.Len(t, lease.ttls, 1)
+}
+
+// blockingLease is a clientv3.Lease whose first grant blocks until
+// release is closed and then fails given its context was cancelled
+// or fail is set
+type blockingLease struct {
+	clientv3.Lease
+
+	fail             bool
+	started, release chan struct{}
+
+	mu    gosync.Mutex
+	calls int
+}
+
+func (b *blockingLease) Grant(ctxt context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	b.mu.Lock()
+	b.calls++
+	calls := b.calls
+	b.mu.Unlock()
+
+	if calls == 1 {
+		close(b.started)
+		<-b.release
+
+		if err := ctxt.Err(); err != nil {
+			return nil, err
+		}
+
+		if b.fail {
+			return nil, errors.New("unavailable")
+		}
+	}
+
+	return &clientv3.LeaseGrantResponse{ID: clientv3.LeaseID(calls), TTL: ttl}, nil
+}
+
+func Test_leaseCache_Waiters(t *testing.T) {
+	for _, testCase := range []struct {
+		name string
+		// fail the first grant
+		fail bool
+		// the lease expected by the caller which granted
+		// first and by the caller waiting for it
+		granter, waiter clientv3.LeaseID
+		granterErr      bool
+	}{
+		{name: "granter cancelled", granter: 1, waiter: 1},
+		{name: "grant failed", fail: true, granterErr: true, waiter: 2},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			var (
+				lease = &blockingLease{
+					fail:    testCase.fail,
+					started: make(chan struct{}),
+					release: make(chan struct{}),
+				}
+				cache        = newLeaseCache(lease, generic.NewCounter("granted"), generic.NewCounter("saved"))
+				ctxt, cancel = context.WithCancel(context.Background())
+				granter      = make(chan error)
+				waiter       = make(chan clientv3.LeaseID)
+			)
+
+			go func() {
+				id, err := cache.get(ctxt, time.Hour)
+				if err == nil {
+					assert.Equal(t, testCase.granter, id)
+				}
+
+				granter <- err
+			}()
+
+			<-lease.started
+
+			go func() {
+				id, err := cache.get(context.Background(), time.Hour)
+				assert.Nil(t, err)
+
+				waiter <- id
+			}()
+
+			// give the waiter time to wait for the grant
+			time.Sleep(10 * time.Millisecond)
+
+			// the caller which granted goes away
+			cancel()
+			close(lease.release)
+
+			if testCase.granterErr {
+				assert.NotNil(t, <-granter)
+			} else {
+				assert.Nil(t, <-granter)
+			}
+
+			// waiters never observe the failure of another
+			assert.Equal(t, testCase.waiter, <-waiter)
+		})
+	}
+}
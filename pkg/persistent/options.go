@@ -1,6 +1,11 @@
 package persistent
 
-import "go.etcd.io/etcd/clientv3"
+import (
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"go.etcd.io/etcd/clientv3"
+)
 
 // Option is a functional option for *Semaphore
 type Option func(*Semaphore)
@@ -29,3 +34,68 @@ func WithLease(lease clientv3.Lease) Option {
 		s.lease = lease
 	}
 }
+
+// WithLeaseCounters configures counters which are incremented whenever
+// a lease is granted and whenever a put reuses a lease granted earlier
+// instead of being granted its own
+func WithLeaseCounters(granted, saved metrics.Counter) Option {
+	return func(s *Semaphore) {
+		s.leaseGrants = granted
+		s.leaseGrantsSaved = saved
+	}
+}
+
+// WithRetries configures the number of attempts made at a transaction
+// which conflicts with concurrent updates to the same keys, after which
+// ErrContention is returned
+// Each retry waits a random duration of up to backoff, doubled for every
+// previous retry, so that contending replicas spread out their attempts
+// Defaults to 10 attempts and a backoff of 5ms
+func WithRetries(maxAttempts int, backoff time.Duration) Option {
+	return func(s *Semaphore) {
+		if maxAttempts < 1 {
+			maxAttempts = 1
+		}
+
+		s.maxAttempts = maxAttempts
+		s.backoff = backoff
+	}
+}
+
+// WithContentionCounters configures counters which are incremented, with
+// a "key" label, whenever a transaction conflicts with concurrent updates
+// and whenever a conflicting transaction is attempted again
+func WithContentionCounters(conflicts, retries metrics.Counter) Option {
+	return func(s *Semaphore) {
+		s.conflicts = conflicts
+		s.retries = retries
+	}
+}
+
+// WithSchemaVersion configures the schema version of the keys written
+// and read, which defaults to 0, the layout written before keys were
+// versioned which has no version prefix
+// Instances of different versions keep their counts apart, so while a
+// rollout moves instances onto a new version each version permits the
+// limit independently and a key may be permitted up to twice its limit
+func WithSchemaVersion(version int) Option {
+	return func(s *Semaphore) {
+		if version < 0 {
+			version = 0
+		}
+
+		s.version = version
+	}
+}
+
+// WithSlidingWindow configures the Semaphore as a sliding window counter
+// The limit is enforced over a window which slides with time by
+// weighting the count of the previous interval by the proportion of
+// it which still overlaps the window
+// It overrides any configured Keyer with IntervalKeyer(window)
+func WithSlidingWindow(window time.Duration) Option {
+	return func(s *Semaphore) {
+		s.window = window
+		s.keyer = IntervalKeyer(window)
+	}
+}
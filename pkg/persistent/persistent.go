@@ -4,17 +4,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"time"
 
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
 	"go.etcd.io/etcd/clientv3"
 )
 
+// reserveIntervals is the number of intervals, including the current
+// interval, from which Semaphore.ReserveN reserves tokens
+const reserveIntervals = 10
+
 var (
 	now = func() time.Time { return time.Now().UTC() }
 
 	// errKeyNotFound is returned when a key cannot be found within etcd
 	errKeyNotFound = errors.New("key not found")
+
+	// ErrCostExceedsLimit is returned by AcquireN when more tokens are
+	// requested at once than the limit would ever permit
+	ErrCostExceedsLimit = rate.ErrCostExceedsLimit
 )
 
 // Keyer generates a key string suitable for current interval in time for a provided key
@@ -33,14 +45,29 @@ func (fn KeyerFunc) Key(key string) (string, time.Duration) { return fn(key) }
 // Every duration in interval the keyer will return the next
 // interval timestamp in the key
 func IntervalKeyer(dur time.Duration) Keyer {
-	return KeyerFunc(func(key string) (string, time.Duration) {
-		var (
-			when        = now().Truncate(dur)
-			intervalKey = fmt.Sprintf("%s/%s", key, when.Format("2006-01-02T15:04:05.999999999"))
-		)
+	return intervalKeyer(dur)
+}
 
-		return intervalKey, time.Until(when)
-	})
+// intervalKeyer is the Keyer returned by IntervalKeyer
+// Semaphores which know their interval can reserve
+// tokens from the intervals which follow
+type intervalKeyer time.Duration
+
+// Key returns the key for the current interval and
+// how long until the next interval begins
+func (i intervalKeyer) Key(key string) (string, time.Duration) {
+	var (
+		now  = now()
+		when = now.Truncate(time.Duration(i))
+	)
+
+	// the key expires at the start of the next interval
+	return intervalKey(key, when), when.Add(time.Duration(i)).Sub(now)
+}
+
+// intervalKey returns the key for the interval beginning at when
+func intervalKey(key string, when time.Time) string {
+	return fmt.Sprintf("%s/%s", key, when.Format("2006-01-02T15:04:05.999999999"))
 }
 
 // Semaphore is a type which is backed by etcd key-value store
@@ -49,21 +76,51 @@ func IntervalKeyer(dur time.Duration) Keyer {
 type Semaphore struct {
 	kv    clientv3.KV
 	lease clientv3.Lease
+	// leases shares a lease between keys which expire together
+	leases *leaseCache
+
+	leaseGrants, leaseGrantsSaved metrics.Counter
+
+	// maxAttempts bounds the attempts made at each transaction
+	// which conflicts with concurrent updates (see WithRetries)
+	maxAttempts int
+	backoff     time.Duration
+
+	conflicts, retries metrics.Counter
+
+	// version is the schema version of the keys written
+	version int
 
 	limit int
 	keyer Keyer
+
+	// window is set when operating as a sliding window counter
+	window time.Duration
 }
 
 // NewSemaphore returns a configured etcd backed Semaphore which implements rate.Acquirer
 func NewSemaphore(kv clientv3.KV, limit int, opts ...Option) *Semaphore {
 	s := &Semaphore{
-		kv:    kv,
-		limit: limit,
-		keyer: IntervalKeyer(1 * time.Minute),
+		kv:               kv,
+		limit:            limit,
+		keyer:            IntervalKeyer(1 * time.Minute),
+		leaseGrants:      discard.NewCounter(),
+		leaseGrantsSaved: discard.NewCounter(),
+		maxAttempts:      defaultMaxAttempts,
+		backoff:          defaultBackoff,
+		conflicts:        discard.NewCounter(),
+		retries:          discard.NewCounter(),
+		version:          0,
 	}
 
 	Options(opts).Apply(s)
 
+	s.kv = versioned(s.kv, s.version)
+
+	if s.lease != nil {
+		s.leases = newLeaseCache(s.lease, s.leaseGrants, s.leaseGrantsSaved)
+	}
+
 	return s
 }
 
@@ -73,79 +130,299 @@ func NewSemaphore(kv clientv3.KV, limit int, opts ...Option) *Semaphore {
 // If the limit has been reached for this current interval this method
 // returns false and the caller should try again later
 func (s *Semaphore) Acquire(ctxt context.Context, key string) (bool, error) {
+	return s.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN attempts to acquire n "tokens" within etcd for the provided key
+// The tokens are claimed in a single transaction so either all n are
+// acquired and true is returned, or none are and false is returned
+func (s *Semaphore) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	acquired, _, _, err := s.acquire(ctxt, key, n)
+	return acquired, err
+}
+
+// ClaimN attempts to acquire n "tokens" for the provided key (see AcquireN)
+// The returned rate.RefundFunc returns the tokens to the count of the
+// interval they were acquired from rather than that of the current
+// interval, and does nothing once that count has expired
+func (s *Semaphore) ClaimN(ctxt context.Context, key string, n int) (rate.RefundFunc, bool, error) {
+	acquired, interval, _, err := s.acquire(ctxt, key, n)
+
+	return func(ctxt context.Context) error {
+		if !now().Before(interval.expires) {
+			// the tokens expired along with the count
+			return nil
+		}
+
+		return s.refund(ctxt, key, interval.prefix, n)
+	}, acquired, err
+}
+
+// interval is the count tokens were acquired from and when it expires
+type interval struct {
+	prefix  string
+	expires time.Time
+}
+
+// acquire attempts to acquire n "tokens" for the provided key and
+// returns the interval the tokens were acquired from along with the
+// status of the key observed when doing so, which accounts for the
+// tokens acquired
+func (s *Semaphore) acquire(ctxt context.Context, key string, n int) (bool, interval, rate.Status, error) {
+	if n > s.limit {
+		return false, interval{}, rate.Status{}, ErrCostExceedsLimit
+	}
+
 	select {
 	case <-ctxt.Done():
-		return false, ctxt.Err()
+		return false, interval{}, rate.Status{}, ctxt.Err()
 	default:
 	}
 
+	if s.window > 0 {
+		return s.acquireSliding(ctxt, key, n)
+	}
+
 	var (
+		now               = now()
 		prefix, expiresIn = s.keyer.Key(key)
-		count, err        = s.getInt64(ctxt, prefix)
-		countChanged      = clientv3.Compare(clientv3.Value(prefix), "=", fmt.Sprintf("%d", count))
 	)
 
-	if err != nil {
-		if err != errKeyNotFound {
+	claimed, count, err := s.claim(ctxt, key, prefix, n, expiresIn)
+	return claimed, interval{prefix, now.Add(expiresIn)}, status(s.limit, float64(count), expiresIn), err
+}
+
+// claim attempts to claim n "tokens" for key from the count stored at
+// prefix which expires after expiresIn
+// The count is returned, including the n tokens given they were claimed
+// The claim is attempted again whenever the count changes between being
+// read and claimed, up to the configured maximum attempts (see WithRetries)
+func (s *Semaphore) claim(ctxt context.Context, key, prefix string, n int, expiresIn time.Duration) (claimed bool, observed int64, err error) {
+	err = s.retry(ctxt, key, func() (bool, error) {
+		var (
+			count, err   = s.getInt64(ctxt, prefix)
+			countChanged = clientv3.Compare(clientv3.Value(prefix), "=", fmt.Sprintf("%d", count))
+		)
+
+		if err != nil {
+			if err != errKeyNotFound {
+				return false, err
+			}
+
+			// if the key was not found then the count is
+			// effectively zero but we must adjust our
+			// comparison in the claim transaction slightly
+			// to account for it being missing rather than zero
+			countChanged = clientv3.Compare(clientv3.Version(prefix), "=", 0)
+		}
+
+		observed = count
+		if count+int64(n) > int64(s.limit) {
+			return true, nil
+		}
+
+		// put a 2 second timeout on the put operation
+		tctxt, cancel := context.WithTimeout(ctxt, 2*time.Second)
+		defer cancel()
+
+		put, err := s.putWithLease(ctxt, prefix, fmt.Sprintf("%d", count+int64(n)), expiresIn)
+		if err != nil {
+			return false, err
+		}
+
+		resp, err := s.kv.Txn(tctxt).
+			If(countChanged).
+			Then(put).
+			Commit()
+		if err != nil {
+			return false, s.checkLease(err)
+		}
+
+		// given the count has changed since it was read the
+		// claim is not done and it is read and attempted again
+		if claimed = resp.Succeeded; claimed {
+			observed = count + int64(n)
+		}
+
+		return claimed, nil
+	})
+
+	return claimed, observed, err
+}
+
+// Refund returns n previously acquired "tokens" for the provided key
+// by decrementing the count for the current interval
+// Tokens acquired from an interval which has since passed are returned
+// to the current interval instead, so ClaimN should be preferred
+func (s *Semaphore) Refund(ctxt context.Context, key string, n int) error {
+	prefix, _ := s.keyer.Key(key)
+
+	return s.refund(ctxt, key, prefix, n)
+}
+
+// refund returns n "tokens" for key to the count stored at prefix
+func (s *Semaphore) refund(ctxt context.Context, key, prefix string, n int) error {
+	return s.retry(ctxt, key, func() (bool, error) {
+		count, err := s.getInt64(ctxt, prefix)
+		if err != nil {
+			if err == errKeyNotFound {
+				return true, nil
+			}
+
 			return false, err
 		}
 
-		// if the key was not found then the count is
-		// effectively zero but we must adjust our
-		// comparison in the claim transaction slightly
-		// to account for it being missing rather than zero
-		countChanged = clientv3.Compare(clientv3.Version(prefix), "=", 0)
+		if count <= 0 {
+			return true, nil
+		}
+
+		returned := n
+		if count < int64(returned) {
+			// never return more than has been claimed
+			returned = int(count)
+		}
+
+		// put a 2 second timeout on the put operation
+		tctxt, cancel := context.WithTimeout(ctxt, 2*time.Second)
+		defer cancel()
+
+		resp, err := s.kv.Txn(tctxt).
+			If(clientv3.Compare(clientv3.Value(prefix), "=", fmt.Sprintf("%d", count))).
+			// retain the lease attached when the key was first claimed
+			Then(clientv3.OpPut(prefix, fmt.Sprintf("%d", count-int64(returned)), clientv3.WithIgnoreLease())).
+			Commit()
+		if err != nil {
+			return false, err
+		}
+
+		// the count changed since it was read so try again
+		return resp.Succeeded, nil
+	})
+}
+
+// Reserve reserves a "token" for the provided key (see ReserveN)
+func (s *Semaphore) Reserve(ctxt context.Context, key string) (rate.Reservation, error) {
+	return s.ReserveN(ctxt, key, 1)
+}
+
+// ReserveN reserves n "tokens" for the provided key from the first
+// interval with capacity, starting with the current interval and
+// looking at most reserveIntervals ahead
+// The returned reservation may be used from the start of the interval
+// and cancelling it returns the tokens to that interval
+// The reservation is not OK given no interval has capacity
+// It is only supported by a Semaphore configured with an IntervalKeyer
+func (s *Semaphore) ReserveN(ctxt context.Context, key string, n int) (rate.Reservation, error) {
+	interval, ok := s.keyer.(intervalKeyer)
+	if !ok || s.window > 0 {
+		return rate.Reservation{}, rate.ErrReserveNotSupported
 	}
 
-	if count >= int64(s.limit) {
-		return false, nil
+	if n > s.limit {
+		return rate.Reservation{}, ErrCostExceedsLimit
 	}
 
-	// put a 2 second timeout on the put operation
-	tctxt, cancel := context.WithTimeout(ctxt, 2*time.Second)
-	defer cancel()
+	var (
+		dur   = time.Duration(interval)
+		now   = now()
+		start = now.Truncate(dur)
+	)
 
-	put, err := s.putWithLease(ctxt, prefix, fmt.Sprintf("%d", count+1), expiresIn)
-	if err != nil {
-		return false, err
+	for i := 0; i < reserveIntervals; i++ {
+		var (
+			from   = start.Add(time.Duration(i) * dur)
+			prefix = intervalKey(key, from)
+		)
+
+		claimed, _, err := s.claim(ctxt, key, prefix, n, from.Add(dur).Sub(now))
+		if err != nil {
+			return rate.Reservation{}, err
+		}
+
+		if !claimed {
+			continue
+		}
+
+		if from.Before(now) {
+			from = now
+		}
+
+		return rate.NewReservation(from, func(ctxt context.Context) error {
+			return s.refund(ctxt, key, prefix, n)
+		}), nil
 	}
 
-	resp, err := s.kv.Txn(tctxt).
-		If(countChanged).
-		Then(put).
-		Commit()
-	if err != nil {
-		return false, err
+	return rate.Reservation{}, nil
+}
+
+// Status returns the budget of the provided key for the current interval
+// without consuming any of it
+// The key regains its full budget once the interval expires
+func (s *Semaphore) Status(ctxt context.Context, key string) (rate.Status, error) {
+	if s.window > 0 {
+		return s.statusSliding(ctxt, key)
 	}
 
-	if !resp.Succeeded {
-		// a claim was not successful
-		// this is the claimPrefix count has changed so we
-		// attempt again until the limit is reached or we
-		// are successful
-		return s.Acquire(ctxt, key)
+	prefix, expiresIn := s.keyer.Key(key)
+
+	count, err := s.getInt64(ctxt, prefix)
+	if err != nil && err != errKeyNotFound {
+		return rate.Status{}, err
 	}
 
-	return true, nil
+	return status(s.limit, float64(count), expiresIn), nil
 }
 
-func (s *Semaphore) putWithLease(ctxt context.Context, key, val string, ttl time.Duration) (clientv3.Op, error) {
-	if s.lease == nil {
-		return clientv3.OpPut(key, val), nil
+// status returns the status of a key of which count tokens
+// have been acquired of limit, which resets in reset
+func status(limit int, count float64, reset time.Duration) rate.Status {
+	remaining := limit - int(math.Ceil(count))
+	if remaining < 0 {
+		remaining = 0
 	}
 
-	// ttl in etcd is in seconds and the minimum is 5
-	leaseTTL := int64(ttl / time.Second)
-	if leaseTTL < 5 {
-		leaseTTL = 5
+	if reset < 0 {
+		reset = 0
 	}
 
-	resp, err := s.lease.Grant(ctxt, leaseTTL)
+	return rate.Status{Limit: limit, Remaining: remaining, Reset: reset}
+}
+
+func (s *Semaphore) putWithLease(ctxt context.Context, key, val string, ttl time.Duration) (clientv3.Op, error) {
+	opts, err := s.leaseOptions(ctxt, ttl)
 	if err != nil {
 		return clientv3.Op{}, err
 	}
 
-	return clientv3.OpPut(key, val, clientv3.WithLease(resp.ID)), nil
+	return clientv3.OpPut(key, val, opts...), nil
+}
+
+// leaseOptions returns the options required to attach a lease which
+// expires after the provided ttl to a put, given the Semaphore has been
+// configured with a lease
+// The lease is shared with every other key which expires at the same time
+// so it is only granted by the first put of each interval
+func (s *Semaphore) leaseOptions(ctxt context.Context, ttl time.Duration) ([]clientv3.OpOption, error) {
+	if s.leases == nil {
+		return nil, nil
+	}
+
+	id, err := s.leases.get(ctxt, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return []clientv3.OpOption{clientv3.WithLease(id)}, nil
+}
+
+// checkLease returns err having forgotten any cached leases
+// given err reports that a lease was not found
+func (s *Semaphore) checkLease(err error) error {
+	if s.leases == nil {
+		return err
+	}
+
+	return s.leases.check(err)
 }
 
 func (s *Semaphore) getInt64(ctxt context.Context, key string) (int64, error) {
@@ -4,13 +4,18 @@ package persistent
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/go-kit/kit/metrics/generic"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/namespace"
 )
 
 var addresses = os.Getenv("ETCD_ADDRESSES")
@@ -89,3 +94,636 @@ func Test_Acquire_Expiration(t *testing.T) {
 	// third attempt should return false as the limit is 2
 	failedAttempt()
 }
+
+func Test_Refund(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		keyer             = staticKeyer(time.Now().Format("2006-01-02T15:04:05.9999999"))
+		sem               = NewSemaphore(clientv3.NewKV(cli), 1, WithKeyer(keyer))
+		ctxt              = context.Background()
+		successfulAttempt = func() { attemptIsSuccessful(t, sem, ctxt, "/foo") }
+		failedAttempt     = func() { attemptIsUnsuccessful(t, sem, ctxt, "/foo") }
+	)
+
+	successfulAttempt()
+	failedAttempt()
+
+	assert.Nil(t, sem.Refund(ctxt, "/foo", 1))
+
+	// the refunded token can be acquired again
+	successfulAttempt()
+	failedAttempt()
+}
+
+func Test_ClaimN(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		when = time.Date(2019, 5, 3, 12, 0, 59, 0, time.UTC)
+		sem  = NewSemaphore(clientv3.NewKV(cli), 1, WithKeyer(IntervalKeyer(time.Minute)))
+		ctxt = context.Background()
+		// unique key so that previous runs are not observed
+		key               = fmt.Sprintf("/claim/%d", time.Now().UnixNano())
+		successfulAttempt = func() { attemptIsSuccessful(t, sem, ctxt, key) }
+		failedAttempt     = func() { attemptIsUnsuccessful(t, sem, ctxt, key) }
+	)
+
+	now = func() time.Time { return when }
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	refund, acquired, err := sem.ClaimN(ctxt, key, 1)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// refunds within the interval return the tokens to it
+	require.Nil(t, refund(ctxt))
+	successfulAttempt()
+	failedAttempt()
+
+	// the next interval begins
+	when = when.Add(time.Second)
+
+	refund, acquired, err = sem.ClaimN(ctxt, key, 1)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	when = when.Add(time.Minute)
+	successfulAttempt()
+
+	// the tokens expired along with their interval
+	// so they are not returned to the current interval
+	require.Nil(t, refund(ctxt))
+	failedAttempt()
+}
+
+func Test_AcquireN(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		keyer = staticKeyer(time.Now().Format("2006-01-02T15:04:05.9999999"))
+		sem   = NewSemaphore(clientv3.NewKV(cli), 10, WithKeyer(keyer))
+		ctxt  = context.Background()
+	)
+
+	acquired, err := sem.AcquireN(ctxt, "/foo", 7)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+
+	// not enough remain so none are claimed
+	acquired, err = sem.AcquireN(ctxt, "/foo", 4)
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+
+	acquired, err = sem.AcquireN(ctxt, "/foo", 3)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+
+	_, err = sem.AcquireN(ctxt, "/foo", 11)
+	assert.Equal(t, ErrCostExceedsLimit, err)
+}
+
+func Test_HierarchicalSemaphore(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		keyer   = staticKeyer(time.Now().Format("2006-01-02T15:04:05.9999999"))
+		sem     = NewHierarchicalSemaphore(clientv3.NewKV(cli), []int{3, 2}, WithKeyer(keyer))
+		ctxt    = context.Background()
+		users   = rate.JoinHierarchy("acme", "/users")
+		orders  = rate.JoinHierarchy("acme", "/orders")
+		attempt = func(key string, expected bool) {
+			t.Helper()
+
+			acquired, err := sem.Acquire(ctxt, key)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, acquired)
+		}
+	)
+
+	attempt(users, true)
+	attempt(users, true)
+	// endpoint level is exhausted
+	attempt(users, false)
+
+	attempt(orders, true)
+	// tenant level is exhausted
+	attempt(orders, false)
+}
+
+func Test_HierarchicalSemaphore_ClaimN(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		when = time.Date(2019, 5, 3, 12, 0, 59, 0, time.UTC)
+		sem  = NewHierarchicalSemaphore(clientv3.NewKV(cli), []int{2, 1}, WithKeyer(IntervalKeyer(time.Minute)))
+		ctxt = context.Background()
+		// unique tenant so that previous runs are not observed
+		tenant  = fmt.Sprintf("tenant-%d", time.Now().UnixNano())
+		users   = rate.JoinHierarchy(tenant, "/users")
+		attempt = func(expected bool) {
+			t.Helper()
+
+			acquired, err := sem.Acquire(ctxt, users)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, acquired)
+		}
+	)
+
+	now = func() time.Time { return when }
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	refund, acquired, err := sem.ClaimN(ctxt, users, 1)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// refunds within the interval return the tokens to every level
+	require.Nil(t, refund(ctxt))
+
+	status, err := sem.Status(ctxt, users)
+	require.Nil(t, err)
+	assert.Equal(t, 1, status.Remaining)
+
+	attempt(true)
+	attempt(false)
+
+	require.Nil(t, sem.Refund(ctxt, users, 1))
+	attempt(true)
+
+	// the next interval begins
+	when = when.Add(time.Second)
+
+	refund, acquired, err = sem.ClaimN(ctxt, users, 1)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	when = when.Add(time.Minute)
+	attempt(true)
+
+	// the tokens expired along with their interval
+	// so they are not returned to the current interval
+	require.Nil(t, refund(ctxt))
+	attempt(false)
+}
+
+func Test_Acquire_SlidingWindow(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		when = time.Date(2019, 5, 3, 12, 0, 59, 0, time.UTC)
+		sem  = NewSemaphore(clientv3.NewKV(cli), 10, WithSlidingWindow(time.Minute))
+		ctxt = context.Background()
+		// unique key so that previous runs are not observed
+		key     = fmt.Sprintf("/sliding/%d", time.Now().UnixNano())
+		attempt = func(expected bool) {
+			t.Helper()
+
+			acquired, err := sem.Acquire(ctxt, key)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, acquired)
+		}
+	)
+
+	now = func() time.Time { return when }
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	// the full limit is consumed just before the minute boundary
+	for i := 0; i < 10; i++ {
+		attempt(true)
+	}
+
+	attempt(false)
+
+	// a fixed window would permit another 10 here
+	when = time.Date(2019, 5, 3, 12, 1, 0, 0, time.UTC)
+	attempt(false)
+
+	// half way through the interval half of the previous count remains
+	when = time.Date(2019, 5, 3, 12, 1, 30, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		attempt(true)
+	}
+
+	attempt(false)
+}
+
+func Test_GCRA(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one request every 6 seconds with bursts of up to 3
+	gcra, err := NewGCRA(clientv3.NewKV(cli), 10, time.Minute, 3)
+	require.Nil(t, err)
+
+	var (
+		when = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		ctxt = context.Background()
+		// unique key so that previous runs are not observed
+		key     = fmt.Sprintf("/gcra/%d", time.Now().UnixNano())
+		attempt = func(expected bool) {
+			t.Helper()
+
+			acquired, err := gcra.Acquire(ctxt, key)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, acquired)
+		}
+	)
+
+	now = func() time.Time { return when }
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	// the full burst is permitted at once
+	attempt(true)
+	attempt(true)
+	attempt(true)
+	attempt(false)
+
+	// a single emission interval later one more is permitted
+	when = when.Add(6 * time.Second)
+	attempt(true)
+	attempt(false)
+
+	_, err = gcra.AcquireN(ctxt, key, 4)
+	assert.Equal(t, ErrCostExceedsLimit, err)
+
+	// refunds move the theoretical arrival time back
+	refund, acquired, err := gcra.ClaimN(ctxt, key, 1)
+	require.Nil(t, err)
+	assert.False(t, acquired)
+
+	require.Nil(t, gcra.Refund(ctxt, key, 1))
+
+	refund, acquired, err = gcra.ClaimN(ctxt, key, 1)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	require.Nil(t, refund(ctxt))
+	attempt(true)
+	attempt(false)
+}
+
+func Test_InflightSemaphore(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		sem  = NewInflightSemaphore(clientv3.NewKV(cli), 2, WithLease(clientv3.NewLease(cli)))
+		ctxt = context.Background()
+		// unique key so that previous runs are not observed
+		key     = fmt.Sprintf("/inflight/%d", time.Now().UnixNano())
+		attempt = func(expected bool) rate.Lease {
+			t.Helper()
+
+			lease, acquired, err := sem.Lease(ctxt, key, 1)
+			require.Nil(t, err)
+			assert.Equal(t, expected, acquired)
+
+			return lease
+		}
+	)
+
+	first := attempt(true)
+	second := attempt(true)
+	attempt(false)
+
+	// releasing returns the token
+	require.Nil(t, first.Release(ctxt))
+	attempt(true)
+	attempt(false)
+
+	require.Nil(t, second.Release(ctxt))
+	attempt(true)
+}
+
+func Test_Status(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		when = time.Date(2019, 5, 3, 12, 0, 45, 0, time.UTC)
+		sem  = NewSemaphore(clientv3.NewKV(cli), 3)
+		ctxt = context.Background()
+		// unique key so that previous runs are not observed
+		key = fmt.Sprintf("/status/%d", time.Now().UnixNano())
+	)
+
+	now = func() time.Time { return when }
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	status, err := sem.Status(ctxt, key)
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 3, Reset: 15 * time.Second}, status)
+
+	acquired, err := sem.AcquireN(ctxt, key, 2)
+	require.Nil(t, err)
+	require.True(t, acquired)
+
+	// the interval resets at the next minute
+	status, err = sem.Status(ctxt, key)
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 1, Reset: 15 * time.Second}, status)
+}
+
+func Test_Reserve(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		when = time.Date(2019, 5, 3, 12, 0, 45, 0, time.UTC)
+		next = time.Date(2019, 5, 3, 12, 1, 0, 0, time.UTC)
+		sem  = NewSemaphore(clientv3.NewKV(cli), 2)
+		ctxt = context.Background()
+		// unique key so that previous runs are not observed
+		key     = fmt.Sprintf("/reserve/%d", time.Now().UnixNano())
+		reserve = func(n int, expected time.Time) rate.Reservation {
+			t.Helper()
+
+			reservation, err := sem.ReserveN(ctxt, key, n)
+			require.Nil(t, err)
+			assert.True(t, reservation.OK())
+			assert.Equal(t, expected, reservation.Time())
+
+			return reservation
+		}
+	)
+
+	now = func() time.Time { return when }
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	// the current interval has capacity
+	reserve(1, when)
+
+	// the remainder does not fit so the next interval is reserved
+	cancelled := reserve(2, next)
+
+	// cancelling returns the tokens to the next interval
+	require.Nil(t, cancelled.Cancel(ctxt))
+	reserve(1, when)
+	reserve(2, next)
+
+	// a custom keyer cannot reserve ahead
+	_, err = NewSemaphore(clientv3.NewKV(cli), 2, WithKeyer(staticKeyer("baz"))).Reserve(ctxt, key)
+	assert.Equal(t, rate.ErrReserveNotSupported, err)
+}
+
+func Test_GCRA_Reserve(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one request every 6 seconds with bursts of up to 2
+	gcra, err := NewGCRA(clientv3.NewKV(cli), 10, time.Minute, 2)
+	require.Nil(t, err)
+
+	var (
+		when = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		ctxt = context.Background()
+		// unique key so that previous runs are not observed
+		key     = fmt.Sprintf("/gcra/reserve/%d", time.Now().UnixNano())
+		reserve = func(expected time.Time) rate.Reservation {
+			t.Helper()
+
+			reservation, err := gcra.Reserve(ctxt, key)
+			require.Nil(t, err)
+			assert.Equal(t, expected, reservation.Time())
+
+			return reservation
+		}
+	)
+
+	now = func() time.Time { return when }
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	reserve(when)
+	reserve(when)
+	cancelled := reserve(when.Add(6 * time.Second))
+
+	require.Nil(t, cancelled.Cancel(ctxt))
+	reserve(when.Add(6 * time.Second))
+}
+
+func Test_Acquire_SharesLease(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		granted = generic.NewCounter("granted")
+		saved   = generic.NewCounter("saved")
+		opts    = Options{WithLease(clientv3.NewLease(cli)), WithLeaseCounters(granted, saved)}
+		sem     = NewSemaphore(clientv3.NewKV(cli), 2, opts...)
+		ctxt    = context.Background()
+		prefix  = fmt.Sprintf("/lease/%d", time.Now().UnixNano())
+		keys    = []string{prefix + "/foo", prefix + "/bar", prefix + "/baz"}
+		leases  = map[int64]struct{}{}
+	)
+
+	for _, key := range keys {
+		acquired, err := sem.Acquire(ctxt, key)
+		require.Nil(t, err)
+		require.True(t, acquired)
+
+		interval, _ := sem.keyer.Key(key)
+
+		resp, err := cli.Get(ctxt, versionPrefix(SchemaVersion)+interval)
+		require.Nil(t, err)
+		require.Len(t, resp.Kvs, 1)
+
+		leases[resp.Kvs[0].Lease] = struct{}{}
+	}
+
+	// every key of the interval is attached to the same lease
+	// unless the interval ended part way through
+	assert.True(t, len(leases) <= 2)
+	assert.Equal(t, float64(len(keys)), granted.Value()+saved.Value())
+}
+
+// countingKV is a clientv3.KV which counts the transactions and gets made
+type countingKV struct {
+	clientv3.KV
+	txns, gets int
+}
+
+func (c *countingKV) Txn(ctxt context.Context) clientv3.Txn {
+	c.txns++
+	return c.KV.Txn(ctxt)
+}
+
+// Do is counted as keys are versioned by wrapping the KV
+// which performs every get using Do
+func (c *countingKV) Do(ctxt context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	if op.IsGet() {
+		c.gets++
+	}
+
+	return c.KV.Do(ctxt, op)
+}
+
+func Test_PrefetchingSemaphore(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		kv    = &countingKV{KV: clientv3.NewKV(cli)}
+		keyer = staticKeyer(time.Now().Format("2006-01-02T15:04:05.9999999"))
+		sem   = NewPrefetchingSemaphore(kv, 20, 5, WithKeyer(keyer))
+		other = NewSemaphore(clientv3.NewKV(cli), 20, WithKeyer(keyer))
+		ctxt  = context.Background()
+	)
+
+	for i := 0; i < 15; i++ {
+		acquired, err := sem.Acquire(ctxt, "/foo")
+		require.Nil(t, err)
+		require.True(t, acquired)
+	}
+
+	// batches grow with the local rate so far fewer
+	// transactions are made than tokens acquired
+	assert.True(t, kv.txns < 10, "expected fewer than 10 transactions, made %d", kv.txns)
+
+	// the status is answered locally
+	gets := kv.gets
+	status, err := sem.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, 5, status.Remaining)
+	assert.Equal(t, gets, kv.gets)
+
+	// tokens prefetched but not acquired are unavailable elsewhere
+	status, err = other.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.True(t, status.Remaining <= 5)
+
+	require.Nil(t, sem.Flush(ctxt))
+
+	// until they are handed back
+	status, err = other.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, 5, status.Remaining)
+
+	// the shortfall is claimed once a batch no longer fits
+	for i := 0; i < 5; i++ {
+		acquired, err := sem.Acquire(ctxt, "/foo")
+		require.Nil(t, err)
+		require.True(t, acquired)
+	}
+
+	acquired, err := sem.Acquire(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.False(t, acquired)
+}
+
+func Test_PrefetchingSemaphore_IntervalEndsWhileClaiming(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		// unique prefixes so that previous runs are not observed
+		prefixes = []string{fmt.Sprintf("/rollover/%d/1", time.Now().UnixNano()), fmt.Sprintf("/rollover/%d/2", time.Now().UnixNano())}
+		calls    int
+		// the interval ends once the batch of the first has been found
+		keyer = KeyerFunc(func(string) (string, time.Duration) {
+			calls++
+			if calls == 1 {
+				return prefixes[0], time.Minute
+			}
+
+			return prefixes[1], time.Minute
+		})
+		sem   = NewPrefetchingSemaphore(clientv3.NewKV(cli), 10, 5, WithKeyer(keyer))
+		other = NewSemaphore(clientv3.NewKV(cli), 10, WithKeyer(staticKeyer(prefixes[1])))
+		ctxt  = context.Background()
+	)
+
+	// a token remains in the first interval and the rate
+	// observed calls for batches of the maximum size
+	b := &batch{prefix: prefixes[0]}
+	b.claimed(now().Add(-time.Second), 1)
+	b.served = 100
+	sem.batches["foo"] = b
+
+	refund, acquired, err := sem.ClaimN(ctxt, "foo", 2)
+	require.Nil(t, err)
+	require.True(t, acquired)
+
+	// the batch moved on to the interval it was claimed from
+	// and retains the tokens claimed but not acquired
+	b.mu.Lock()
+	assert.Equal(t, prefixes[1], b.prefix)
+	assert.Equal(t, 3, b.remaining)
+	b.mu.Unlock()
+
+	status, err := other.Status(ctxt, "foo")
+	require.Nil(t, err)
+	assert.Equal(t, 5, status.Remaining)
+
+	// and the tokens acquired are refunded to it
+	require.Nil(t, refund(ctxt))
+
+	b.mu.Lock()
+	assert.Equal(t, 5, b.remaining)
+	b.mu.Unlock()
+}
+
+func Test_Semaphore_SchemaVersions(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(addresses, ",")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		kv     = namespace.NewKV(clientv3.NewKV(cli), fmt.Sprintf("/versions/%d/", time.Now().UnixNano()))
+		keyer  = staticKeyer(time.Now().Format("2006-01-02T15:04:05.9999999"))
+		legacy = NewSemaphore(kv, 2, WithKeyer(keyer), WithSchemaVersion(0))
+		sem    = NewSemaphore(kv, 2, WithKeyer(keyer))
+		ctxt   = context.Background()
+	)
+
+	// instances of different versions run side by side
+	// without reading each other's counts
+	for _, s := range []*Semaphore{legacy, sem} {
+		attemptIsSuccessful(t, s, ctxt, "/foo")
+		attemptIsSuccessful(t, s, ctxt, "/foo")
+		attemptIsUnsuccessful(t, s, ctxt, "/foo")
+	}
+
+	interval, _ := keyer.Key("/foo")
+
+	for _, key := range []string{interval, versionPrefix(SchemaVersion) + interval} {
+		resp, err := kv.Get(ctxt, key)
+		require.Nil(t, err)
+		require.Len(t, resp.Kvs, 1)
+		assert.Equal(t, "2", string(resp.Kvs[0].Value))
+	}
+}
@@ -0,0 +1,443 @@
+package persistent
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// prefetchHorizon is how far ahead a PrefetchingSemaphore prefetches
+// Batches are sized to the tokens expected to be acquired locally
+// within the horizon at the rate observed since the last batch
+const prefetchHorizon = time.Second
+
+// PrefetchingSemaphore is a Semaphore which claims tokens from etcd in
+// batches and serves them locally, rather than making a round trip to
+// etcd for every acquisition
+// The size of each batch adapts to the rate at which tokens are acquired
+// locally, up to the configured maximum batch size
+// Tokens which are prefetched but never acquired are lost to the rest of
+// the cluster until their interval ends, so the maximum batch size bounds
+// the loss of precision of the limit per instance
+type PrefetchingSemaphore struct {
+	sem *Semaphore
+	max int
+
+	mu sync.Mutex
+	// batches and the expiry of each batch are guarded by mu
+	batches map[string]*batch
+}
+
+// batch is the tokens of a key prefetched for the current interval
+type batch struct {
+	// expires is when the current interval ends
+	expires time.Time
+
+	mu sync.Mutex
+	// prefix is the key of the interval the tokens were claimed from
+	prefix string
+	// total is the number of tokens claimed for the interval
+	// of which remaining have yet to be acquired
+	total, remaining int
+
+	// claiming is closed once the batch being claimed, if any, has been
+	claiming chan struct{}
+
+	// served is the number of tokens acquired locally
+	// since the last batch was claimed at claimedAt
+	served    int
+	claimedAt time.Time
+
+	// budget is the number of tokens of the interval which remained in
+	// etcd, and resetAt when they reset, as last observed by a claim
+	// reconciled is false until the budget of the interval is observed
+	budget     int
+	resetAt    time.Time
+	reconciled bool
+}
+
+// NewPrefetchingSemaphore returns a configured etcd backed PrefetchingSemaphore
+// which implements rate.Acquirer and claims up to max tokens at once
+// It may be configured as a sliding window counter using WithSlidingWindow
+func NewPrefetchingSemaphore(kv clientv3.KV, limit, max int, opts ...Option) *PrefetchingSemaphore {
+	if max < 1 {
+		max = 1
+	}
+
+	return &PrefetchingSemaphore{
+		sem:     NewSemaphore(kv, limit, opts...),
+		max:     max,
+		batches: map[string]*batch{},
+	}
+}
+
+// Acquire attempts to acquire a "token" for the provided key (see AcquireN)
+func (p *PrefetchingSemaphore) Acquire(ctxt context.Context, key string) (bool, error) {
+	return p.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN attempts to acquire n "tokens" for the provided key
+// Tokens are served from those prefetched for the current interval and
+// a batch is claimed from etcd when too few remain
+// Given a batch cannot be claimed the shortfall alone is claimed, so
+// that the limit is reached precisely
+// Batches are claimed without holding the lock of the key, so that
+// acquisitions which the tokens remaining cover are served meanwhile
+// and only those which need the batch wait for it
+func (p *PrefetchingSemaphore) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	_, acquired, err := p.acquireN(ctxt, key, n)
+	return acquired, err
+}
+
+// ClaimN attempts to acquire n "tokens" for the provided key (see AcquireN)
+// The returned rate.RefundFunc returns the tokens to those prefetched
+// for the interval they were acquired from, and does nothing once that
+// interval has ended as the tokens expired along with it
+func (p *PrefetchingSemaphore) ClaimN(ctxt context.Context, key string, n int) (rate.RefundFunc, bool, error) {
+	prefix, acquired, err := p.acquireN(ctxt, key, n)
+
+	return func(ctxt context.Context) error {
+		b, err := p.batch(ctxt, key)
+		if err != nil {
+			return err
+		}
+
+		defer b.mu.Unlock()
+
+		if b.prefix == prefix {
+			b.refund(n)
+		}
+
+		return nil
+	}, acquired, err
+}
+
+// acquireN attempts to acquire n "tokens" for the provided key and
+// returns the prefix of the interval they were acquired from
+func (p *PrefetchingSemaphore) acquireN(ctxt context.Context, key string, n int) (string, bool, error) {
+	if n > p.sem.limit {
+		return "", false, ErrCostExceedsLimit
+	}
+
+	for {
+		b, err := p.batch(ctxt, key)
+		if err != nil {
+			return "", false, err
+		}
+
+		if b.remaining >= n {
+			prefix := b.prefix
+			b.remaining -= n
+			b.served += n
+			b.mu.Unlock()
+
+			return prefix, true, nil
+		}
+
+		if claiming := b.claiming; claiming != nil {
+			// wait for the batch being claimed and attempt again
+			b.mu.Unlock()
+
+			select {
+			case <-claiming:
+				continue
+			case <-ctxt.Done():
+				return "", false, ctxt.Err()
+			}
+		}
+
+		var (
+			prefix   = b.prefix
+			need     = n - b.remaining
+			size     = b.next(now(), p.max)
+			claiming = make(chan struct{})
+		)
+
+		b.claiming = claiming
+		b.mu.Unlock()
+
+		acquired, size, claimed, status, err := p.claim(ctxt, key, size, need)
+
+		b.mu.Lock()
+		b.claiming = nil
+		close(claiming)
+
+		if err != nil {
+			b.mu.Unlock()
+			return prefix, false, err
+		}
+
+		if claimed != b.prefix {
+			if b.prefix != prefix {
+				// the batch has since moved to an interval other than
+				// the one claimed from, so the tokens are handed back
+				// and the acquisition is attempted again
+				b.mu.Unlock()
+
+				if acquired {
+					if err := p.sem.refund(ctxt, key, claimed, size); err != nil {
+						return prefix, false, err
+					}
+				}
+
+				continue
+			}
+
+			// the interval ended while claiming so the tokens which
+			// remained were discarded and the batch moves on to the
+			// interval the tokens were claimed from
+			b.begin(claimed)
+		}
+
+		b.reconcile(now(), status)
+
+		if !acquired {
+			b.mu.Unlock()
+			return claimed, false, nil
+		}
+
+		// the batch is served from by attempting again as the tokens
+		// which remained may have been acquired while claiming
+		b.claimed(now(), size)
+		b.mu.Unlock()
+	}
+}
+
+// claim claims a batch of size tokens for key from etcd, or need tokens
+// given the batch cannot be claimed, and returns the number claimed and
+// the prefix of the interval they were claimed from along with the
+// status of the key observed
+func (p *PrefetchingSemaphore) claim(ctxt context.Context, key string, size, need int) (bool, int, string, rate.Status, error) {
+	if size < need {
+		size = need
+	}
+
+	if size > p.sem.limit {
+		size = p.sem.limit
+	}
+
+	acquired, interval, status, err := p.sem.acquire(ctxt, key, size)
+	if err != nil || acquired || size == need {
+		return acquired, size, interval.prefix, status, err
+	}
+
+	acquired, interval, status, err = p.sem.acquire(ctxt, key, need)
+	return acquired, need, interval.prefix, status, err
+}
+
+// Refund returns n previously acquired "tokens" for the provided key
+// Tokens of the current interval are returned to those prefetched
+// rather than to etcd, where they may be acquired again locally
+func (p *PrefetchingSemaphore) Refund(ctxt context.Context, key string, n int) error {
+	b, err := p.batch(ctxt, key)
+	if err != nil {
+		return err
+	}
+
+	if b.total == 0 {
+		// nothing has been claimed for the current interval
+		// so the budget is observed again once it is refunded
+		b.reconciled = false
+		b.mu.Unlock()
+
+		return p.sem.Refund(ctxt, key, n)
+	}
+
+	defer b.mu.Unlock()
+
+	b.refund(n)
+
+	return nil
+}
+
+// Status returns the budget of the provided key for the current interval
+// without consuming any of it
+// It is answered locally from the budget observed by the last claim and
+// the tokens prefetched, so etcd is only read given nothing has been
+// claimed for the key during the current interval
+func (p *PrefetchingSemaphore) Status(ctxt context.Context, key string) (rate.Status, error) {
+	b, err := p.batch(ctxt, key)
+	if err != nil {
+		return rate.Status{}, err
+	}
+
+	if !b.reconciled {
+		prefix := b.prefix
+		b.mu.Unlock()
+
+		status, err := p.sem.Status(ctxt, key)
+		if err != nil {
+			return rate.Status{}, err
+		}
+
+		b.mu.Lock()
+		if b.prefix != prefix {
+			// the interval ended while reading it
+			b.mu.Unlock()
+			return status, nil
+		}
+
+		if !b.reconciled {
+			b.reconcile(now(), status)
+		}
+	}
+
+	defer b.mu.Unlock()
+
+	return b.status(now(), p.sem.limit), nil
+}
+
+// Flush hands the tokens prefetched for the current interval of every
+// key back to etcd, so that they may be acquired by other instances
+// e.g. when the instance is shutting down
+// Tokens of previous intervals have expired and are simply discarded
+func (p *PrefetchingSemaphore) Flush(ctxt context.Context) error {
+	p.mu.Lock()
+	keys := make([]string, 0, len(p.batches))
+	for key := range p.batches {
+		keys = append(keys, key)
+	}
+	p.mu.Unlock()
+
+	for _, key := range keys {
+		b, err := p.batch(ctxt, key)
+		if err != nil {
+			return err
+		}
+
+		remaining := b.remaining
+		if remaining > 0 {
+			if err := p.sem.refund(ctxt, key, b.prefix, remaining); err != nil {
+				b.mu.Unlock()
+				return err
+			}
+
+			b.total -= remaining
+			b.budget += remaining
+			b.remaining = 0
+		}
+
+		b.mu.Unlock()
+	}
+
+	return nil
+}
+
+// batch returns the locked batch of key for the current interval
+// The tokens prefetched for previous intervals are discarded as they
+// expired along with their interval
+func (p *PrefetchingSemaphore) batch(ctxt context.Context, key string) (*batch, error) {
+	select {
+	case <-ctxt.Done():
+		return nil, ctxt.Err()
+	default:
+	}
+
+	var (
+		prefix, expiresIn = p.sem.keyer.Key(key)
+		now               = now()
+	)
+
+	p.mu.Lock()
+	b, ok := p.batches[key]
+	if !ok {
+		p.evict(now)
+
+		b = &batch{}
+		p.batches[key] = b
+	}
+	b.expires = now.Add(expiresIn)
+	p.mu.Unlock()
+
+	b.mu.Lock()
+	if b.prefix != prefix {
+		b.begin(prefix)
+	}
+
+	return b, nil
+}
+
+// evict forgets the batches of every key which have expired
+// It must be called with the lock held
+func (p *PrefetchingSemaphore) evict(now time.Time) {
+	for key, b := range p.batches {
+		if b.expires.Before(now) {
+			delete(p.batches, key)
+		}
+	}
+}
+
+// next returns the number of tokens to claim in the next batch
+// given the rate at which tokens have been acquired since the last
+// batch was claimed, between 1 and max
+func (b *batch) next(now time.Time, max int) int {
+	elapsed := now.Sub(b.claimedAt)
+	if b.claimedAt.IsZero() || elapsed <= 0 {
+		return 1
+	}
+
+	size := int(math.Ceil(float64(b.served) * float64(prefetchHorizon) / float64(elapsed)))
+	switch {
+	case size < 1:
+		return 1
+	case size > max:
+		return max
+	}
+
+	return size
+}
+
+// begin moves the batch on to the interval stored at prefix
+// The observed rate is retained across intervals so only the
+// tokens claimed are forgotten
+func (b *batch) begin(prefix string) {
+	b.prefix = prefix
+	b.total, b.remaining = 0, 0
+	b.reconciled = false
+}
+
+// claimed records that size tokens have been claimed at now
+func (b *batch) claimed(now time.Time, size int) {
+	b.total += size
+	b.remaining += size
+	b.served = 0
+	b.claimedAt = now
+}
+
+// refund returns n tokens acquired locally to those prefetched
+// but never holds more than has been claimed
+func (b *batch) refund(n int) {
+	if b.remaining+n > b.total {
+		n = b.total - b.remaining
+	}
+
+	b.remaining += n
+	b.served -= n
+}
+
+// reconcile records the status of the key observed in etcd at now
+func (b *batch) reconcile(now time.Time, status rate.Status) {
+	b.budget = status.Remaining
+	b.resetAt = now.Add(status.Reset)
+	b.reconciled = true
+}
+
+// status returns the status of the key at now given the budget last
+// observed in etcd and the tokens prefetched which remain locally
+func (b *batch) status(now time.Time, limit int) rate.Status {
+	remaining := b.budget + b.remaining
+	if remaining > limit {
+		remaining = limit
+	}
+
+	reset := b.resetAt.Sub(now)
+	if reset < 0 {
+		reset = 0
+	}
+
+	return rate.Status{Limit: limit, Remaining: remaining, Reset: reset}
+}
@@ -0,0 +1,195 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/clientv3"
+)
+
+func Test_batch_next(t *testing.T) {
+	claimedAt := time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name      string
+		claimedAt time.Time
+		served    int
+		elapsed   time.Duration
+		size      int
+	}{
+		{name: "never claimed", size: 1},
+		{name: "nothing served", claimedAt: claimedAt, elapsed: time.Second, size: 1},
+		{name: "slow rate", claimedAt: claimedAt, served: 1, elapsed: 10 * time.Second, size: 1},
+		{name: "moderate rate", claimedAt: claimedAt, served: 3, elapsed: 500 * time.Millisecond, size: 6},
+		{name: "rate beyond max", claimedAt: claimedAt, served: 100, elapsed: time.Second, size: 10},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := &batch{claimedAt: test.claimedAt, served: test.served}
+			assert.Equal(t, test.size, b.next(test.claimedAt.Add(test.elapsed), 10))
+		})
+	}
+}
+
+func Test_PrefetchingSemaphore_batch(t *testing.T) {
+	var (
+		prefix = "foo/1"
+		keyer  = KeyerFunc(func(key string) (string, time.Duration) { return prefix, time.Minute })
+		sem    = NewPrefetchingSemaphore(nil, 10, 5, WithKeyer(keyer))
+		ctxt   = context.Background()
+	)
+
+	b, err := sem.batch(ctxt, "foo")
+	require.Nil(t, err)
+	b.claimed(now(), 5)
+	b.remaining, b.served = 2, 3
+	b.mu.Unlock()
+
+	// refunds are returned to the tokens prefetched
+	require.Nil(t, sem.Refund(ctxt, "foo", 2))
+	assert.Equal(t, 4, b.remaining)
+	assert.Equal(t, 1, b.served)
+
+	// but never beyond the tokens claimed
+	require.Nil(t, sem.Refund(ctxt, "foo", 2))
+	assert.Equal(t, 5, b.remaining)
+
+	// tokens prefetched for previous intervals are discarded
+	prefix = "foo/2"
+
+	b, err = sem.batch(ctxt, "foo")
+	require.Nil(t, err)
+	b.mu.Unlock()
+
+	assert.Equal(t, 0, b.total)
+	assert.Equal(t, 0, b.remaining)
+}
+
+func Test_PrefetchingSemaphore_ClaimN(t *testing.T) {
+	var (
+		prefix = "foo/1"
+		keyer  = KeyerFunc(func(key string) (string, time.Duration) { return prefix, time.Minute })
+		sem    = NewPrefetchingSemaphore(nil, 10, 5, WithKeyer(keyer))
+		ctxt   = context.Background()
+		claim  = func(size int) {
+			t.Helper()
+
+			b, err := sem.batch(ctxt, "foo")
+			require.Nil(t, err)
+			b.claimed(now(), size)
+			b.mu.Unlock()
+		}
+	)
+
+	claim(5)
+
+	// tokens are served from those prefetched
+	refund, acquired, err := sem.ClaimN(ctxt, "foo", 2)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	b, err := sem.batch(ctxt, "foo")
+	require.Nil(t, err)
+	assert.Equal(t, 3, b.remaining)
+	b.mu.Unlock()
+
+	// and refunded to them within the same interval
+	require.Nil(t, refund(ctxt))
+	assert.Equal(t, 5, b.remaining)
+
+	refund, acquired, err = sem.ClaimN(ctxt, "foo", 2)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// once the interval ends its tokens have expired
+	// so they are not refunded to the next interval
+	prefix = "foo/2"
+	claim(3)
+
+	require.Nil(t, refund(ctxt))
+
+	b, err = sem.batch(ctxt, "foo")
+	require.Nil(t, err)
+	assert.Equal(t, 3, b.remaining)
+	b.mu.Unlock()
+}
+
+func Test_PrefetchingSemaphore_Status(t *testing.T) {
+	when := time.Date(2019, 5, 3, 12, 0, 30, 0, time.UTC)
+	now = func() time.Time { return when }
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	var (
+		// the semaphore has no kv so any read of etcd would panic
+		sem  = NewPrefetchingSemaphore(nil, 10, 5)
+		ctxt = context.Background()
+	)
+
+	b, err := sem.batch(ctxt, "foo")
+	require.Nil(t, err)
+	b.claimed(when, 5)
+	b.reconcile(when, rate.Status{Limit: 10, Remaining: 3, Reset: 30 * time.Second})
+	b.remaining = 4
+	b.mu.Unlock()
+
+	when = when.Add(10 * time.Second)
+
+	// the tokens prefetched remain in addition to those left in etcd
+	status, err := sem.Status(ctxt, "foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 10, Remaining: 7, Reset: 20 * time.Second}, status)
+}
+
+// blockingKV is a clientv3.KV which blocks every operation until released
+// and then fails it
+type blockingKV struct {
+	clientv3.KV
+
+	entered, release chan struct{}
+}
+
+func (b blockingKV) Do(context.Context, clientv3.Op) (clientv3.OpResponse, error) {
+	b.entered <- struct{}{}
+	<-b.release
+
+	return clientv3.OpResponse{}, errors.New("unavailable")
+}
+
+func (b blockingKV) Get(ctxt context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	_, err := b.Do(ctxt, clientv3.OpGet(key, opts...))
+	return nil, err
+}
+
+func Test_PrefetchingSemaphore_ClaimsWithoutLock(t *testing.T) {
+	var (
+		kv   = blockingKV{entered: make(chan struct{}), release: make(chan struct{})}
+		sem  = NewPrefetchingSemaphore(kv, 10, 5)
+		ctxt = context.Background()
+		errs = make(chan error)
+	)
+
+	b, err := sem.batch(ctxt, "foo")
+	require.Nil(t, err)
+	b.claimed(now(), 1)
+	b.mu.Unlock()
+
+	// an acquisition which the remaining token does not cover claims a batch
+	go func() {
+		_, err := sem.AcquireN(ctxt, "foo", 2)
+		errs <- err
+	}()
+
+	<-kv.entered
+
+	// while acquisitions the remaining token covers are served meanwhile
+	acquired, err := sem.Acquire(ctxt, "foo")
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	close(kv.release)
+	assert.NotNil(t, <-errs)
+}
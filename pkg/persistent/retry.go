@@ -0,0 +1,74 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	// defaultMaxAttempts is the number of times a transaction is
+	// attempted by default before giving up under contention
+	defaultMaxAttempts = 10
+	// defaultBackoff is the most a first retry waits by default
+	defaultBackoff = 5 * time.Millisecond
+	// maxBackoff is the most any single retry waits
+	maxBackoff = 250 * time.Millisecond
+)
+
+// ErrContention is returned when a transaction conflicted with
+// concurrent updates to the same keys on every attempt permitted
+// (see WithRetries)
+var ErrContention = errors.New("too much contention")
+
+// retry calls attempt until it reports that it is done or returns
+// an error, which is typically once its transaction has succeeded
+// Attempts which are not done are counted as conflicts for key and
+// are retried after a jittered backoff which doubles every attempt
+// ErrContention is returned once the maximum attempts have been made
+func (s *Semaphore) retry(ctxt context.Context, key string, attempt func() (done bool, err error)) error {
+	for i := 0; ; i++ {
+		done, err := attempt()
+		if err != nil || done {
+			return err
+		}
+
+		s.conflicts.With("key", key).Add(1)
+
+		if i+1 >= s.maxAttempts {
+			return ErrContention
+		}
+
+		s.retries.With("key", key).Add(1)
+
+		if err := s.wait(ctxt, i); err != nil {
+			return err
+		}
+	}
+}
+
+// wait sleeps for a random duration of up to the configured backoff
+// doubled for every previous retry, or until the context is done
+func (s *Semaphore) wait(ctxt context.Context, retries int) error {
+	if s.backoff <= 0 {
+		return ctxt.Err()
+	}
+
+	ceiling := maxBackoff
+	if retries < 32 {
+		if backoff := s.backoff << uint(retries); backoff > 0 && backoff < maxBackoff {
+			ceiling = backoff
+		}
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(ceiling))) + 1)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctxt.Done():
+		return ctxt.Err()
+	}
+}
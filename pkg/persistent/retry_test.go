@@ -0,0 +1,81 @@
+package persistent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// keyCounter is a metrics.Counter which records counts per "key" label
+type keyCounter struct {
+	counts map[string]float64
+	key    string
+}
+
+func (k keyCounter) With(labelValues ...string) metrics.Counter {
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		if labelValues[i] == "key" {
+			k.key = labelValues[i+1]
+		}
+	}
+
+	return k
+}
+
+func (k keyCounter) Add(delta float64) { k.counts[k.key] += delta }
+
+func Test_Semaphore_retry(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		conflicts int
+		attempts  int
+		counts    map[string]float64
+		retries   map[string]float64
+		err       error
+	}{
+		{name: "no conflicts", conflicts: 0, attempts: 1, counts: map[string]float64{}, retries: map[string]float64{}},
+		{name: "some conflicts", conflicts: 2, attempts: 3, counts: map[string]float64{"foo": 2}, retries: map[string]float64{"foo": 2}},
+		// the final conflict is counted but not retried
+		{name: "contention exhausted", conflicts: 5, attempts: 3, counts: map[string]float64{"foo": 3}, retries: map[string]float64{"foo": 2}, err: ErrContention},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				conflicts = keyCounter{counts: map[string]float64{}}
+				retries   = keyCounter{counts: map[string]float64{}}
+				sem       = NewSemaphore(nil, 10, WithRetries(3, 0), WithContentionCounters(conflicts, retries))
+				attempts  int
+			)
+
+			err := sem.retry(context.Background(), "foo", func() (bool, error) {
+				attempts++
+				return attempts > test.conflicts, nil
+			})
+
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.attempts, attempts)
+			assert.Equal(t, test.counts, conflicts.counts)
+			assert.Equal(t, test.retries, retries.counts)
+		})
+	}
+}
+
+func Test_Semaphore_retry_Cancelled(t *testing.T) {
+	var (
+		sem          = NewSemaphore(nil, 10, WithRetries(3, time.Hour))
+		ctxt, cancel = context.WithCancel(context.Background())
+		attempts     int
+	)
+
+	err := sem.retry(ctxt, "foo", func() (bool, error) {
+		attempts++
+		cancel()
+		return false, nil
+	})
+
+	// the backoff is abandoned once the context is done
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,145 @@
+package persistent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// acquireSliding attempts to acquire n "tokens" for the provided key
+// using the sliding window counter algorithm
+// The counts of the current and previous intervals are read and the
+// claim is made within the same transaction
+// The transaction only claims if both counts are as expected and
+// otherwise returns the counts observed, so that the estimate can be
+// recalculated and the claim attempted again (see WithRetries)
+// The interval the tokens were acquired from and the status of the key
+// observed are returned along with the outcome
+func (s *Semaphore) acquireSliding(ctxt context.Context, key string, n int) (acquired bool, _ interval, _ rate.Status, err error) {
+	var (
+		now   = now()
+		start = now.Truncate(s.window)
+		keys  = [2]string{intervalKey(key, start), intervalKey(key, start.Add(-s.window))}
+		// the current count is read as the previous count
+		// throughout the next interval so it must outlive it
+		expiresIn = start.Add(2 * s.window).Sub(now)
+		// proportion of the previous interval which overlaps the window
+		weight = 1 - float64(now.Sub(start))/float64(s.window)
+		// both counts are assumed to be missing until observed otherwise
+		counts   [2]int64
+		cmps     = [2]clientv3.Cmp{missing(keys[0]), missing(keys[1])}
+		observed = false
+	)
+
+	opts, err := s.leaseOptions(ctxt, expiresIn)
+	if err != nil {
+		return false, interval{}, rate.Status{}, err
+	}
+
+	err = s.retry(ctxt, key, func() (bool, error) {
+		for {
+			estimate := float64(counts[1])*weight + float64(counts[0])
+			if observed && estimate+float64(n) > float64(s.limit) {
+				return true, nil
+			}
+
+			// put a 2 second timeout on the transaction
+			tctxt, cancel := context.WithTimeout(ctxt, 2*time.Second)
+
+			resp, err := s.kv.Txn(tctxt).
+				If(cmps[0], cmps[1]).
+				Then(clientv3.OpPut(keys[0], fmt.Sprintf("%d", counts[0]+int64(n)), opts...)).
+				Else(clientv3.OpGet(keys[0]), clientv3.OpGet(keys[1])).
+				Commit()
+			cancel()
+			if err != nil {
+				return false, s.checkLease(err)
+			}
+
+			if resp.Succeeded {
+				acquired = true
+				counts[0] += int64(n)
+				return true, nil
+			}
+
+			for i := range keys {
+				counts[i], cmps[i], err = countAndCompare(resp.Responses[i].GetResponseRange().Kvs, keys[i])
+				if err != nil {
+					return false, err
+				}
+			}
+
+			if observed {
+				// the counts changed since they were observed
+				return false, nil
+			}
+
+			// the counts were assumed missing rather than observed
+			// so the first attempt is made again with their values
+			observed = true
+		}
+	})
+
+	estimate := float64(counts[1])*weight + float64(counts[0])
+
+	return acquired, interval{keys[0], now.Add(expiresIn)}, status(s.limit, estimate, slidingReset(counts, start, now, s.window)), err
+}
+
+// statusSliding returns the budget of the provided key using the
+// sliding window counter estimate of the current window
+// The key regains its full budget once both the current and
+// previous intervals have left the window
+func (s *Semaphore) statusSliding(ctxt context.Context, key string) (rate.Status, error) {
+	var (
+		now   = now()
+		start = now.Truncate(s.window)
+		keys  = [2]string{intervalKey(key, start), intervalKey(key, start.Add(-s.window))}
+		// proportion of the previous interval which overlaps the window
+		weight = 1 - float64(now.Sub(start))/float64(s.window)
+		counts [2]int64
+	)
+
+	// put a 1 second timeout on the get operations
+	tctxt, cancel := context.WithTimeout(ctxt, 1*time.Second)
+	defer cancel()
+
+	resp, err := s.kv.Txn(tctxt).
+		Then(clientv3.OpGet(keys[0]), clientv3.OpGet(keys[1])).
+		Commit()
+	if err != nil {
+		return rate.Status{}, err
+	}
+
+	for i := range keys {
+		counts[i], _, err = countAndCompare(resp.Responses[i].GetResponseRange().Kvs, keys[i])
+		if err != nil {
+			return rate.Status{}, err
+		}
+	}
+
+	estimate := float64(counts[1])*weight + float64(counts[0])
+
+	return status(s.limit, estimate, slidingReset(counts, start, now, s.window)), nil
+}
+
+// slidingReset returns how long until a key with the provided counts of
+// the current and previous intervals regains its full budget, given both
+// intervals must have left the window
+func slidingReset(counts [2]int64, start, now time.Time, window time.Duration) time.Duration {
+	switch {
+	case counts[0] > 0:
+		return start.Add(2 * window).Sub(now)
+	case counts[1] > 0:
+		return start.Add(window).Sub(now)
+	}
+
+	return 0
+}
+
+// missing returns a comparison which holds while key does not exist
+func missing(key string) clientv3.Cmp {
+	return clientv3.Compare(clientv3.Version(key), "=", 0)
+}
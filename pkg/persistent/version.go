@@ -0,0 +1,31 @@
+package persistent
+
+import (
+	"fmt"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/namespace"
+)
+
+// SchemaVersion is the version of the layout and format of the keys
+// written by this package, which prefixes every key as "v<version>/"
+// It is incremented whenever either changes, so that instances writing
+// different versions never read each other's keys (see WithSchemaVersion)
+const SchemaVersion = 1
+
+// versioned returns kv with every key placed beneath the prefix of
+// the provided schema version
+// Version 0 keys were written before keys were versioned and so
+// kv is returned as is
+func versioned(kv clientv3.KV, version int) clientv3.KV {
+	if version <= 0 {
+		return kv
+	}
+
+	return namespace.NewKV(kv, versionPrefix(version))
+}
+
+// versionPrefix returns the prefix of the keys of schema version
+func versionPrefix(version int) string {
+	return fmt.Sprintf("v%d/", version)
+}
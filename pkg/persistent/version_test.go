@@ -0,0 +1,69 @@
+package persistent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// recordingKV is a clientv3.KV which records the key of every operation
+// and responds as though no key exists
+type recordingKV struct {
+	clientv3.KV
+
+	keys []string
+}
+
+func (r *recordingKV) Get(ctxt context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp, err := r.Do(ctxt, clientv3.OpGet(key, opts...))
+	return resp.Get(), err
+}
+
+func (r *recordingKV) Do(_ context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	r.keys = append(r.keys, string(op.KeyBytes()))
+	return (&clientv3.GetResponse{}).OpResponse(), nil
+}
+
+func Test_Semaphore_SchemaVersion(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		opts    Options
+		expects string
+	}{
+		{name: "unversioned by default", expects: "foo/bar"},
+		{name: "current version", opts: Options{WithSchemaVersion(SchemaVersion)}, expects: "v1/foo/bar"},
+		{name: "pinned version", opts: Options{WithSchemaVersion(2)}, expects: "v2/foo/bar"},
+		{name: "unversioned", opts: Options{WithSchemaVersion(0)}, expects: "foo/bar"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				kv  = &recordingKV{}
+				sem = NewSemaphore(kv, 10, append(test.opts, WithKeyer(staticKeyer("foo")))...)
+			)
+
+			status, err := sem.Status(context.Background(), "bar")
+			require.Nil(t, err)
+
+			assert.Equal(t, 10, status.Remaining)
+			assert.Equal(t, []string{test.expects}, kv.keys)
+		})
+	}
+}
+
+func Test_Semaphore_SchemaVersion_Keyer(t *testing.T) {
+	when := time.Date(2019, 5, 3, 12, 0, 30, 0, time.UTC)
+	now = func() time.Time { return when }
+	defer func() { now = func() time.Time { return time.Now().UTC() } }()
+
+	kv := &recordingKV{}
+
+	_, err := NewSemaphore(kv, 10, WithSchemaVersion(SchemaVersion)).Status(context.Background(), "/foo")
+	require.Nil(t, err)
+
+	// the version precedes the key and its interval
+	assert.Equal(t, []string{"v1//foo/2019-05-03T12:00:00"}, kv.keys)
+}
@@ -0,0 +1,386 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+)
+
+const (
+	// BehaviourWait blocks requests until they can be served
+	BehaviourWait = "wait"
+	// BehaviourReject responds 429 Too Many Requests
+	// to requests which cannot be served immediately
+	BehaviourReject = "reject"
+
+	defaultPeriod = time.Minute
+	defaultKey    = "{path}"
+)
+
+// Config is a set of rules which are matched against requests
+// in order to determine which limits apply
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule describes a limit and the requests it applies to
+type Rule struct {
+	// Name identifies the rule and namespaces its keys
+	Name string `json:"name"`
+	// Match describes which requests the rule applies to
+	Match Match `json:"match"`
+	// Limit is the number of requests permitted per key each period
+	Limit int `json:"limit"`
+	// Period is the interval over which the limit applies (default 1m)
+	Period Duration `json:"period"`
+	// Key is a key template as understood by rate.ParseKeyTemplate (default {path})
+	Key string `json:"key"`
+	// Behaviour is either wait or reject (default wait)
+	Behaviour string `json:"behaviour"`
+	// Cost describes how many tokens each request consumes
+	Cost Cost `json:"cost"`
+	// MaxWait bounds how long requests wait before being
+	// rejected with 429 Too Many Requests (default unbounded)
+	MaxWait Duration `json:"max_wait"`
+	// MaxQueue bounds the number of requests waiting per key
+	// beyond which they are rejected (default unbounded)
+	MaxQueue int `json:"max_queue"`
+	// Priority describes how waiting requests are classified
+	Priority Priority `json:"priority"`
+}
+
+// Match describes a set of conditions which must all hold
+// for a request to match a rule
+// Empty conditions match every request
+type Match struct {
+	// Host must equal the request host
+	Host string `json:"host"`
+	// Path is a glob as understood by path.Match
+	Path string `json:"path"`
+	// PathPrefix must prefix the request path
+	PathPrefix string `json:"path_prefix"`
+	// Methods contains the request method
+	Methods []string `json:"methods"`
+	// Headers are header names mapped to the values they must equal
+	Headers map[string]string `json:"headers"`
+}
+
+// Cost describes how many tokens a request consumes
+// Method costs take precedence over content-length buckets
+// and requests which match neither are charged the default
+type Cost struct {
+	// Default is the cost of requests which match nothing else (default 1)
+	Default int `json:"default"`
+	// Methods are request methods mapped to their cost
+	Methods map[string]int `json:"methods"`
+	// ContentLength are buckets which charge requests by their size
+	ContentLength []ContentLengthBucket `json:"content_length"`
+}
+
+// Priority describes how requests waiting for the same key
+// are classified, higher classes are served first
+// Requests are not classified unless a header is configured
+type Priority struct {
+	// Header identifies the class of a request e.g. a client tier
+	Header string `json:"header"`
+	// Classes are header values mapped to their class
+	Classes map[string]int `json:"classes"`
+	// Default is the class of requests which match no class
+	Default int `json:"default"`
+	// Aging is how long a request waits before it is promoted
+	// by one class (default disabled)
+	Aging Duration `json:"aging"`
+}
+
+// Func returns a rate.PriorityFunc which classifies requests as described
+func (p Priority) Func() rate.PriorityFunc {
+	return rate.HeaderPriority(p.Header, p.Classes, p.Default)
+}
+
+func (p Priority) validate() (errs []error) {
+	if p.Header == "" && len(p.Classes) > 0 {
+		errs = append(errs, fmt.Errorf("priority header is required to classify requests"))
+	}
+
+	if p.Aging < 0 {
+		errs = append(errs, fmt.Errorf("priority aging must be >= 0"))
+	}
+
+	return
+}
+
+// ContentLengthBucket charges Cost for requests with a
+// content-length of at most MaxBytes
+type ContentLengthBucket struct {
+	MaxBytes int64 `json:"max_bytes"`
+	Cost     int   `json:"cost"`
+}
+
+// Func returns a rate.CostFunc which charges requests as described
+func (c Cost) Func() rate.CostFunc {
+	var (
+		buckets = make([]rate.ContentLengthBucket, 0, len(c.ContentLength))
+		def     = c.Default
+	)
+
+	if def == 0 {
+		def = 1
+	}
+
+	for _, bucket := range c.ContentLength {
+		buckets = append(buckets, rate.ContentLengthBucket(bucket))
+	}
+
+	var (
+		byLength = rate.ContentLengthCost(buckets, def)
+		byMethod = rate.MethodCost(c.Methods, -1)
+	)
+
+	return func(r *http.Request) int {
+		if cost := byMethod(r); cost > -1 {
+			return cost
+		}
+
+		return byLength(r)
+	}
+}
+
+func (c Cost) validate(limit int) (errs []error) {
+	check := func(name string, cost int) {
+		if cost < 0 || cost > limit {
+			errs = append(errs, fmt.Errorf("%s cost %d must be between 0 and the limit %d", name, cost, limit))
+		}
+	}
+
+	check("default", c.Default)
+
+	for method, cost := range c.Methods {
+		check(method, cost)
+	}
+
+	for _, bucket := range c.ContentLength {
+		check(fmt.Sprintf("content length <= %d", bucket.MaxBytes), bucket.Cost)
+	}
+
+	return
+}
+
+// Duration is a time.Duration which is represented in
+// JSON as a string understood by time.ParseDuration e.g. "1m"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(v []byte) error {
+	var str string
+	if err := json.Unmarshal(v, &str); err != nil {
+		return fmt.Errorf("duration must be a string e.g. \"1m\": %v", err)
+	}
+
+	dur, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(dur)
+
+	return nil
+}
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Errors is a collection of validation errors
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Load decodes a JSON encoded Config from the provided reader,
+// defaults any unset fields and validates the result
+func Load(r io.Reader) (Config, error) {
+	var config Config
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("decoding policy: %v", err)
+	}
+
+	config.setDefaults()
+
+	return config, config.Validate()
+}
+
+// LoadFile opens and loads the Config found at the provided path
+func LoadFile(path string) (Config, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	defer fi.Close()
+
+	return Load(fi)
+}
+
+func (c *Config) setDefaults() {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+
+		if rule.Period == 0 {
+			rule.Period = Duration(defaultPeriod)
+		}
+
+		if rule.Key == "" {
+			rule.Key = defaultKey
+		}
+
+		if rule.Behaviour == "" {
+			rule.Behaviour = BehaviourWait
+		}
+	}
+}
+
+// Validate returns an Errors containing every problem
+// found with the configured rules, or nil if there are none
+func (c Config) Validate() error {
+	var (
+		errs  Errors
+		names = map[string]struct{}{}
+	)
+
+	if len(c.Rules) == 0 {
+		errs = append(errs, fmt.Errorf("policy must contain at least one rule"))
+	}
+
+	for i, rule := range c.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+			errs = append(errs, fmt.Errorf("rule %s: name is required", name))
+		} else if _, ok := names[name]; ok {
+			errs = append(errs, fmt.Errorf("rule %q: name is not unique", name))
+		}
+
+		names[name] = struct{}{}
+
+		for _, err := range rule.validate() {
+			errs = append(errs, fmt.Errorf("rule %q: %v", name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (r Rule) validate() (errs []error) {
+	if r.Limit <= 0 {
+		errs = append(errs, fmt.Errorf("limit must be > 0"))
+	}
+
+	if r.Period <= 0 {
+		errs = append(errs, fmt.Errorf("period must be > 0"))
+	}
+
+	if _, err := rate.ParseKeyTemplate(r.Key); err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, r.Cost.validate(r.Limit)...)
+	errs = append(errs, r.Priority.validate()...)
+
+	if r.MaxWait < 0 {
+		errs = append(errs, fmt.Errorf("max_wait must be >= 0"))
+	}
+
+	if r.MaxQueue < 0 {
+		errs = append(errs, fmt.Errorf("max_queue must be >= 0"))
+	}
+
+	switch r.Behaviour {
+	case BehaviourWait, BehaviourReject:
+	default:
+		errs = append(errs, fmt.Errorf("behaviour %q must be one of %q or %q", r.Behaviour, BehaviourWait, BehaviourReject))
+	}
+
+	if r.Match.Path != "" {
+		if _, err := path.Match(r.Match.Path, "/"); err != nil {
+			errs = append(errs, fmt.Errorf("path %q: %v", r.Match.Path, err))
+		}
+	}
+
+	for _, method := range r.Match.Methods {
+		if method == "" || method != strings.ToUpper(method) {
+			errs = append(errs, fmt.Errorf("method %q must be upper case", method))
+		}
+	}
+
+	return
+}
+
+// Matches returns true if the request satisfies every
+// condition of the match
+func (m Match) Matches(r *http.Request) bool {
+	if m.Host != "" && !strings.EqualFold(m.Host, hostname(r.Host)) {
+		return false
+	}
+
+	if m.Path != "" {
+		if ok, _ := path.Match(m.Path, r.URL.Path); !ok {
+			return false
+		}
+	}
+
+	if m.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, m.PathPrefix) {
+		return false
+	}
+
+	if len(m.Methods) > 0 && !contains(m.Methods, r.Method) {
+		return false
+	}
+
+	for name, value := range m.Headers {
+		if r.Header.Get(name) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hostname(host string) string {
+	if idx := strings.LastIndex(host, ":"); idx > -1 && !strings.Contains(host[idx:], "]") {
+		return host[:idx]
+	}
+
+	return host
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
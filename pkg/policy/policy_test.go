@@ -0,0 +1,145 @@
+package policy
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadFile_Example(t *testing.T) {
+	config, err := LoadFile("../../hack/policy.example.json")
+	require.Nil(t, err)
+
+	require.Len(t, config.Rules, 4)
+
+	exports := config.Rules[0]
+	assert.Equal(t, "exports", exports.Name)
+	assert.Equal(t, 10, exports.Limit)
+	assert.Equal(t, Duration(time.Minute), exports.Period)
+	assert.Equal(t, BehaviourReject, exports.Behaviour)
+
+	// defaults are applied to unset fields
+	uploads := config.Rules[1]
+	assert.Equal(t, 100, uploads.Cost.Default)
+	assert.Equal(t, map[string]int{"GET": 1}, uploads.Cost.Methods)
+	assert.Equal(t, []ContentLengthBucket{{1048576, 5}, {104857600, 50}}, uploads.Cost.ContentLength)
+
+	freeTier := config.Rules[2]
+	assert.Equal(t, Duration(10*time.Second), freeTier.MaxWait)
+	assert.Equal(t, 20, freeTier.MaxQueue)
+	assert.Equal(t, Priority{
+		Header:  "X-Priority",
+		Classes: map[string]int{"high": 2, "low": 0},
+		Default: 1,
+		Aging:   Duration(2 * time.Second),
+	}, freeTier.Priority)
+
+	static := config.Rules[3]
+	assert.Equal(t, Duration(10*time.Second), static.Period)
+	assert.Equal(t, defaultKey, static.Key)
+	assert.Equal(t, BehaviourWait, static.Behaviour)
+}
+
+func Test_LoadFile_Missing(t *testing.T) {
+	_, err := LoadFile("missing.json")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_Load_Invalid(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		policy string
+		errors []string
+	}{
+		{"malformed", `{"rules": [`, []string{"decoding policy"}},
+		{"unknown field", `{"rules": [{"name": "foo", "limit": 1, "burst": 2}]}`, []string{"unknown field"}},
+		{"bad duration", `{"rules": [{"name": "foo", "limit": 1, "period": "soon"}]}`, []string{"invalid duration"}},
+		{"no rules", `{"rules": []}`, []string{"at least one rule"}},
+		{
+			"invalid rules",
+			`{"rules": [
+				{"limit": 1},
+				{"name": "foo", "limit": 0, "key": "{nope}", "behaviour": "drop"},
+				{"name": "foo", "limit": 1, "match": {"path": "/[", "methods": ["get"]}},
+				{"name": "bar", "limit": 2, "cost": {"default": 3, "methods": {"POST": -1}}},
+				{"name": "baz", "limit": 1, "max_wait": "-1s", "max_queue": -1},
+				{"name": "qux", "limit": 1, "priority": {"classes": {"paid": 1}, "aging": "-1s"}}
+			]}`,
+			[]string{
+				`rule #0: name is required`,
+				`rule "foo": limit must be > 0`,
+				`rule "foo": key template "{nope}": unknown placeholder "nope"`,
+				`rule "foo": behaviour "drop" must be one of "wait" or "reject"`,
+				`rule "foo": name is not unique`,
+				`rule "foo": path "/[": syntax error in pattern`,
+				`rule "foo": method "get" must be upper case`,
+				`rule "bar": default cost 3 must be between 0 and the limit 2`,
+				`rule "bar": POST cost -1 must be between 0 and the limit 2`,
+				`rule "baz": max_wait must be >= 0`,
+				`rule "baz": max_queue must be >= 0`,
+				`rule "qux": priority header is required to classify requests`,
+				`rule "qux": priority aging must be >= 0`,
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(test.policy))
+			require.Error(t, err)
+
+			for _, msg := range test.errors {
+				assert.Contains(t, err.Error(), msg)
+			}
+		})
+	}
+}
+
+func Test_Match(t *testing.T) {
+	match := Match{
+		Host:       "example.com",
+		Path:       "/users/*/posts",
+		PathPrefix: "/users/",
+		Methods:    []string{"GET", "HEAD"},
+		Headers:    map[string]string{"X-Tier": "free"},
+	}
+
+	newRequest := func(method, host, path string, header http.Header) *http.Request {
+		return &http.Request{Method: method, Host: host, URL: &url.URL{Path: path}, Header: header}
+	}
+
+	free := http.Header{"X-Tier": []string{"free"}}
+
+	assert.True(t, match.Matches(newRequest("GET", "example.com", "/users/123/posts", free)))
+	assert.True(t, match.Matches(newRequest("HEAD", "Example.com:8080", "/users/123/posts", free)))
+
+	assert.False(t, match.Matches(newRequest("POST", "example.com", "/users/123/posts", free)))
+	assert.False(t, match.Matches(newRequest("GET", "other.com", "/users/123/posts", free)))
+	assert.False(t, match.Matches(newRequest("GET", "example.com", "/users/123/posts/456", free)))
+	assert.False(t, match.Matches(newRequest("GET", "example.com", "/users/123/posts", http.Header{})))
+
+	// empty match matches every request
+	assert.True(t, Match{}.Matches(newRequest("DELETE", "other.com", "/", http.Header{})))
+}
+
+func Test_Cost(t *testing.T) {
+	var (
+		cost = Cost{
+			Methods:       map[string]int{"GET": 1},
+			ContentLength: []ContentLengthBucket{{MaxBytes: 1024, Cost: 5}},
+		}.Func()
+		newRequest = func(method string, length int64) *http.Request {
+			return &http.Request{Method: method, ContentLength: length}
+		}
+	)
+
+	// methods take precedence
+	assert.Equal(t, 1, cost(newRequest("GET", 1024)))
+	assert.Equal(t, 5, cost(newRequest("POST", 1024)))
+	// unset default is a single token
+	assert.Equal(t, 1, cost(newRequest("POST", 1025)))
+}
@@ -0,0 +1,139 @@
+package policy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+)
+
+// AcquirerFactory constructs the Acquirer which enforces
+// the provided limit per key for every period
+type AcquirerFactory func(limit int, period time.Duration) (rate.Acquirer, error)
+
+// Router is a http.Handler which routes each request to a
+// rate.Limiter configured by the first rule the request matches
+// Requests which match no rule are delegated to the fallback handler
+type Router struct {
+	routes   []route
+	fallback http.Handler
+
+	ruleHeader     string
+	limiterOptions rate.Options
+}
+
+type route struct {
+	rule    Rule
+	limiter rate.Limiter
+}
+
+// RouterOption is a functional option for the Router type
+type RouterOption func(*Router)
+
+// WithRuleHeader configures the Router to set a response header
+// with the provided name to the name of the rule which matched
+// This is intended to aid debugging policies
+func WithRuleHeader(name string) RouterOption {
+	return func(r *Router) {
+		r.ruleHeader = name
+	}
+}
+
+// WithLimiterOptions applies the provided options to the rate.Limiter
+// constructed for every rule, before the options derived from the rule
+func WithLimiterOptions(opts ...rate.Option) RouterOption {
+	return func(r *Router) {
+		r.limiterOptions = append(r.limiterOptions, opts...)
+	}
+}
+
+// NewRouter validates the provided config and constructs a Router
+// with a rate.Limiter per rule, each delegating to proxy once acquired
+func NewRouter(config Config, proxy, fallback http.Handler, factory AcquirerFactory, opts ...RouterOption) (*Router, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	router := &Router{fallback: fallback}
+	for _, opt := range opts {
+		opt(router)
+	}
+
+	for _, rule := range config.Rules {
+		limiter, err := router.limiter(rule, proxy, factory)
+		if err != nil {
+			return nil, err
+		}
+
+		router.routes = append(router.routes, route{rule, limiter})
+	}
+
+	return router, nil
+}
+
+func (r *Router) limiter(rule Rule, proxy http.Handler, factory AcquirerFactory) (rate.Limiter, error) {
+	period := time.Duration(rule.Period)
+
+	acquirer, err := factory(rule.Limit, period)
+	if err != nil {
+		return rate.Limiter{}, err
+	}
+
+	keyFunc, err := rate.ParseKeyTemplate(rule.Key)
+	if err != nil {
+		return rate.Limiter{}, err
+	}
+
+	opts := append(rate.Options{}, r.limiterOptions...)
+	opts = append(opts,
+		rate.WithWaiter(rate.NextIntervalWaiter(period)),
+		// namespace keys by rule so that rules sharing
+		// a backend do not share limits
+		rate.WithKeyFunc(func(req *http.Request) string {
+			return rule.Name + ":" + keyFunc(req)
+		}),
+	)
+
+	opts = append(opts,
+		rate.WithCost(rule.Cost.Func()),
+		rate.WithRetryAfter(rate.NextInterval(period)),
+	)
+
+	// rules which do not bound waiting inherit the limiter options
+	if rule.MaxWait > 0 {
+		opts = append(opts, rate.WithMaxWait(time.Duration(rule.MaxWait)))
+	}
+
+	if rule.MaxQueue > 0 {
+		opts = append(opts, rate.WithMaxQueue(rule.MaxQueue))
+	}
+
+	if rule.Priority.Header != "" {
+		opts = append(opts, rate.WithPriority(rule.Priority.Func(), time.Duration(rule.Priority.Aging)))
+	}
+
+	if rule.Behaviour == BehaviourReject {
+		opts = append(opts, rate.WithReject())
+	}
+
+	return rate.NewLimiter(proxy, acquirer, opts...), nil
+}
+
+// ServeHTTP delegates the request to the limiter of the first
+// matching rule, or the fallback handler if there is no match
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, route := range r.routes {
+		if !route.rule.Match.Matches(req) {
+			continue
+		}
+
+		if r.ruleHeader != "" {
+			w.Header().Set(r.ruleHeader, route.rule.Name)
+		}
+
+		route.limiter.ServeHTTP(w, req)
+		return
+	}
+
+	r.fallback.ServeHTTP(w, req)
+}
@@ -0,0 +1,126 @@
+package policy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `{
+	"rules": [
+		{
+			"name": "exports",
+			"match": {"path_prefix": "/exports/", "methods": ["POST"]},
+			"limit": 1,
+			"key": "{header:X-Api-Key}",
+			"behaviour": "reject"
+		},
+		{
+			"name": "default",
+			"match": {"path_prefix": "/api/"},
+			"limit": 5,
+			"period": "1h",
+			"max_wait": "10ms"
+		}
+	]
+}`
+
+type countingAcquirer struct {
+	limit  int
+	counts map[string]int
+	mu     sync.Mutex
+}
+
+func (a *countingAcquirer) Acquire(_ context.Context, key string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.counts[key] >= a.limit {
+		return false, nil
+	}
+
+	a.counts[key]++
+
+	return true, nil
+}
+
+func Test_Router(t *testing.T) {
+	config, err := Load(strings.NewReader(testPolicy))
+	require.Nil(t, err)
+
+	var (
+		acquirers = map[int]*countingAcquirer{}
+		periods   = map[int]time.Duration{}
+		factory   = func(limit int, period time.Duration) (rate.Acquirer, error) {
+			acquirer := &countingAcquirer{limit: limit, counts: map[string]int{}}
+			acquirers[limit], periods[limit] = acquirer, period
+			return acquirer, nil
+		}
+		proxy    = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+		fallback = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusAccepted) })
+	)
+
+	router, err := NewRouter(config, proxy, fallback, factory, WithRuleHeader("X-Rate-Rule"))
+	require.Nil(t, err)
+
+	assert.Equal(t, time.Minute, periods[1])
+	assert.Equal(t, time.Hour, periods[5])
+
+	serve := func(method, path, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Api-Key", apiKey)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := serve("POST", "/exports/users", "foo")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "exports", rec.Header().Get("X-Rate-Rule"))
+
+	// exports limit is exhausted for api key foo and the rule rejects
+	rec = serve("POST", "/exports/orders", "foo")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "exports", rec.Header().Get("X-Rate-Rule"))
+
+	// but not for api key bar
+	rec = serve("POST", "/exports/orders", "bar")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve("GET", "/api/users", "foo")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "default", rec.Header().Get("X-Rate-Rule"))
+
+	// requests are rejected once they have waited for the maximum
+	// and are told to retry when the hourly period is refilled
+	assert.Equal(t, http.StatusOK, serve("GET", "/api/orders", "foo").Code)
+	acquirers[5].counts["default:/api/orders"] = 5
+
+	rec = serve("GET", "/api/orders", "foo")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// no rule matches
+	rec = serve("GET", "/exports/users", "foo")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Empty(t, rec.Header().Get("X-Rate-Rule"))
+
+	// keys are namespaced by rule name
+	assert.Equal(t, map[string]int{"exports:foo": 1, "exports:bar": 1}, acquirers[1].counts)
+	assert.Equal(t, map[string]int{"default:/api/users": 1, "default:/api/orders": 5}, acquirers[5].counts)
+}
+
+func Test_NewRouter_Invalid(t *testing.T) {
+	_, err := NewRouter(Config{}, nil, nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package rate
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errTooManyRequests is returned when a request is rejected
+// rather than waiting for tokens
+var errTooManyRequests = errors.New("too many requests")
+
+// RetryAfterFunc returns how long a client should wait before
+// retrying a rejected request for the provided key
+type RetryAfterFunc func(key string) time.Duration
+
+// NextInterval returns a RetryAfterFunc which returns the time
+// remaining until the next interval e.g. the next refill of a
+// fixed window
+func NextInterval(interval time.Duration) RetryAfterFunc {
+	return func(string) time.Duration {
+		now := time.Now()
+		return now.Add(interval).Truncate(interval).Sub(now)
+	}
+}
+
+// FixedRetryAfter returns a RetryAfterFunc which always returns d
+// e.g. the time taken to regain a single token
+func FixedRetryAfter(d time.Duration) RetryAfterFunc {
+	return func(string) time.Duration { return d }
+}
+
+// retryAfterSeconds formats d as a Retry-After value of whole seconds
+// rounded up, so that clients never retry before d has passed
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return strconv.FormatInt(seconds, 10)
+}
+
+// parseWait parses a maximum wait requested by a client which is
+// either a duration (e.g. 500ms) or a whole number of seconds
+// Only waits greater than zero are understood
+func parseWait(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		seconds, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+
+		d = time.Duration(seconds) * time.Second
+	}
+
+	return d, d > 0
+}
+
+// queue counts the requests waiting per key and bounds
+// them to a maximum depth
+type queue struct {
+	mu     sync.Mutex
+	max    int
+	counts map[string]int
+}
+
+func newQueue(max int) *queue {
+	return &queue{max: max, counts: map[string]int{}}
+}
+
+// enter returns true if the request may wait for key
+// in which case leave must be called once it stops waiting
+// A nil queue is unbounded
+func (q *queue) enter(key string) bool {
+	if q == nil {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.counts[key] >= q.max {
+		return false
+	}
+
+	q.counts[key]++
+
+	return true
+}
+
+// leave removes a request which entered for key
+func (q *queue) leave(key string) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.counts[key]--; q.counts[key] <= 0 {
+		delete(q.counts, key)
+	}
+}
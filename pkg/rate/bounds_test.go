@@ -0,0 +1,134 @@
+package rate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Limiter_MaxWait(t *testing.T) {
+	var (
+		proxy    = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+		acquirer = newLocalAcquirer(1)
+		limiter  = NewLimiter(proxy, acquirer,
+			WithWaiter(newWaiter()),
+			WithMaxWait(10*time.Millisecond),
+			WithRetryAfter(FixedRetryAfter(1500*time.Millisecond)))
+	)
+
+	rec := httptest.NewRecorder()
+	limiter.ServeHTTP(rec, request(t, "/foo"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the waiter is never woken so the maximum wait is exceeded
+	rec = httptest.NewRecorder()
+	limiter.ServeHTTP(rec, request(t, "/foo"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+}
+
+func Test_Limiter_MaxWaitHeader(t *testing.T) {
+	var (
+		proxy    = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+		acquirer = newLocalAcquirer(0)
+		limiter  = NewLimiter(proxy, acquirer,
+			WithWaiter(newWaiter()),
+			WithMaxWait(time.Hour),
+			WithMaxWaitHeader("X-Max-Wait"))
+	)
+
+	req := request(t, "/foo")
+	req.Header = http.Header{"X-Max-Wait": []string{"10ms"}}
+
+	// the client requested a shorter wait than the limiter permits
+	rec := httptest.NewRecorder()
+	limiter.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+}
+
+func Test_Limiter_MaxQueue(t *testing.T) {
+	var (
+		proxy    = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+		acquirer = newLocalAcquirer(0)
+		waiter   = newWaiter()
+		limiter  = NewLimiter(proxy, acquirer, WithWaiter(waiter), WithMaxQueue(1))
+		done     = make(chan int)
+	)
+
+	go func() {
+		rec := httptest.NewRecorder()
+		limiter.ServeHTTP(rec, request(t, "/foo"))
+		done <- rec.Code
+	}()
+
+	// wait for the first request to be queued
+	for {
+		limiter.queue.mu.Lock()
+		queued := limiter.queue.counts["/foo"]
+		limiter.queue.mu.Unlock()
+
+		if queued == 1 {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	// the queue for the key is full
+	rec := httptest.NewRecorder()
+	limiter.ServeHTTP(rec, request(t, "/foo"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// once tokens are available the queued request is served
+	acquirer.mu.Lock()
+	acquirer.count = 1
+	acquirer.mu.Unlock()
+
+	waiter.wake(1)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	// and leaves the queue
+	assert.Empty(t, limiter.queue.counts)
+}
+
+func Test_Limiter_Reject_RetryAfter(t *testing.T) {
+	var (
+		proxy   = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+		limiter = NewLimiter(proxy, newLocalAcquirer(0), WithReject())
+		rec     = httptest.NewRecorder()
+	)
+
+	limiter.ServeHTTP(rec, request(t, "/foo"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// by default clients retry at the next minute
+	seconds := rec.Header().Get("Retry-After")
+	require.NotEmpty(t, seconds)
+	assert.True(t, len(seconds) <= 2)
+}
+
+func Test_parseWait(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"500ms", 500 * time.Millisecond, true},
+		{"5", 5 * time.Second, true},
+		{"0", 0, false},
+		{"-1s", -time.Second, false},
+		{"soon", 0, false},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			wait, ok := parseWait(test.value)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.expected, wait)
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package rate
+
+import "context"
+
+// Refunder is an Acquirer which can return n tokens it previously
+// granted for a key
+// Combinators use it to hand back tokens which were acquired by
+// some children when the combined acquisition was not successful
+type Refunder interface {
+	Refund(ctxt context.Context, key string, n int) error
+}
+
+// RefundFunc returns tokens which were previously acquired
+type RefundFunc func(context.Context) error
+
+// Claimer is a Refunder whose tokens belong to the interval they
+// were acquired from
+// ClaimN acquires n tokens for key and returns a RefundFunc which
+// returns them to that interval rather than to the current one
+// The RefundFunc is only called given all n tokens were acquired
+type Claimer interface {
+	ClaimN(ctxt context.Context, key string, n int) (RefundFunc, bool, error)
+}
+
+// ClaimN acquires n tokens for key from the provided Acquirer and
+// returns a RefundFunc which returns them
+// It delegates to ClaimN when the acquirer implements Claimer and
+// otherwise acquires the tokens via AcquireN, in which case they are
+// refunded via Refund given the acquirer is a Refunder
+func ClaimN(ctxt context.Context, acquirer Acquirer, key string, n int) (RefundFunc, bool, error) {
+	if claimer, ok := acquirer.(Claimer); ok {
+		return claimer.ClaimN(ctxt, key, n)
+	}
+
+	acquired, err := AcquireN(ctxt, acquirer, key, n)
+
+	return func(ctxt context.Context) error {
+		return refund(ctxt, acquirer, key, n)
+	}, acquired, err
+}
+
+// Refunds is a RefundFunc for every claim of a combined acquisition
+type Refunds []RefundFunc
+
+// Refund calls every RefundFunc in reverse order
+// The first error encountered is returned once all have been called
+func (r Refunds) Refund(ctxt context.Context) (err error) {
+	for i := len(r) - 1; i >= 0; i-- {
+		if rerr := r[i](ctxt); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+
+	return
+}
+
+// Keyed returns an Acquirer which derives its own key from the
+// request being limited using the provided KeyFunc
+// The request is obtained from the context passed to Acquire
+// If no request is present the provided key is used unchanged
+func Keyed(acquirer Acquirer, fn KeyFunc) Acquirer {
+	return keyed{acquirer, fn}
+}
+
+type keyed struct {
+	acquirer Acquirer
+	fn       KeyFunc
+}
+
+func (k keyed) key(ctxt context.Context, key string) string {
+	if r, ok := RequestFromContext(ctxt); ok {
+		return k.fn(r)
+	}
+
+	return key
+}
+
+// Acquire delegates to the wrapped Acquirer using the derived key
+func (k keyed) Acquire(ctxt context.Context, key string) (bool, error) {
+	return k.acquirer.Acquire(ctxt, k.key(ctxt, key))
+}
+
+// AcquireN delegates to the wrapped Acquirer using the derived key
+func (k keyed) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	return AcquireN(ctxt, k.acquirer, k.key(ctxt, key), n)
+}
+
+// WaitN delegates to the wrapped Acquirer using the derived key
+// given it is a WaitAcquirer
+func (k keyed) WaitN(ctxt context.Context, key string, n int) error {
+	return WaitN(ctxt, k.acquirer, k.key(ctxt, key), n)
+}
+
+// ReserveN delegates to the wrapped Acquirer using the derived key
+// given it is a Reserver
+func (k keyed) ReserveN(ctxt context.Context, key string, n int) (Reservation, error) {
+	return ReserveN(ctxt, k.acquirer, k.key(ctxt, key), n)
+}
+
+// ClaimN delegates to the wrapped Acquirer using the derived key
+func (k keyed) ClaimN(ctxt context.Context, key string, n int) (RefundFunc, bool, error) {
+	return ClaimN(ctxt, k.acquirer, k.key(ctxt, key), n)
+}
+
+// Lease delegates to the wrapped Acquirer using the derived key
+func (k keyed) Lease(ctxt context.Context, key string, n int) (Lease, bool, error) {
+	return LeaseN(ctxt, k.acquirer, k.key(ctxt, key), n)
+}
+
+// Refund delegates to the wrapped Acquirer using the derived key
+// given it is a Refunder
+func (k keyed) Refund(ctxt context.Context, key string, n int) error {
+	return refund(ctxt, k.acquirer, k.key(ctxt, key), n)
+}
+
+// Status delegates to the wrapped Acquirer using the derived key
+// given it is a Reporter
+func (k keyed) Status(ctxt context.Context, key string) (Status, error) {
+	return StatusOf(ctxt, k.acquirer, k.key(ctxt, key))
+}
+
+// AllAcquirer requires every child Acquirer to grant a token
+type AllAcquirer []Acquirer
+
+// All returns an Acquirer which only grants when every one
+// of the provided acquirers grants
+// Children are consulted in order and consultation stops at the
+// first child which denies or errors
+// Tokens which were granted by earlier children are then refunded
+// in reverse order, for those children which implement Refunder
+// Children which cannot refund keep the token they granted until
+// their next refill, so the most restrictive children should
+// be provided first
+func All(acquirers ...Acquirer) AllAcquirer {
+	return AllAcquirer(acquirers)
+}
+
+// Acquire returns true if all the children grant a token for key
+func (a AllAcquirer) Acquire(ctxt context.Context, key string) (bool, error) {
+	return a.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN returns true if all the children grant n tokens for key
+func (a AllAcquirer) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	_, acquired, err := a.ClaimN(ctxt, key, n)
+	return acquired, err
+}
+
+// ClaimN returns true if all the children grant n tokens for key
+// The returned RefundFunc returns the tokens of every child to the
+// interval they were acquired from, as do the refunds made when a
+// child denies or errors
+func (a AllAcquirer) ClaimN(ctxt context.Context, key string, n int) (RefundFunc, bool, error) {
+	refunds := make(Refunds, 0, len(a))
+	for _, acquirer := range a {
+		refund, acquired, err := ClaimN(ctxt, acquirer, key, n)
+		if err == nil && acquired {
+			refunds = append(refunds, refund)
+			continue
+		}
+
+		if rerr := refunds.Refund(ctxt); err == nil {
+			// surface any failure to return tokens when the
+			// child simply denied the request
+			err = rerr
+		}
+
+		return nil, false, err
+	}
+
+	return refunds.Refund, true, nil
+}
+
+// Lease returns true if all the children grant n tokens for key
+// The returned Lease releases the tokens of every child which
+// implements Leaser
+func (a AllAcquirer) Lease(ctxt context.Context, key string, n int) (Lease, bool, error) {
+	leases := make(Leases, 0, len(a))
+	for _, acquirer := range a {
+		lease, acquired, err := LeaseN(ctxt, acquirer, key, n)
+		if err == nil && acquired {
+			leases = append(leases, lease)
+			continue
+		}
+
+		if rerr := leases.revoke(ctxt); err == nil {
+			// surface any failure to return tokens when the
+			// child simply denied the request
+			err = rerr
+		}
+
+		return nil, false, err
+	}
+
+	return leases, true, nil
+}
+
+// Refund returns n tokens to every child in reverse order
+// The first error encountered is returned once all children
+// have been attempted
+// Children return the tokens to their current interval, so ClaimN
+// should be preferred when the tokens are refunded shortly after
+func (a AllAcquirer) Refund(ctxt context.Context, key string, n int) (err error) {
+	for i := len(a) - 1; i >= 0; i-- {
+		if rerr := refund(ctxt, a[i], key, n); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+
+	return
+}
+
+// Status returns the status of the most restrictive child
+// Children which do not implement Reporter are ignored and
+// ErrStatusNotSupported is returned when none of them do
+func (a AllAcquirer) Status(ctxt context.Context, key string) (Status, error) {
+	var (
+		status   Status
+		reported bool
+	)
+
+	for _, acquirer := range a {
+		child, err := StatusOf(ctxt, acquirer, key)
+		if err == ErrStatusNotSupported {
+			continue
+		}
+
+		if err != nil {
+			return Status{}, err
+		}
+
+		if !reported {
+			status, reported = child, true
+			continue
+		}
+
+		status = status.Min(child)
+	}
+
+	if !reported {
+		return Status{}, ErrStatusNotSupported
+	}
+
+	return status, nil
+}
+
+// AnyAcquirer requires any one child Acquirer to grant a token
+type AnyAcquirer []Acquirer
+
+// Any returns an Acquirer which grants when one of the
+// provided acquirers grants
+// Children are consulted in order and consultation stops at the
+// first child which grants, so at most one token is consumed
+// A child which errors is skipped, the error is only returned
+// when no child grants
+// AnyAcquirer cannot refund as it does not record which child granted
+func Any(acquirers ...Acquirer) AnyAcquirer {
+	return AnyAcquirer(acquirers)
+}
+
+// Acquire returns true if any of the children grant a token for key
+func (a AnyAcquirer) Acquire(ctxt context.Context, key string) (bool, error) {
+	return a.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN returns true if any of the children grant n tokens for key
+func (a AnyAcquirer) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	var firstErr error
+	for _, acquirer := range a {
+		acquired, err := AcquireN(ctxt, acquirer, key, n)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		if acquired {
+			return true, nil
+		}
+	}
+
+	return false, firstErr
+}
+
+// FallbackAcquirer consults a secondary Acquirer when the primary errors
+type FallbackAcquirer struct {
+	primary, secondary Acquirer
+}
+
+// Fallback returns an Acquirer which delegates to primary and
+// only consults secondary when primary returns an error
+// e.g. a shared etcd backed acquirer falling back to a local
+// in-memory acquirer when etcd is unavailable
+// A denial from primary is final and secondary is not consulted
+func Fallback(primary, secondary Acquirer) FallbackAcquirer {
+	return FallbackAcquirer{primary, secondary}
+}
+
+// Acquire delegates to the primary Acquirer and to the
+// secondary when the primary errors
+func (f FallbackAcquirer) Acquire(ctxt context.Context, key string) (bool, error) {
+	return f.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN delegates to the primary Acquirer and to the
+// secondary when the primary errors
+func (f FallbackAcquirer) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	acquired, err := AcquireN(ctxt, f.primary, key, n)
+	if err != nil {
+		return AcquireN(ctxt, f.secondary, key, n)
+	}
+
+	return acquired, nil
+}
+
+// Status delegates to the primary Acquirer and to the
+// secondary when the primary errors
+func (f FallbackAcquirer) Status(ctxt context.Context, key string) (Status, error) {
+	status, err := StatusOf(ctxt, f.primary, key)
+	if err != nil && err != ErrStatusNotSupported {
+		return StatusOf(ctxt, f.secondary, key)
+	}
+
+	return status, err
+}
+
+func refund(ctxt context.Context, acquirer Acquirer, key string, n int) error {
+	if refunder, ok := acquirer.(Refunder); ok {
+		return refunder.Refund(ctxt, key, n)
+	}
+
+	return nil
+}
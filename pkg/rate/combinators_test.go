@@ -0,0 +1,149 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+func Test_All(t *testing.T) {
+	var (
+		path   = newLocalAcquirer(3)
+		client = newLocalAcquirer(1)
+		ctxt   = context.Background()
+		all    = All(path, client)
+	)
+
+	acquired, err := all.Acquire(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// client acquirer is exhausted so the combination is denied
+	acquired, err = all.Acquire(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.False(t, acquired)
+
+	// the token granted by the path acquirer has been refunded
+	assert.Equal(t, 1, path.countFor("/foo"))
+	assert.Equal(t, 1, client.countFor("/foo"))
+}
+
+func Test_All_Claim(t *testing.T) {
+	var (
+		path   = newClaimingAcquirer(3)
+		client = newLocalAcquirer(1)
+		ctxt   = context.Background()
+		all    = All(path, client)
+	)
+
+	refund, acquired, err := all.ClaimN(ctxt, "/foo", 1)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// the path acquirer moves on to its next interval
+	path.interval++
+
+	acquired, err = path.Acquire(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// tokens are refunded to the interval they were claimed from
+	require.Nil(t, refund(ctxt))
+	assert.Equal(t, 0, path.countFor("/foo/0"))
+	assert.Equal(t, 1, path.countFor("/foo/1"))
+	assert.Equal(t, 0, client.countFor("/foo"))
+}
+
+func Test_All_Error(t *testing.T) {
+	var (
+		path   = newLocalAcquirer(3)
+		broken = &staticAcquirer{err: errUnavailable}
+		never  = &staticAcquirer{acquired: true}
+		all    = All(path, broken, never)
+	)
+
+	acquired, err := all.Acquire(context.Background(), "/foo")
+	assert.Equal(t, errUnavailable, err)
+	assert.False(t, acquired)
+
+	assert.Equal(t, 0, path.countFor("/foo"))
+	// children after the failure are not consulted
+	assert.Equal(t, 0, never.calls)
+}
+
+func Test_Any(t *testing.T) {
+	var (
+		broken = &staticAcquirer{err: errUnavailable}
+		denied = &staticAcquirer{}
+		local  = newLocalAcquirer(1)
+		never  = &staticAcquirer{acquired: true}
+		ctxt   = context.Background()
+		any    = Any(broken, denied, local, never)
+	)
+
+	acquired, err := any.Acquire(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.True(t, acquired)
+	// consultation stops at the first grant
+	assert.Equal(t, 0, never.calls)
+
+	// all errors are only surfaced when no child grants
+	acquired, err = Any(broken, denied).Acquire(ctxt, "/foo")
+	assert.Equal(t, errUnavailable, err)
+	assert.False(t, acquired)
+}
+
+func Test_Fallback(t *testing.T) {
+	var (
+		broken    = &staticAcquirer{err: errUnavailable}
+		denied    = &staticAcquirer{}
+		secondary = &staticAcquirer{acquired: true}
+		ctxt      = context.Background()
+	)
+
+	acquired, err := Fallback(broken, secondary).Acquire(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, 1, secondary.calls)
+
+	// denials from the primary are final
+	acquired, err = Fallback(denied, secondary).Acquire(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.False(t, acquired)
+	assert.Equal(t, 1, secondary.calls)
+}
+
+func Test_Limiter_Combined(t *testing.T) {
+	var (
+		proxiedCount int
+		proxy        = http.HandlerFunc(func(http.ResponseWriter, *http.Request) { proxiedCount++ })
+		path         = newLocalAcquirer(2)
+		client       = newLocalAcquirer(1)
+		global       = newLocalAcquirer(10)
+		acquirer     = All(
+			path,
+			Keyed(client, HeaderKey("X-Api-Key")),
+			Keyed(global, func(*http.Request) string { return "global" }),
+		)
+		limiter = NewLimiter(proxy, acquirer, WithWaiter(newWaiter()))
+	)
+
+	for _, apiKey := range []string{"foo", "bar"} {
+		req := request(t, "/foo/bar")
+		req.Header = http.Header{"X-Api-Key": []string{apiKey}}
+
+		limiter.ServeHTTP(nil, req)
+	}
+
+	assert.Equal(t, 2, proxiedCount)
+	assert.Equal(t, 2, path.countFor("/foo/bar"))
+	assert.Equal(t, 1, client.countFor("foo"))
+	assert.Equal(t, 1, client.countFor("bar"))
+	assert.Equal(t, 2, global.countFor("global"))
+}
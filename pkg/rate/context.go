@@ -0,0 +1,22 @@
+package rate
+
+import (
+	"context"
+	"net/http"
+)
+
+type requestContextKey struct{}
+
+// ContextWithRequest returns a copy of the provided context which
+// carries the request being limited
+// The Limiter uses it so that Acquirers can derive their own keys
+func ContextWithRequest(ctxt context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctxt, requestContextKey{}, r)
+}
+
+// RequestFromContext returns the request being limited if
+// one has been stored on the provided context
+func RequestFromContext(ctxt context.Context) (*http.Request, bool) {
+	r, ok := ctxt.Value(requestContextKey{}).(*http.Request)
+	return r, ok
+}
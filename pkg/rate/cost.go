@@ -0,0 +1,94 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+)
+
+var (
+	// ErrCostNotSupported is returned by AcquireN when more than one token
+	// is requested from an Acquirer which does not implement NAcquirer
+	ErrCostNotSupported = errors.New("acquirer does not support acquiring more than one token")
+
+	// ErrCostExceedsLimit is returned by acquirers when more tokens are
+	// requested at once than their limit would ever permit
+	ErrCostExceedsLimit = errors.New("cost exceeds limit")
+)
+
+// NAcquirer is an Acquirer which can acquire a number of tokens
+// for a key at once
+// It should return true only if all n tokens were acquired
+// in which case they have all been consumed
+type NAcquirer interface {
+	AcquireN(ctxt context.Context, key string, n int) (bool, error)
+}
+
+// AcquireN acquires n tokens for key from the provided Acquirer
+// It delegates to Acquire when n is 1 and otherwise requires
+// the acquirer to implement NAcquirer
+func AcquireN(ctxt context.Context, acquirer Acquirer, key string, n int) (bool, error) {
+	if nacquirer, ok := acquirer.(NAcquirer); ok {
+		return nacquirer.AcquireN(ctxt, key, n)
+	}
+
+	if n == 1 {
+		return acquirer.Acquire(ctxt, key)
+	}
+
+	return false, ErrCostNotSupported
+}
+
+// CostFunc returns the number of tokens a request costs
+// A cost less than 1 means the request is not limited
+type CostFunc func(*http.Request) int
+
+// FixedCost returns a CostFunc which always costs n
+func FixedCost(n int) CostFunc {
+	return func(*http.Request) int { return n }
+}
+
+// MethodCost returns a CostFunc which costs requests by their method
+// Methods which are not present in costs are charged the fallback
+func MethodCost(costs map[string]int, fallback int) CostFunc {
+	return func(r *http.Request) int {
+		if cost, ok := costs[r.Method]; ok {
+			return cost
+		}
+
+		return fallback
+	}
+}
+
+// ContentLengthBucket charges Cost for requests with a
+// content-length of at most MaxBytes
+type ContentLengthBucket struct {
+	MaxBytes int64
+	Cost     int
+}
+
+// ContentLengthCost returns a CostFunc which costs requests by the
+// first bucket which can contain their content-length
+// Requests of unknown length, or which are larger than every
+// bucket, are charged the fallback
+func ContentLengthCost(buckets []ContentLengthBucket, fallback int) CostFunc {
+	buckets = append([]ContentLengthBucket(nil), buckets...)
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].MaxBytes < buckets[j].MaxBytes
+	})
+
+	return func(r *http.Request) int {
+		if r.ContentLength < 0 {
+			return fallback
+		}
+
+		for _, bucket := range buckets {
+			if r.ContentLength <= bucket.MaxBytes {
+				return bucket.Cost
+			}
+		}
+
+		return fallback
+	}
+}
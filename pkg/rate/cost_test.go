@@ -0,0 +1,85 @@
+package rate
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CostFuncs(t *testing.T) {
+	var (
+		byMethod = MethodCost(map[string]int{"POST": 5, "OPTIONS": 0}, 1)
+		byLength = ContentLengthCost([]ContentLengthBucket{
+			{MaxBytes: 1 << 20, Cost: 10},
+			{MaxBytes: 1 << 10, Cost: 1},
+		}, 20)
+		newRequest = func(method string, length int64) *http.Request {
+			return &http.Request{Method: method, ContentLength: length}
+		}
+	)
+
+	assert.Equal(t, 3, FixedCost(3)(newRequest("GET", 0)))
+
+	assert.Equal(t, 5, byMethod(newRequest("POST", 0)))
+	assert.Equal(t, 0, byMethod(newRequest("OPTIONS", 0)))
+	assert.Equal(t, 1, byMethod(newRequest("GET", 0)))
+
+	assert.Equal(t, 1, byLength(newRequest("POST", 0)))
+	assert.Equal(t, 1, byLength(newRequest("POST", 1<<10)))
+	assert.Equal(t, 10, byLength(newRequest("POST", 1<<10+1)))
+	assert.Equal(t, 20, byLength(newRequest("POST", 1<<20+1)))
+	// unknown length
+	assert.Equal(t, 20, byLength(newRequest("POST", -1)))
+}
+
+func Test_AcquireN(t *testing.T) {
+	var (
+		ctxt   = context.Background()
+		single = &staticAcquirer{acquired: true}
+	)
+
+	acquired, err := AcquireN(ctxt, single, "/foo", 1)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// acquirers which cannot acquire many tokens at once error
+	_, err = AcquireN(ctxt, single, "/foo", 2)
+	assert.Equal(t, ErrCostNotSupported, err)
+
+	local := newLocalAcquirer(5)
+
+	acquired, err = AcquireN(ctxt, local, "/foo", 4)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = AcquireN(ctxt, local, "/foo", 2)
+	require.Nil(t, err)
+	assert.False(t, acquired)
+}
+
+func Test_Limiter_Cost(t *testing.T) {
+	var (
+		proxiedCount int
+		proxy        = http.HandlerFunc(func(http.ResponseWriter, *http.Request) { proxiedCount++ })
+		acquirer     = newLocalAcquirer(10)
+		cost         = MethodCost(map[string]int{"POST": 5, "OPTIONS": 0}, 1)
+		limiter      = NewLimiter(proxy, acquirer, WithWaiter(newWaiter()), WithCost(cost))
+		serve        = func(method string) {
+			req := request(t, "/foo")
+			req.Method = method
+			limiter.ServeHTTP(nil, req)
+		}
+	)
+
+	serve("POST")
+	serve("GET")
+	serve("GET")
+	// free requests consume nothing
+	serve("OPTIONS")
+
+	assert.Equal(t, 4, proxiedCount)
+	assert.Equal(t, 7, acquirer.countFor("/foo"))
+}
@@ -0,0 +1,101 @@
+package rate
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrRateNotPermitted is returned by a call to NewGCRA
+// if the limit or period is <= 0
+var ErrRateNotPermitted = errors.New("limit and period must be > 0")
+
+// GCRA holds the parameters of the generic cell rate algorithm
+// Rather than counting, the algorithm tracks a single theoretical
+// arrival time (TAT) per key, which is the time at which the key
+// would be fully replenished given no further arrivals
+// Requests are spaced by the emission interval and up to burst
+// requests may arrive at once
+type GCRA struct {
+	// Emission is the interval between requests at the sustained rate
+	Emission time.Duration
+	// Tolerance is how far ahead of the current time the TAT may
+	// be while still permitting a request
+	Tolerance time.Duration
+}
+
+// NewGCRA returns the GCRA parameters which permit limit requests
+// per period with bursts of up to burst requests at once
+// A burst less than 1 is treated as 1
+func NewGCRA(limit int, period time.Duration, burst int) (GCRA, error) {
+	if limit <= 0 || period <= 0 {
+		return GCRA{}, ErrRateNotPermitted
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	emission := period / time.Duration(limit)
+
+	return GCRA{
+		Emission:  emission,
+		Tolerance: emission * time.Duration(burst-1),
+	}, nil
+}
+
+// Conform returns whether n requests arriving at now conform given
+// the current theoretical arrival time tat (the zero time if unknown)
+// It returns the theoretical arrival time which should be stored given
+// the requests conform, otherwise tat is returned unchanged
+func (g GCRA) Conform(tat, now time.Time, n int) (time.Time, bool) {
+	next, at := g.Reserve(tat, now, n)
+	if at.After(now) {
+		return tat, false
+	}
+
+	return next, true
+}
+
+// Reserve returns the theoretical arrival time which should be stored
+// once n requests arriving at now are reserved regardless of whether
+// they conform, along with the time from which they conform
+func (g GCRA) Reserve(tat, now time.Time, n int) (next, at time.Time) {
+	start := tat
+	if start.Before(now) {
+		start = now
+	}
+
+	next = start.Add(g.Emission * time.Duration(n))
+
+	at = next.Add(-(g.Tolerance + g.Emission))
+	if at.Before(now) {
+		at = now
+	}
+
+	return next, at
+}
+
+// Permits returns true if n requests could ever conform at once
+func (g GCRA) Permits(n int) bool {
+	return g.Emission*time.Duration(n) <= g.Tolerance+g.Emission
+}
+
+// Status returns the budget of a key given its current theoretical
+// arrival time tat (the zero time if unknown) at now
+// The key regains its full budget once the TAT has passed
+func (g GCRA) Status(tat, now time.Time) Status {
+	var (
+		capacity = g.Tolerance + g.Emission
+		reset    = tat.Sub(now)
+	)
+
+	if reset < 0 {
+		reset = 0
+	}
+
+	return Status{
+		Limit:     int(capacity / g.Emission),
+		Remaining: int((capacity - reset) / g.Emission),
+		Reset:     reset,
+	}
+}
@@ -0,0 +1,80 @@
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GCRA(t *testing.T) {
+	var (
+		// 60 per minute with bursts of 3
+		gcra, err = NewGCRA(60, time.Minute, 3)
+		now       = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		tat       time.Time
+		ok        bool
+	)
+
+	require.Nil(t, err)
+
+	assert.Equal(t, time.Second, gcra.Emission)
+	assert.Equal(t, 2*time.Second, gcra.Tolerance)
+
+	// a burst of 3 conforms
+	for i := 0; i < 3; i++ {
+		tat, ok = gcra.Conform(tat, now, 1)
+		assert.True(t, ok)
+	}
+
+	assert.Equal(t, now.Add(3*time.Second), tat)
+
+	// the fourth does not and the tat is unchanged
+	next, ok := gcra.Conform(tat, now, 1)
+	assert.False(t, ok)
+	assert.Equal(t, tat, next)
+
+	// one emission interval later another request conforms
+	tat, ok = gcra.Conform(tat, now.Add(time.Second), 1)
+	assert.True(t, ok)
+
+	// after a long pause the full burst is available again
+	_, ok = gcra.Conform(tat, now.Add(time.Hour), 3)
+	assert.True(t, ok)
+
+	assert.True(t, gcra.Permits(3))
+	assert.False(t, gcra.Permits(4))
+}
+
+func Test_GCRA_Reserve(t *testing.T) {
+	var (
+		// 60 per minute with bursts of 2
+		gcra, err = NewGCRA(60, time.Minute, 2)
+		now       = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+	)
+
+	require.Nil(t, err)
+
+	// the burst may be used straight away
+	tat, at := gcra.Reserve(time.Time{}, now, 2)
+	assert.Equal(t, now.Add(2*time.Second), tat)
+	assert.Equal(t, now, at)
+
+	// further requests are reserved an emission interval apart
+	tat, at = gcra.Reserve(tat, now, 1)
+	assert.Equal(t, now.Add(3*time.Second), tat)
+	assert.Equal(t, now.Add(time.Second), at)
+
+	tat, at = gcra.Reserve(tat, now, 2)
+	assert.Equal(t, now.Add(5*time.Second), tat)
+	assert.Equal(t, now.Add(3*time.Second), at)
+}
+
+func Test_GCRA_BadRate(t *testing.T) {
+	_, err := NewGCRA(0, time.Minute, 1)
+	assert.Equal(t, ErrRateNotPermitted, err)
+
+	_, err = NewGCRA(10, 0, 1)
+	assert.Equal(t, ErrRateNotPermitted, err)
+}
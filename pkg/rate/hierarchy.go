@@ -0,0 +1,47 @@
+package rate
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HierarchySeparator separates the levels of a hierarchical key
+// The ASCII unit separator is used as it is not expected to
+// appear within paths, headers or other request attributes
+const HierarchySeparator = "\x1f"
+
+// JoinHierarchy joins the provided levels into a single hierarchical key
+// e.g. a tenant followed by the endpoint of the tenant being requested
+func JoinHierarchy(levels ...string) string {
+	return strings.Join(levels, HierarchySeparator)
+}
+
+// SplitHierarchy splits a hierarchical key into its levels
+// and returns the key of every level in the chain from root to leaf
+// e.g. "a", "a<sep>b", "a<sep>b<sep>c"
+func SplitHierarchy(key string) []string {
+	var (
+		levels = strings.Split(key, HierarchySeparator)
+		chain  = make([]string, 0, len(levels))
+	)
+
+	for i := range levels {
+		chain = append(chain, JoinHierarchy(levels[:i+1]...))
+	}
+
+	return chain
+}
+
+// HierarchicalKey returns a KeyFunc which derives a hierarchical key
+// from the provided KeyFuncs, ordered from root to leaf
+// e.g. HierarchicalKey(HeaderKey("X-Tenant"), PathKey)
+func HierarchicalKey(levels ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		keys := make([]string, 0, len(levels))
+		for _, level := range levels {
+			keys = append(keys, level(r))
+		}
+
+		return JoinHierarchy(keys...)
+	}
+}
@@ -0,0 +1,23 @@
+package rate
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Hierarchy(t *testing.T) {
+	key := JoinHierarchy("acme", "/users", "GET")
+
+	assert.Equal(t, []string{
+		"acme",
+		JoinHierarchy("acme", "/users"),
+		key,
+	}, SplitHierarchy(key))
+
+	req := request(t, "/users")
+	req.Header = http.Header{"X-Tenant": []string{"acme"}}
+
+	assert.Equal(t, JoinHierarchy("acme", "/users"), HierarchicalKey(HeaderKey("X-Tenant"), PathKey)(req))
+}
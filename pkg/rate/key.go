@@ -0,0 +1,166 @@
+package rate
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// KeyFunc derives the key used to impose limits on a request
+// It is passed the incoming request and returns the key which is
+// provided to the Acquirer
+type KeyFunc func(*http.Request) string
+
+// PathKey returns the path of the request as the key
+// This is the default strategy used by the Limiter
+func PathKey(r *http.Request) string {
+	return r.URL.Path
+}
+
+// MethodPathKey returns the method and path of the request
+// as the key e.g. "GET /foo/bar"
+func MethodPathKey(r *http.Request) string {
+	return r.Method + " " + r.URL.Path
+}
+
+// HostKey returns the host the request was addressed to as the key
+func HostKey(r *http.Request) string {
+	return r.Host
+}
+
+// RemoteAddrKey returns the host portion of the address of
+// the client which made the request as the key
+func RemoteAddrKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// remote address has no port
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// HeaderKey returns a KeyFunc which uses the value of the
+// named header as the key
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// CookieKey returns a KeyFunc which uses the value of the
+// named cookie as the key
+func CookieKey(name string) KeyFunc {
+	return func(r *http.Request) string {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+
+		return cookie.Value
+	}
+}
+
+// QueryKey returns a KeyFunc which uses the value of the
+// named query parameter as the key
+func QueryKey(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	}
+}
+
+// ParseKeyTemplate parses a template which combines the output of
+// a number of the built-in KeyFunc types into a single KeyFunc
+// Placeholders are delimited by braces and any text outside of the
+// braces is included in the key verbatim
+// e.g. "{method} {path}" or "{header:X-Api-Key}/{path}"
+// Supported placeholders are {path}, {method}, {host}, {remote_addr},
+// {header:<name>}, {cookie:<name>} and {query:<name>}
+func ParseKeyTemplate(tmpl string) (KeyFunc, error) {
+	var (
+		parts []KeyFunc
+		rest  = tmpl
+	)
+
+	for rest != "" {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			parts = append(parts, literalKey(rest))
+			break
+		}
+
+		if start > 0 {
+			parts = append(parts, literalKey(rest[:start]))
+		}
+
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("key template %q: unterminated placeholder", tmpl)
+		}
+
+		fn, err := placeholderKey(rest[start+1 : start+end])
+		if err != nil {
+			return nil, fmt.Errorf("key template %q: %v", tmpl, err)
+		}
+
+		parts = append(parts, fn)
+
+		rest = rest[start+end+1:]
+	}
+
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("key template %q: template is empty", tmpl)
+	}
+
+	return func(r *http.Request) string {
+		var builder strings.Builder
+		for _, part := range parts {
+			builder.WriteString(part(r))
+		}
+
+		return builder.String()
+	}, nil
+}
+
+func literalKey(literal string) KeyFunc {
+	return func(*http.Request) string { return literal }
+}
+
+func placeholderKey(placeholder string) (KeyFunc, error) {
+	var (
+		name, arg = placeholder, ""
+		idx       = strings.Index(placeholder, ":")
+	)
+
+	if idx > -1 {
+		name, arg = placeholder[:idx], placeholder[idx+1:]
+	}
+
+	switch name {
+	case "path":
+		return PathKey, nil
+	case "method":
+		return func(r *http.Request) string { return r.Method }, nil
+	case "host":
+		return HostKey, nil
+	case "remote_addr":
+		return RemoteAddrKey, nil
+	case "header":
+		return namedKey(name, arg, HeaderKey)
+	case "cookie":
+		return namedKey(name, arg, CookieKey)
+	case "query":
+		return namedKey(name, arg, QueryKey)
+	}
+
+	return nil, fmt.Errorf("unknown placeholder %q", placeholder)
+}
+
+func namedKey(placeholder, name string, fn func(string) KeyFunc) (KeyFunc, error) {
+	if name == "" {
+		return nil, fmt.Errorf("placeholder %q requires a name e.g. {%s:name}", placeholder, placeholder)
+	}
+
+	return fn(name), nil
+}
@@ -0,0 +1,80 @@
+package rate
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_KeyFuncs(t *testing.T) {
+	req := request(t, "/foo/bar?api_key=baz")
+	req.Method = http.MethodPost
+	req.Host = "example.com"
+	req.RemoteAddr = "10.0.0.1:51234"
+	req.Header = http.Header{}
+	req.Header.Set("X-Api-Key", "qux")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "quux"})
+
+	for _, test := range []struct {
+		name     string
+		keyFunc  KeyFunc
+		expected string
+	}{
+		{"path", PathKey, "/foo/bar"},
+		{"method and path", MethodPathKey, "POST /foo/bar"},
+		{"host", HostKey, "example.com"},
+		{"remote address", RemoteAddrKey, "10.0.0.1"},
+		{"header", HeaderKey("X-Api-Key"), "qux"},
+		{"missing header", HeaderKey("X-Missing"), ""},
+		{"cookie", CookieKey("session"), "quux"},
+		{"missing cookie", CookieKey("missing"), ""},
+		{"query", QueryKey("api_key"), "baz"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.keyFunc(req))
+		})
+	}
+}
+
+func Test_ParseKeyTemplate(t *testing.T) {
+	req := request(t, "/foo/bar?page=2")
+	req.Method = http.MethodGet
+	req.RemoteAddr = "10.0.0.1"
+	req.Header = http.Header{}
+	req.Header.Set("X-Api-Key", "qux")
+
+	for _, test := range []struct {
+		template string
+		expected string
+	}{
+		{"{path}", "/foo/bar"},
+		{"{method} {path}", "GET /foo/bar"},
+		{"client:{remote_addr}", "client:10.0.0.1"},
+		{"{header:X-Api-Key}{path}?page={query:page}", "qux/foo/bar?page=2"},
+		{"static", "static"},
+	} {
+		t.Run(test.template, func(t *testing.T) {
+			keyFunc, err := ParseKeyTemplate(test.template)
+			require.Nil(t, err)
+
+			assert.Equal(t, test.expected, keyFunc(req))
+		})
+	}
+}
+
+func Test_ParseKeyTemplate_Invalid(t *testing.T) {
+	for _, template := range []string{
+		"",
+		"{path",
+		"{unknown}",
+		"{header}",
+		"{cookie:}",
+	} {
+		t.Run(template, func(t *testing.T) {
+			_, err := ParseKeyTemplate(template)
+			assert.Error(t, err)
+		})
+	}
+}
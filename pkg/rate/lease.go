@@ -0,0 +1,103 @@
+package rate
+
+import (
+	"context"
+	"sync"
+)
+
+// Lease is a claim on tokens which are held until released
+// e.g. a token which limits the number of requests inflight
+type Lease interface {
+	Release(ctxt context.Context) error
+}
+
+// LeaseFunc is a function which implements the Lease interface
+type LeaseFunc func(context.Context) error
+
+// Release delegates to the wrapped LeaseFunc
+func (l LeaseFunc) Release(ctxt context.Context) error {
+	return l(ctxt)
+}
+
+// Leaser is an Acquirer whose tokens are returned once the
+// work they permit has finished rather than on a refill
+// It should return true only if all n tokens were acquired in
+// which case they are held until the returned Lease is released
+type Leaser interface {
+	Lease(ctxt context.Context, key string, n int) (Lease, bool, error)
+}
+
+// LeaseN leases n tokens for key from the provided Acquirer
+// It delegates to Lease when the acquirer implements Leaser and
+// otherwise acquires the tokens via ClaimN, in which case
+// releasing the returned Lease does nothing as the tokens are
+// returned on refill
+func LeaseN(ctxt context.Context, acquirer Acquirer, key string, n int) (Lease, bool, error) {
+	if leaser, ok := acquirer.(Leaser); ok {
+		return leaser.Lease(ctxt, key, n)
+	}
+
+	refund, acquired, err := ClaimN(ctxt, acquirer, key, n)
+	return acquiredLease{refund}, acquired, err
+}
+
+// ReleaseOnce returns a Lease which only calls release the first
+// time it is released, subsequent calls return the same result
+func ReleaseOnce(release LeaseFunc) Lease {
+	var (
+		once sync.Once
+		err  error
+	)
+
+	return LeaseFunc(func(ctxt context.Context) error {
+		once.Do(func() {
+			err = release(ctxt)
+		})
+
+		return err
+	})
+}
+
+// Leases is a Lease which releases every contained Lease in reverse order
+// The first error encountered is returned once all have been released
+type Leases []Lease
+
+// Release releases every lease in reverse order
+func (l Leases) Release(ctxt context.Context) (err error) {
+	for i := len(l) - 1; i >= 0; i-- {
+		if rerr := l[i].Release(ctxt); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+
+	return
+}
+
+// revoke returns the tokens held by every lease in reverse order
+// Unlike Release, tokens which were acquired from acquirers which do
+// not implement Leaser are refunded to the interval they were acquired
+// from given the acquirer is a Claimer, or otherwise a Refunder
+func (l Leases) revoke(ctxt context.Context) (err error) {
+	for i := len(l) - 1; i >= 0; i-- {
+		rerr := l[i].Release(ctxt)
+		if acquired, ok := l[i].(acquiredLease); ok {
+			rerr = acquired.refund(ctxt)
+		}
+
+		if rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+
+	return
+}
+
+// acquiredLease is returned by LeaseN for tokens acquired from
+// an Acquirer which does not implement Leaser
+// The tokens are returned by refund when the lease is revoked
+type acquiredLease struct {
+	refund RefundFunc
+}
+
+// Release does nothing as the tokens are returned on refill
+func (acquiredLease) Release(context.Context) error { return nil }
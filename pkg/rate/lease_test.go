@@ -0,0 +1,91 @@
+package rate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Limiter_Lease(t *testing.T) {
+	var (
+		acquirer = newLeasingAcquirer(1)
+		inflight int
+		proxy    = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			inflight = acquirer.countFor("/foo")
+			w.WriteHeader(http.StatusOK)
+		})
+		limiter = NewLimiter(proxy, acquirer, WithWaiter(newWaiter()), WithReject())
+	)
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		limiter.ServeHTTP(rec, request(t, "/foo"))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		// the token is held while proxying and released once finished
+		assert.Equal(t, 1, inflight)
+		assert.Equal(t, 0, acquirer.countFor("/foo"))
+	}
+}
+
+func Test_Limiter_Lease_Panic(t *testing.T) {
+	var (
+		acquirer = newLeasingAcquirer(1)
+		proxy    = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			panic(http.ErrAbortHandler)
+		})
+		limiter = NewLimiter(proxy, acquirer, WithWaiter(newWaiter()))
+	)
+
+	assert.Panics(t, func() {
+		limiter.ServeHTTP(httptest.NewRecorder(), request(t, "/foo"))
+	})
+
+	// the token is released regardless
+	assert.Equal(t, 0, acquirer.countFor("/foo"))
+}
+
+func Test_All_Lease(t *testing.T) {
+	var (
+		interval = newLocalAcquirer(3)
+		inflight = newLeasingAcquirer(1)
+		ctxt     = context.Background()
+		all      = All(interval, inflight)
+	)
+
+	lease, acquired, err := all.Lease(ctxt, "/foo", 1)
+	require.Nil(t, err)
+	require.True(t, acquired)
+
+	// inflight acquirer is exhausted so the combination is denied
+	_, acquired, err = all.Lease(ctxt, "/foo", 1)
+	require.Nil(t, err)
+	assert.False(t, acquired)
+
+	// the token granted by the interval acquirer has been refunded
+	assert.Equal(t, 1, interval.countFor("/foo"))
+
+	// releasing only returns leased tokens
+	require.Nil(t, lease.Release(ctxt))
+	assert.Equal(t, 1, interval.countFor("/foo"))
+	assert.Equal(t, 0, inflight.countFor("/foo"))
+}
+
+func Test_LeaseN(t *testing.T) {
+	var (
+		acquirer = newLocalAcquirer(1)
+		ctxt     = context.Background()
+	)
+
+	// acquirers which do not lease hold tokens until refill
+	lease, acquired, err := LeaseN(ctxt, acquirer, "/foo", 1)
+	require.Nil(t, err)
+	require.True(t, acquired)
+
+	require.Nil(t, lease.Release(ctxt))
+	assert.Equal(t, 1, acquirer.countFor("/foo"))
+}
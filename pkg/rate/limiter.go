@@ -3,9 +3,15 @@ package rate
 import (
 	"context"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// releaseTimeout bounds how long releasing a Lease may take once
+// a request has finished
+const releaseTimeout = 5 * time.Second
+
 // Acquirer is a type which can authorize a key to be actionable
 // It should return true if a caller is allowed to proceed
 // and action a request given the provided key at the point in time
@@ -22,20 +28,48 @@ type Waiter interface {
 }
 
 // Limiter is a http.Handler which limits incoming requests using
-// based on the response of a Acquirer per request path
+// based on the response of a Acquirer per request key
+// By default the key is the request path
 type Limiter struct {
 	proxy    http.Handler
 	acquirer Acquirer
 	waiter   Waiter
+	keyFunc  KeyFunc
+
+	// inflight limits the requests proxied per key at once
+	// and inflightWaiter waits for its tokens to be released
+	inflight       Acquirer
+	inflightWaiter Waiter
+
+	normalizer Normalizer
+	reject     bool
+	costFunc   CostFunc
+
+	maxWait       time.Duration
+	maxWaitHeader string
+	queue         *queue
+	retryAfter    RetryAfterFunc
+
+	priorityFunc PriorityFunc
+	priorities   *priorityQueue
+
+	hideStatus      bool
+	keyHeader       string
+	remainingHeader string
 }
 
 // NewLimiter constructs a newly configured requirer with a default
 // waiting strategy set to 1 minute intervals
+// By default requests wait indefinitely and rejected requests are
+// told to retry at the next minute
 func NewLimiter(proxy http.Handler, acquirer Acquirer, opts ...Option) Limiter {
 	l := Limiter{
 		proxy:    proxy,
 		acquirer: acquirer,
 		waiter:   NextIntervalWaiter(time.Minute),
+		keyFunc:  PathKey,
+
+		retryAfter: NextInterval(time.Minute),
 	}
 
 	Options(opts).Apply(&l)
@@ -44,33 +78,381 @@ func NewLimiter(proxy http.Handler, acquirer Acquirer, opts ...Option) Limiter {
 }
 
 // ServeHTTP handles the provided request by imposing any active
-// limits on the request path and then delegating the request to
+// limits on the request key and then delegating the request to
 // the underlying proxy
 func (l Limiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	for {
-		// check if request is ready to be served
-		acquired, err := l.acquirer.Acquire(r.Context(), r.URL.Path)
+	var (
+		keyed = l.keyedRequest(r)
+		key   = l.keyFunc(keyed)
+		// make the request available to acquirers which derive their own keys
+		ctxt = ContextWithRequest(r.Context(), keyed)
+		cost = l.cost(r)
+		// the status is reported using the request context as the
+		// wait context may have expired by the time it is reported
+		rctxt = ctxt
+	)
+
+	// bound the time spent waiting given a maximum is configured
+	ctxt, cancel := l.waitContext(ctxt, r)
+	defer cancel()
+
+	lease, err := l.acquire(ctxt, key, cost, l.priority(r))
+	switch {
+	case err == nil:
+	case r.Context().Err() != nil:
+		// the client has gone away e.g. closed connection
+		return
+	case err == errTooManyRequests, ctxt.Err() == context.DeadlineExceeded:
+		l.report(rctxt, w, key)
+		l.tooManyRequests(w, key)
+		return
+	default:
+		http.Error(w, "service currently unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if lease != nil {
+		// return leased tokens once the request has been
+		// proxied, even if the handler panics or the
+		// client goes away
+		defer release(lease)
+	}
+
+	status, reported := l.report(rctxt, w, key)
+	l.forward(r, key, status, reported)
+
+	// delegate to proxy handler
+	l.proxy.ServeHTTP(w, r)
+}
+
+// report sets the RateLimit headers of the response to the status
+// of key given the acquirer is a Reporter and the headers are not hidden
+// It returns the status and whether it was reported by the acquirer
+func (l Limiter) report(ctxt context.Context, w http.ResponseWriter, key string) (Status, bool) {
+	if l.hideStatus && l.remainingHeader == "" {
+		return Status{}, false
+	}
+
+	status, err := StatusOf(ctxt, l.acquirer, key)
+	if err != nil {
+		// the headers are advisory so the request is
+		// served regardless
+		return Status{}, false
+	}
+
+	if !l.hideStatus {
+		status.SetHeaders(w.Header())
+	}
+
+	return status, true
+}
+
+// forward sets the key and remaining budget of the request on the
+// request headers configured to be forwarded to the upstream
+func (l Limiter) forward(r *http.Request, key string, status Status, reported bool) {
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+
+	if l.keyHeader != "" {
+		r.Header.Set(l.keyHeader, headerValue(key))
+	}
+
+	if l.remainingHeader != "" && reported {
+		r.Header.Set(l.remainingHeader, strconv.Itoa(status.Remaining))
+	}
+}
+
+// headerValue returns key as a valid header value
+// The levels of hierarchical keys are separated by a colon and
+// any other control characters are dropped
+func headerValue(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case string(r) == HierarchySeparator:
+			return ':'
+		case r < ' ' || r == 0x7f:
+			return -1
+		}
+
+		return r
+	}, key)
+}
+
+// acquire obtains cost tokens for key, waiting until they are
+// available unless the limiter rejects requests immediately
+// Given an inflight limit is configured its tokens are leased first,
+// so that a request it denies consumes none of the tokens of the key
+// Waiting requests take turns to acquire in order of priority
+// given priorities are configured
+// It returns errTooManyRequests when the request is rejected
+func (l Limiter) acquire(ctxt context.Context, key string, cost, priority int) (Lease, error) {
+	// requests which cost nothing are not limited
+	if cost <= 0 {
+		return nil, nil
+	}
+
+	if l.reject {
+		return l.leaseNow(ctxt, key, cost)
+	}
+
+	if !l.queue.enter(key) {
+		return nil, errTooManyRequests
+	}
+
+	defer l.queue.leave(key)
+
+	if l.priorities != nil {
+		if err := l.priorities.wait(ctxt, key, priority); err != nil {
+			return nil, err
+		}
+
+		defer l.priorities.done(key)
+	}
+
+	inflight, err := l.leaseInflight(ctxt, key, cost)
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := l.acquireTokens(ctxt, key, cost)
+	if err != nil {
+		if inflight != nil {
+			release(inflight)
+		}
+
+		return nil, err
+	}
+
+	return joinLeases(inflight, lease), nil
+}
+
+// leaseNow leases cost tokens for key from the inflight limit, given
+// one is configured, and the acquirer without waiting for either
+// It returns errTooManyRequests when either denies the request
+func (l Limiter) leaseNow(ctxt context.Context, key string, cost int) (Lease, error) {
+	var inflight Lease
+	if l.inflight != nil {
+		lease, acquired, err := LeaseN(ctxt, l.inflight, key, cost)
+		if err == nil && !acquired {
+			err = errTooManyRequests
+		}
+
 		if err != nil {
-			http.Error(w, "service currently unavailable", http.StatusServiceUnavailable)
-			return
+			return nil, err
 		}
 
-		if acquired {
-			break
+		inflight = lease
+	}
+
+	lease, acquired, err := LeaseN(ctxt, l.acquirer, key, cost)
+	if err == nil && !acquired {
+		err = errTooManyRequests
+	}
+
+	if err != nil {
+		if inflight != nil {
+			release(inflight)
 		}
 
-		// given the context has not been cancelled
-		// e.g. client closed connection
-		select {
-		case <-r.Context().Done():
-			return
-		default:
+		return nil, err
+	}
+
+	return joinLeases(inflight, lease), nil
+}
+
+// leaseInflight leases cost tokens for key from the inflight limit
+// given one is configured, waiting using the inflight waiter while
+// the limit is reached as a token is regained as soon as any inflight
+// request finishes
+func (l Limiter) leaseInflight(ctxt context.Context, key string, cost int) (Lease, error) {
+	if l.inflight == nil {
+		return nil, nil
+	}
+
+	for {
+		lease, acquired, err := LeaseN(ctxt, l.inflight, key, cost)
+		if err != nil || acquired {
+			return lease, err
+		}
+
+		l.inflightWaiter.Wait(ctxt)
+
+		if err := ctxt.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// acquireTokens obtains cost tokens for key from the acquirer, waiting
+// for them to be handed over or reserved given the acquirer supports
+// either, and otherwise polling using the configured waiter
+func (l Limiter) acquireTokens(ctxt context.Context, key string, cost int) (Lease, error) {
+	waited, err := l.wait(ctxt, key, cost)
+	if waited || err != nil {
+		return nil, err
+	}
+
+	reserved, err := l.reserve(ctxt, key, cost)
+	if reserved || err != nil {
+		return nil, err
+	}
+
+	for {
+		// check if request is ready to be served
+		lease, acquired, err := LeaseN(ctxt, l.acquirer, key, cost)
+		if err != nil || acquired {
+			return lease, err
 		}
 
 		// wait using the configured wait until ready
-		l.waiter.Wait(r.Context())
+		l.waiter.Wait(ctxt)
+
+		// given the context has not been cancelled
+		// or the maximum wait exceeded
+		if err := ctxt.Err(); err != nil {
+			return nil, err
+		}
 	}
+}
 
-	// delegate to proxy handler
-	l.proxy.ServeHTTP(w, r)
+// priority returns the priority class of the request
+// Requests are all of the same class unless a PriorityFunc is configured
+func (l Limiter) priority(r *http.Request) int {
+	if l.priorityFunc == nil {
+		return 0
+	}
+
+	return l.priorityFunc(r)
+}
+
+// waitContext returns a context which expires once the maximum time
+// a request may wait has passed, given a maximum is configured either
+// on the limiter or by the client, in which case the shorter is used
+func (l Limiter) waitContext(ctxt context.Context, r *http.Request) (context.Context, context.CancelFunc) {
+	maxWait := l.maxWait
+	if l.maxWaitHeader != "" {
+		if requested, ok := parseWait(r.Header.Get(l.maxWaitHeader)); ok && (maxWait <= 0 || requested < maxWait) {
+			maxWait = requested
+		}
+	}
+
+	if maxWait <= 0 {
+		return context.WithCancel(ctxt)
+	}
+
+	return context.WithTimeout(ctxt, maxWait)
+}
+
+// tooManyRequests responds 429 Too Many Requests with a Retry-After
+// header of the number of seconds until the key is next refilled
+func (l Limiter) tooManyRequests(w http.ResponseWriter, key string) {
+	if l.retryAfter != nil {
+		w.Header().Set("Retry-After", retryAfterSeconds(l.retryAfter(key)))
+	}
+
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+}
+
+// wait blocks until cost tokens for key are handed over given the
+// acquirer supports waiting and requests are not being rejected
+// It returns false when the tokens must be polled for instead
+func (l Limiter) wait(ctxt context.Context, key string, cost int) (bool, error) {
+	if cost <= 0 || l.reject {
+		return false, nil
+	}
+
+	err := WaitN(ctxt, l.acquirer, key, cost)
+	if err == ErrWaitNotSupported {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// reserve reserves cost tokens for key and sleeps until they may be
+// used given the acquirer supports reservations
+// The reservation is cancelled if it cannot be used before the request
+// stops waiting, in which case the request is rejected straight away
+// when it would never have been served in time
+// It returns false when the tokens must be polled for instead
+func (l Limiter) reserve(ctxt context.Context, key string, cost int) (bool, error) {
+	reservation, err := ReserveN(ctxt, l.acquirer, key, cost)
+	switch {
+	case err == ErrReserveNotSupported:
+		return false, nil
+	case err != nil:
+		return false, err
+	case !reservation.OK():
+		return false, nil
+	}
+
+	if deadline, ok := ctxt.Deadline(); ok && reservation.Time().After(deadline) {
+		release(LeaseFunc(reservation.Cancel))
+		return false, errTooManyRequests
+	}
+
+	timer := time.NewTimer(reservation.Delay())
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctxt.Done():
+		release(LeaseFunc(reservation.Cancel))
+		return false, ctxt.Err()
+	}
+}
+
+// joinLeases returns a Lease which releases every non-nil lease
+// provided, or nil given there are none
+func joinLeases(leases ...Lease) Lease {
+	var joined Leases
+	for _, lease := range leases {
+		if lease != nil {
+			joined = append(joined, lease)
+		}
+	}
+
+	if len(joined) == 0 {
+		return nil
+	}
+
+	return joined
+}
+
+// release releases the provided lease using a fresh context
+// as the context of the request may already be cancelled
+func release(lease Lease) {
+	ctxt, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	lease.Release(ctxt)
+}
+
+// cost returns the number of tokens the request costs
+// Requests cost a single token unless a CostFunc is configured
+func (l Limiter) cost(r *http.Request) int {
+	if l.costFunc == nil {
+		return 1
+	}
+
+	return l.costFunc(r)
+}
+
+// keyedRequest returns the request from which keys are derived
+// If a normalizer is configured this is a copy of the request
+// where the path has been replaced by its route template
+func (l Limiter) keyedRequest(r *http.Request) *http.Request {
+	if l.normalizer == nil {
+		return r
+	}
+
+	u := *r.URL
+	u.Path, u.RawPath = l.normalizer.Normalize(u.Path), ""
+
+	normalized := r.WithContext(r.Context())
+	normalized.URL = &u
+
+	return normalized
 }
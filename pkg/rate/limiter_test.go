@@ -3,6 +3,7 @@ package rate
 import (
 	"context"
 	"net/http"
+	"net/http/httptest"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -125,3 +126,128 @@ func Test_Limiter_Concurrent(t *testing.T) {
 
 	wg.Wait()
 }
+
+func Test_Limiter_KeyFunc(t *testing.T) {
+	var (
+		proxiedCount int64
+		proxy        = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			atomic.AddInt64(&proxiedCount, 1)
+		})
+		acquirer = newLocalAcquirer(1)
+		limiter  = NewLimiter(proxy, acquirer, WithWaiter(newWaiter()), WithKeyFunc(HeaderKey("X-Api-Key")))
+	)
+
+	for _, apiKey := range []string{"foo", "bar"} {
+		req := request(t, "/foo/bar")
+		req.Header = http.Header{}
+		req.Header.Set("X-Api-Key", apiKey)
+
+		limiter.ServeHTTP(nil, req)
+	}
+
+	// both requests share a path but are limited by their api key
+	assert.Equal(t, int64(2), atomic.LoadInt64(&proxiedCount))
+	assert.Equal(t, map[string]int{"foo": 1, "bar": 1}, acquirer.counts)
+}
+
+func Test_Limiter_Reject(t *testing.T) {
+	var (
+		proxy    = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+		acquirer = newLocalAcquirer(1)
+		limiter  = NewLimiter(proxy, acquirer, WithWaiter(newWaiter()), WithReject())
+	)
+
+	rec := httptest.NewRecorder()
+	limiter.ServeHTTP(rec, request(t, "/foo"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// second request is rejected rather than waiting
+	rec = httptest.NewRecorder()
+	limiter.ServeHTTP(rec, request(t, "/foo"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func Test_Limiter_Inflight(t *testing.T) {
+	var (
+		entered  = make(chan struct{}, 2)
+		finish   = make(chan struct{})
+		proxy    = http.HandlerFunc(func(http.ResponseWriter, *http.Request) { entered <- struct{}{}; <-finish })
+		acquirer = newLocalAcquirer(3)
+		inflight = newLeasingAcquirer(1)
+		// the configured waiter is never woken so a request
+		// waiting on it would not be proxied
+		limiter = NewLimiter(proxy, acquirer, WithWaiter(newWaiter()), WithInflight(inflight, DelayWaiter(time.Millisecond)))
+		wg      sync.WaitGroup
+		serve   = func() {
+			defer wg.Done()
+
+			rec := httptest.NewRecorder()
+			limiter.ServeHTTP(rec, request(t, "/foo"))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}
+	)
+
+	wg.Add(2)
+	go serve()
+	<-entered
+
+	go serve()
+
+	select {
+	case <-entered:
+		t.Fatal("expected request to wait for the inflight limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the waiting request has consumed none of the tokens of the key
+	assert.Equal(t, 1, acquirer.countFor("/foo"))
+	assert.Equal(t, 1, inflight.countFor("/foo"))
+
+	finish <- struct{}{}
+
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Fatal("expected waiting request to be proxied")
+	}
+
+	close(finish)
+	wg.Wait()
+
+	assert.Equal(t, 2, acquirer.countFor("/foo"))
+	assert.Equal(t, 0, inflight.countFor("/foo"))
+}
+
+func Test_Limiter_Inflight_Reject(t *testing.T) {
+	var (
+		entered  = make(chan struct{})
+		finish   = make(chan struct{})
+		proxy    = http.HandlerFunc(func(http.ResponseWriter, *http.Request) { close(entered); <-finish })
+		acquirer = newLocalAcquirer(3)
+		inflight = newLeasingAcquirer(1)
+		limiter  = NewLimiter(proxy, acquirer, WithReject(), WithInflight(inflight, newWaiter()))
+		done     = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+
+		rec := httptest.NewRecorder()
+		limiter.ServeHTTP(rec, request(t, "/foo"))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}()
+
+	<-entered
+
+	// second request is rejected by the inflight limit
+	// without consuming a token of the key
+	rec := httptest.NewRecorder()
+	limiter.ServeHTTP(rec, request(t, "/foo"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, 1, acquirer.countFor("/foo"))
+
+	close(finish)
+	<-done
+
+	assert.Equal(t, 0, inflight.countFor("/foo"))
+}
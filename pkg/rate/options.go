@@ -1,5 +1,7 @@
 package rate
 
+import "time"
+
 // Option is a functional option for the limiter type
 type Option func(*Limiter)
 
@@ -21,3 +23,123 @@ func WithWaiter(waiter Waiter) Option {
 		l.waiter = waiter
 	}
 }
+
+// WithInflight limits the number of requests proxied per key at once
+// using the provided Acquirer, whose tokens are leased (see Leaser) and
+// released once each request has been proxied
+// Inflight tokens are leased before those of the limiter's Acquirer
+// so a request denied by the inflight limit consumes nothing
+// Requests denied by the inflight limit wait using the provided Waiter
+// rather than the configured one, as a token is regained as soon as
+// any inflight request finishes rather than at the next refill
+func WithInflight(acquirer Acquirer, waiter Waiter) Option {
+	return func(l *Limiter) {
+		l.inflight = acquirer
+		l.inflightWaiter = waiter
+	}
+}
+
+// WithKeyFunc sets the function used to derive the key
+// passed to the Acquirer for each request
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(l *Limiter) {
+		l.keyFunc = fn
+	}
+}
+
+// WithNormalizer configures the limiter to derive keys from
+// requests where the path has been collapsed into a route template
+// e.g. requests for /users/123 and /users/124 share the key /users/{id}
+func WithNormalizer(normalizer Normalizer) Option {
+	return func(l *Limiter) {
+		l.normalizer = normalizer
+	}
+}
+
+// WithReject configures the limiter to respond with 429 Too Many Requests
+// when a request cannot be served immediately, rather than waiting
+func WithReject() Option {
+	return func(l *Limiter) {
+		l.reject = true
+	}
+}
+
+// WithCost sets the function used to determine how many tokens
+// each request costs, e.g. so that expensive endpoints consume
+// more of the budget than cheap ones
+func WithCost(fn CostFunc) Option {
+	return func(l *Limiter) {
+		l.costFunc = fn
+	}
+}
+
+// WithMaxWait bounds how long a request waits for tokens
+// Requests which are not served in time are responded to with
+// 429 Too Many Requests rather than waiting indefinitely
+func WithMaxWait(d time.Duration) Option {
+	return func(l *Limiter) {
+		l.maxWait = d
+	}
+}
+
+// WithMaxWaitHeader allows clients to request a shorter maximum wait
+// than the limiter is configured with using the named request header
+// The value is either a duration (e.g. 500ms) or a number of seconds
+func WithMaxWaitHeader(name string) Option {
+	return func(l *Limiter) {
+		l.maxWaitHeader = name
+	}
+}
+
+// WithMaxQueue bounds the number of requests waiting per key
+// Requests beyond the bound are responded to with 429 Too Many
+// Requests rather than waiting
+func WithMaxQueue(n int) Option {
+	return func(l *Limiter) {
+		l.queue = nil
+		if n > 0 {
+			l.queue = newQueue(n)
+		}
+	}
+}
+
+// WithRetryAfter sets the function used to compute the Retry-After
+// header of requests which are rejected
+func WithRetryAfter(fn RetryAfterFunc) Option {
+	return func(l *Limiter) {
+		l.retryAfter = fn
+	}
+}
+
+// WithPriority classifies requests using the provided PriorityFunc
+// Requests waiting for the same key take turns to acquire tokens and
+// higher classes are given their turn first
+// A waiting request is promoted by one class every aging interval so
+// that lower classes are not starved (aging is disabled when <= 0)
+func WithPriority(fn PriorityFunc, aging time.Duration) Option {
+	return func(l *Limiter) {
+		l.priorityFunc = fn
+		l.priorities = newPriorityQueue(aging)
+	}
+}
+
+// WithoutStatusHeaders stops the limiter from setting the RateLimit-*
+// and X-RateLimit-* headers on responses, which are otherwise set
+// whenever the Acquirer is a Reporter
+func WithoutStatusHeaders() Option {
+	return func(l *Limiter) {
+		l.hideStatus = true
+	}
+}
+
+// WithUpstreamHeaders forwards the key of each request and its
+// remaining budget to the upstream using the named request headers
+// e.g. so that the upstream can adapt to clients nearing their limit
+// Either header is not forwarded if its name is left blank and the
+// remaining budget is only forwarded if the Acquirer is a Reporter
+func WithUpstreamHeaders(keyHeader, remainingHeader string) Option {
+	return func(l *Limiter) {
+		l.keyHeader = keyHeader
+		l.remainingHeader = remainingHeader
+	}
+}
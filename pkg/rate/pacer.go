@@ -0,0 +1,76 @@
+package rate
+
+import (
+	"context"
+	"time"
+)
+
+// Scheduler schedules requests for a key so that they are
+// released at a steady rate rather than all at once
+// It returns the time at which n requests for key may proceed
+// and false if no more requests can be scheduled for the key
+type Scheduler interface {
+	Schedule(ctxt context.Context, key string, n int) (time.Time, bool, error)
+}
+
+// SchedulerFunc is a function which implements the Scheduler interface
+type SchedulerFunc func(context.Context, string, int) (time.Time, bool, error)
+
+// Schedule delegates to the wrapped SchedulerFunc
+func (fn SchedulerFunc) Schedule(ctxt context.Context, key string, n int) (time.Time, bool, error) {
+	return fn(ctxt, key, n)
+}
+
+// Pacer is an Acquirer which paces requests using a Scheduler
+// Rather than returning immediately, an acquisition blocks until the
+// slot scheduled for the request, so that waiting requests are released
+// evenly instead of polling for tokens
+// It only returns false when the scheduler cannot schedule the request
+type Pacer struct {
+	scheduler Scheduler
+}
+
+// Pace returns a Pacer which paces requests using the provided Scheduler
+func Pace(scheduler Scheduler) Pacer {
+	return Pacer{scheduler}
+}
+
+// Acquire blocks until the slot scheduled for a request for key
+func (p Pacer) Acquire(ctxt context.Context, key string) (bool, error) {
+	return p.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN blocks until the slot scheduled for n requests for key
+// If the context is cancelled while waiting its error is returned
+// and the slot is handed back, given the Scheduler is a Refunder
+func (p Pacer) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	when, scheduled, err := p.scheduler.Schedule(ctxt, key, n)
+	if err != nil || !scheduled {
+		return false, err
+	}
+
+	timer := time.NewTimer(time.Until(when))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctxt.Done():
+		// the context is done so the slot is refunded without it
+		// otherwise every later request would wait an extra slot
+		if err := p.Refund(context.Background(), key, n); err != nil {
+			return false, err
+		}
+
+		return false, ctxt.Err()
+	}
+}
+
+// Refund delegates to the Scheduler given it is a Refunder
+func (p Pacer) Refund(ctxt context.Context, key string, n int) error {
+	if refunder, ok := p.scheduler.(Refunder); ok {
+		return refunder.Refund(ctxt, key, n)
+	}
+
+	return nil
+}
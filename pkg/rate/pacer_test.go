@@ -0,0 +1,122 @@
+package rate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// spacedScheduler schedules requests interval apart up to capacity queued
+type spacedScheduler struct {
+	mu       sync.Mutex
+	next     time.Time
+	interval time.Duration
+	capacity int
+}
+
+func (s *spacedScheduler) Schedule(_ context.Context, _ string, n int) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now, start := time.Now(), s.next
+	if start.Before(now) {
+		start = now
+	}
+
+	if start.Sub(now) >= s.interval*time.Duration(s.capacity) {
+		return time.Time{}, false, nil
+	}
+
+	s.next = start.Add(s.interval * time.Duration(n))
+
+	return start, true, nil
+}
+
+func (s *spacedScheduler) Refund(_ context.Context, _ string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next = s.next.Add(-s.interval * time.Duration(n))
+
+	return nil
+}
+
+func Test_Pacer(t *testing.T) {
+	var (
+		pacer = Pace(&spacedScheduler{interval: 20 * time.Millisecond, capacity: 3})
+		ctxt  = context.Background()
+		start = time.Now()
+	)
+
+	for i := 0; i < 3; i++ {
+		acquired, err := pacer.Acquire(ctxt, "/foo")
+		require.Nil(t, err)
+		assert.True(t, acquired)
+	}
+
+	// requests are released an interval apart
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+
+	// a cancelled context stops waiting for the slot
+	cctxt, cancel := context.WithCancel(ctxt)
+	cancel()
+
+	var (
+		next      = time.Now().Add(time.Hour)
+		scheduler = &spacedScheduler{next: next, interval: time.Hour, capacity: 2}
+	)
+
+	pacer = Pace(scheduler)
+
+	acquired, err := pacer.Acquire(cctxt, "/foo")
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, acquired)
+
+	// and hands the slot back so later requests are not delayed
+	assert.Equal(t, next, scheduler.next)
+
+	acquired, err = pacer.Acquire(cctxt, "/foo")
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, acquired)
+	assert.Equal(t, next, scheduler.next)
+}
+
+func Test_Limiter_Pacer(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+		proxy = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			times = append(times, time.Now())
+		})
+		pacer   = Pace(&spacedScheduler{interval: 20 * time.Millisecond, capacity: 5})
+		limiter = NewLimiter(proxy, pacer, WithWaiter(newWaiter()))
+		wg      sync.WaitGroup
+	)
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rec := httptest.NewRecorder()
+			limiter.ServeHTTP(rec, request(t, "/foo"))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}()
+	}
+
+	wg.Wait()
+
+	require.Len(t, times, 5)
+
+	// the requests arrive at once but are proxied evenly spaced
+	assert.True(t, times[4].Sub(times[0]) >= 80*time.Millisecond)
+}
@@ -0,0 +1,160 @@
+package rate
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PriorityFunc returns the priority class of a request
+// Requests in higher classes are served before those in lower
+// classes when they are waiting for the same key
+type PriorityFunc func(*http.Request) int
+
+// HeaderPriority returns a PriorityFunc which classifies requests
+// by the value of the named header e.g. a client tier
+// Values which are not present in classes are given the fallback class
+func HeaderPriority(name string, classes map[string]int, fallback int) PriorityFunc {
+	return func(r *http.Request) int {
+		if class, ok := classes[r.Header.Get(name)]; ok {
+			return class
+		}
+
+		return fallback
+	}
+}
+
+// FixedPriority returns a PriorityFunc which always returns class
+func FixedPriority(class int) PriorityFunc {
+	return func(*http.Request) int { return class }
+}
+
+// priorityQueue orders the requests waiting per key by priority
+// Only the request at the head of the queue for a key attempts to
+// acquire tokens at any one time, once it has finished the turn is
+// passed to the waiting request with the highest priority
+// To protect lower classes from starvation a request is promoted by
+// one class for every aging interval it has waited
+type priorityQueue struct {
+	mu    sync.Mutex
+	keys  map[string]*turns
+	aging time.Duration
+}
+
+// turns holds the requests waiting for a key and whether
+// a request currently holds the turn
+type turns struct {
+	active  bool
+	waiting []*turn
+}
+
+// turn is a request waiting for its turn to acquire tokens
+// ready is closed once the turn has been passed to it
+type turn struct {
+	priority int
+	arrived  time.Time
+	ready    chan struct{}
+}
+
+func newPriorityQueue(aging time.Duration) *priorityQueue {
+	return &priorityQueue{keys: map[string]*turns{}, aging: aging}
+}
+
+// wait blocks until it is the turn of a request with the provided
+// priority to acquire tokens for key or the context is cancelled
+// On success done must be called once the request has finished
+func (q *priorityQueue) wait(ctxt context.Context, key string, priority int) error {
+	q.mu.Lock()
+
+	ts, ok := q.keys[key]
+	if !ok {
+		ts = &turns{}
+		q.keys[key] = ts
+	}
+
+	if !ts.active {
+		ts.active = true
+		q.mu.Unlock()
+
+		return nil
+	}
+
+	t := &turn{priority: priority, arrived: time.Now(), ready: make(chan struct{})}
+	ts.waiting = append(ts.waiting, t)
+
+	q.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		return nil
+	case <-ctxt.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		// passed the turn while giving up so pass it on
+		q.next(key, ts)
+	default:
+		ts.remove(t)
+	}
+
+	return ctxt.Err()
+}
+
+// done passes the turn for key to the next waiting request
+func (q *priorityQueue) done(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if ts, ok := q.keys[key]; ok {
+		q.next(key, ts)
+	}
+}
+
+// next passes the turn to the waiting request with the highest
+// effective priority, which is the earliest to arrive among equals
+// It must be called while holding the lock
+func (q *priorityQueue) next(key string, ts *turns) {
+	if len(ts.waiting) == 0 {
+		// nobody is waiting so the key can be forgotten
+		delete(q.keys, key)
+		return
+	}
+
+	var (
+		now  = time.Now()
+		best = ts.waiting[0]
+	)
+
+	for _, t := range ts.waiting[1:] {
+		if p, bp := q.effective(t, now), q.effective(best, now); p > bp || (p == bp && t.arrived.Before(best.arrived)) {
+			best = t
+		}
+	}
+
+	ts.remove(best)
+	close(best.ready)
+}
+
+// effective returns the priority of t promoted by one
+// class for every aging interval it has waited
+func (q *priorityQueue) effective(t *turn, now time.Time) int {
+	if q.aging <= 0 {
+		return t.priority
+	}
+
+	return t.priority + int(now.Sub(t.arrived)/q.aging)
+}
+
+func (ts *turns) remove(t *turn) {
+	for i, w := range ts.waiting {
+		if w == t {
+			ts.waiting = append(ts.waiting[:i], ts.waiting[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package rate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_priorityQueue(t *testing.T) {
+	var (
+		queue = newPriorityQueue(0)
+		ctxt  = context.Background()
+		order = make(chan int, 3)
+	)
+
+	// the first request takes the turn immediately
+	require.Nil(t, queue.wait(ctxt, "/foo", 0))
+
+	for i, priority := range []int{0, 2, 1} {
+		go func(priority int) {
+			require.Nil(t, queue.wait(ctxt, "/foo", priority))
+			order <- priority
+		}(priority)
+
+		waitForTurns(t, queue, "/foo", i+1)
+	}
+
+	// other keys take turns independently
+	require.Nil(t, queue.wait(ctxt, "/bar", 0))
+
+	for _, expected := range []int{2, 1, 0} {
+		queue.done("/foo")
+		assert.Equal(t, expected, <-order)
+	}
+
+	queue.done("/foo")
+	queue.done("/bar")
+	assert.Empty(t, queue.keys)
+}
+
+func Test_priorityQueue_Aging(t *testing.T) {
+	var (
+		queue = newPriorityQueue(10 * time.Millisecond)
+		ctxt  = context.Background()
+		order = make(chan int, 2)
+	)
+
+	require.Nil(t, queue.wait(ctxt, "/foo", 0))
+
+	go func() {
+		require.Nil(t, queue.wait(ctxt, "/foo", 0))
+		order <- 0
+	}()
+
+	waitForTurns(t, queue, "/foo", 1)
+
+	// the low priority request ages beyond the high priority one
+	time.Sleep(50 * time.Millisecond)
+
+	go func() {
+		require.Nil(t, queue.wait(ctxt, "/foo", 2))
+		order <- 2
+	}()
+
+	waitForTurns(t, queue, "/foo", 2)
+
+	queue.done("/foo")
+	assert.Equal(t, 0, <-order)
+
+	queue.done("/foo")
+	assert.Equal(t, 2, <-order)
+}
+
+func Test_priorityQueue_Cancelled(t *testing.T) {
+	var (
+		queue        = newPriorityQueue(0)
+		ctxt, cancel = context.WithCancel(context.Background())
+		done         = make(chan error)
+	)
+
+	require.Nil(t, queue.wait(context.Background(), "/foo", 0))
+
+	go func() { done <- queue.wait(ctxt, "/foo", 0) }()
+	waitForTurns(t, queue, "/foo", 1)
+
+	// a cancelled request leaves the queue
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	waitForTurns(t, queue, "/foo", 0)
+
+	queue.done("/foo")
+	assert.Empty(t, queue.keys)
+}
+
+func Test_Limiter_Priority(t *testing.T) {
+	var (
+		served = make(chan string, 3)
+		proxy  = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served <- r.Header.Get("X-Tier")
+		})
+		acquirer = newLocalAcquirer(0)
+		waiter   = newWaiter()
+		limiter  = NewLimiter(proxy, acquirer,
+			WithWaiter(waiter),
+			WithPriority(HeaderPriority("X-Tier", map[string]int{"paid": 1}, 0), 0))
+	)
+
+	for i, tier := range []string{"free", "free", "paid"} {
+		req := request(t, "/foo")
+		req.Header = http.Header{"X-Tier": []string{tier}}
+
+		go limiter.ServeHTTP(httptest.NewRecorder(), req)
+
+		if i == 0 {
+			// the first request holds the turn and polls
+			continue
+		}
+
+		waitForTurns(t, limiter.priorities, "/foo", i)
+	}
+
+	// tokens become available for all three requests
+	acquirer.mu.Lock()
+	acquirer.count = 3
+	acquirer.mu.Unlock()
+
+	waiter.wake(1)
+
+	// the polling request is served before the paid request
+	// which has priority over the remaining free request
+	assert.Equal(t, "free", <-served)
+	assert.Equal(t, "paid", <-served)
+	assert.Equal(t, "free", <-served)
+}
+
+func waitForTurns(t *testing.T, queue *priorityQueue, key string, n int) {
+	t.Helper()
+
+	for {
+		queue.mu.Lock()
+		var waiting int
+		if ts, ok := queue.keys[key]; ok {
+			waiting = len(ts.waiting)
+		}
+		queue.mu.Unlock()
+
+		if waiting == n {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,85 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrReserveNotSupported is returned by ReserveN when the Acquirer
+// does not implement Reserver
+var ErrReserveNotSupported = errors.New("acquirer does not support reservations")
+
+// Reservation is a claim on tokens which may be used from a point
+// in time, similar to the Reservation of golang.org/x/time/rate
+// The tokens are consumed when the reservation is made so the
+// holder may proceed once the delay has passed without acquiring
+// again, or hand the tokens back by cancelling
+type Reservation struct {
+	ok     bool
+	at     time.Time
+	cancel Lease
+}
+
+// NewReservation returns a reservation of tokens which may be used
+// from at and which are handed back by calling cancel
+// cancel is only called the first time the reservation is cancelled
+func NewReservation(at time.Time, cancel LeaseFunc) Reservation {
+	return Reservation{ok: true, at: at, cancel: ReleaseOnce(cancel)}
+}
+
+// OK returns false when no tokens could be reserved
+// e.g. when the limit is reserved further ahead than is permitted
+func (r Reservation) OK() bool {
+	return r.ok
+}
+
+// Time returns the time from which the reserved tokens may be used
+func (r Reservation) Time() time.Time {
+	return r.at
+}
+
+// Delay returns how long the holder must wait before using
+// the reserved tokens, which is zero once they may be used
+func (r Reservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom returns how long after now the holder must wait
+// before using the reserved tokens
+func (r Reservation) DelayFrom(now time.Time) time.Duration {
+	if delay := r.at.Sub(now); delay > 0 {
+		return delay
+	}
+
+	return 0
+}
+
+// Cancel hands the reserved tokens back so that they can be
+// acquired by others
+// Cancelling a reservation which is not OK does nothing
+func (r Reservation) Cancel(ctxt context.Context) error {
+	if !r.ok || r.cancel == nil {
+		return nil
+	}
+
+	return r.cancel.Release(ctxt)
+}
+
+// Reserver is an Acquirer which can reserve tokens ahead of time
+// It should consume n tokens for key and return when they may be
+// used, or a Reservation which is not OK if they cannot be reserved
+type Reserver interface {
+	ReserveN(ctxt context.Context, key string, n int) (Reservation, error)
+}
+
+// ReserveN reserves n tokens for key from the provided Acquirer
+// given it implements Reserver
+// ErrReserveNotSupported is returned otherwise
+func ReserveN(ctxt context.Context, acquirer Acquirer, key string, n int) (Reservation, error) {
+	if reserver, ok := acquirer.(Reserver); ok {
+		return reserver.ReserveN(ctxt, key, n)
+	}
+
+	return Reservation{}, ErrReserveNotSupported
+}
@@ -0,0 +1,104 @@
+package rate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Reservation(t *testing.T) {
+	var (
+		now         = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		cancelled   int
+		reservation = NewReservation(now.Add(time.Second), func(context.Context) error {
+			cancelled++
+			return nil
+		})
+	)
+
+	assert.True(t, reservation.OK())
+	assert.Equal(t, now.Add(time.Second), reservation.Time())
+	assert.Equal(t, time.Second, reservation.DelayFrom(now))
+	assert.Equal(t, time.Duration(0), reservation.DelayFrom(now.Add(time.Minute)))
+
+	// tokens are only handed back once
+	require.Nil(t, reservation.Cancel(context.Background()))
+	require.Nil(t, reservation.Cancel(context.Background()))
+	assert.Equal(t, 1, cancelled)
+
+	// the zero reservation is not OK and cannot be cancelled
+	assert.False(t, Reservation{}.OK())
+	assert.Nil(t, Reservation{}.Cancel(context.Background()))
+
+	_, err := ReserveN(context.Background(), newLocalAcquirer(1), "/foo", 1)
+	assert.Equal(t, ErrReserveNotSupported, err)
+}
+
+func Test_Limiter_Reserve(t *testing.T) {
+	var (
+		proxiedCount int64
+		proxy        = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			atomic.AddInt64(&proxiedCount, 1)
+		})
+		// polling would wait forever
+		waiter = newWaiter()
+	)
+
+	t.Run("requests sleep until their reservation", func(t *testing.T) {
+		var (
+			acquirer = newReservingAcquirer(50 * time.Millisecond)
+			limiter  = NewLimiter(proxy, acquirer, WithWaiter(waiter))
+			start    = time.Now()
+			w        = httptest.NewRecorder()
+		)
+
+		limiter.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&proxiedCount))
+		assert.True(t, time.Since(start) >= 50*time.Millisecond)
+		assert.Len(t, acquirer.cancelled, 0)
+	})
+
+	t.Run("reservations beyond the maximum wait are cancelled", func(t *testing.T) {
+		var (
+			acquirer = newReservingAcquirer(time.Minute)
+			limiter  = NewLimiter(proxy, acquirer, WithWaiter(waiter), WithMaxWait(time.Second))
+			start    = time.Now()
+			w        = httptest.NewRecorder()
+		)
+
+		limiter.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+
+		// rejected straight away rather than after the maximum wait
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.True(t, time.Since(start) < time.Second)
+		assert.Equal(t, "/foo", <-acquirer.cancelled)
+	})
+
+	t.Run("reservations are cancelled when the client goes away", func(t *testing.T) {
+		var (
+			acquirer     = newReservingAcquirer(time.Minute)
+			limiter      = NewLimiter(proxy, acquirer, WithWaiter(waiter))
+			ctxt, cancel = context.WithCancel(context.Background())
+			done         = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+			limiter.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil).WithContext(ctxt))
+		}()
+
+		cancel()
+		<-done
+
+		assert.Equal(t, "/foo", <-acquirer.cancelled)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&proxiedCount))
+	})
+}
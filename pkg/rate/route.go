@@ -0,0 +1,121 @@
+package rate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	// hex segments must be at least 8 characters and contain
+	// a digit to avoid collapsing words such as "accepted"
+	hexSegment = regexp.MustCompile(`^[0-9a-fA-F]{8,}$`)
+	digit      = regexp.MustCompile(`[0-9]`)
+)
+
+// Normalizer is a type which collapses a request path
+// into a route template e.g. /users/123 into /users/{id}
+type Normalizer interface {
+	Normalize(path string) string
+}
+
+// NormalizerFunc is a function which implements the Normalizer interface
+type NormalizerFunc func(string) string
+
+// Normalize delegates to the wrapped NormalizerFunc
+func (fn NormalizerFunc) Normalize(path string) string { return fn(path) }
+
+// RouteNormalizer normalizes paths using a set of configured route patterns
+// Paths which match none of the patterns have any numeric, UUID or hex
+// segments replaced with the placeholder {id}
+type RouteNormalizer struct {
+	routes []route
+}
+
+type route struct {
+	pattern  string
+	segments []string
+}
+
+// NewRouteNormalizer constructs a RouteNormalizer from the provided patterns
+// A pattern is a path where any segment wrapped in braces matches any value
+// e.g. /users/{user}/posts/{post}
+// Patterns are matched in the order they are provided
+func NewRouteNormalizer(patterns ...string) (RouteNormalizer, error) {
+	var n RouteNormalizer
+
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "/") {
+			return n, fmt.Errorf("route pattern %q must begin with /", pattern)
+		}
+
+		segments := strings.Split(pattern, "/")
+		for _, segment := range segments {
+			if strings.ContainsAny(segment, "{}") && !isPlaceholder(segment) {
+				return n, fmt.Errorf("route pattern %q: malformed segment %q", pattern, segment)
+			}
+		}
+
+		n.routes = append(n.routes, route{pattern: pattern, segments: segments})
+	}
+
+	return n, nil
+}
+
+// Normalize returns the pattern of the first configured route which
+// matches the path, otherwise the path with any identifier-like
+// segments replaced
+func (n RouteNormalizer) Normalize(path string) string {
+	segments := strings.Split(path, "/")
+
+	for _, route := range n.routes {
+		if route.matches(segments) {
+			return route.pattern
+		}
+	}
+
+	for i, segment := range segments {
+		if isIdentifier(segment) {
+			segments[i] = "{id}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func (r route) matches(segments []string) bool {
+	if len(r.segments) != len(segments) {
+		return false
+	}
+
+	for i, segment := range r.segments {
+		if isPlaceholder(segment) {
+			if segments[i] == "" {
+				return false
+			}
+
+			continue
+		}
+
+		if segment != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isPlaceholder(segment string) bool {
+	return len(segment) > 2 &&
+		strings.HasPrefix(segment, "{") &&
+		strings.HasSuffix(segment, "}") &&
+		!strings.ContainsAny(segment[1:len(segment)-1], "{}")
+}
+
+func isIdentifier(segment string) bool {
+	return numericSegment.MatchString(segment) ||
+		uuidSegment.MatchString(segment) ||
+		(hexSegment.MatchString(segment) && digit.MatchString(segment))
+}
@@ -0,0 +1,69 @@
+package rate
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RouteNormalizer(t *testing.T) {
+	normalizer, err := NewRouteNormalizer("/users/{user}/posts/{post}", "/files/{name}")
+	require.Nil(t, err)
+
+	for _, test := range []struct {
+		path     string
+		expected string
+	}{
+		// configured patterns
+		{"/users/george/posts/hello-world", "/users/{user}/posts/{post}"},
+		{"/files/report.pdf", "/files/{name}"},
+		// automatic detection
+		{"/users/123", "/users/{id}"},
+		{"/users/124", "/users/{id}"},
+		{"/orders/3f2504e0-4f89-11d3-9a0c-0305e82c3301/items", "/orders/{id}/items"},
+		{"/commits/5d41402abc4b2a76b9719d911017c592", "/commits/{id}"},
+		// left alone
+		{"/", "/"},
+		{"/users", "/users"},
+		{"/users/accepted", "/users/accepted"},
+		{"/files/", "/files/"},
+		{"/users/george/posts", "/users/george/posts"},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			assert.Equal(t, test.expected, normalizer.Normalize(test.path))
+		})
+	}
+}
+
+func Test_RouteNormalizer_Invalid(t *testing.T) {
+	for _, pattern := range []string{
+		"users/{id}",
+		"/users/{id",
+		"/users/{}",
+		"/users/prefix-{id}",
+	} {
+		t.Run(pattern, func(t *testing.T) {
+			_, err := NewRouteNormalizer(pattern)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_Limiter_Normalizer(t *testing.T) {
+	var (
+		proxied  []string
+		proxy    = http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { proxied = append(proxied, r.URL.Path) })
+		acquirer = newLocalAcquirer(2)
+		limiter  = NewLimiter(proxy, acquirer, WithWaiter(newWaiter()), WithNormalizer(RouteNormalizer{}))
+	)
+
+	limiter.ServeHTTP(nil, request(t, "/users/123"))
+	limiter.ServeHTTP(nil, request(t, "/users/124"))
+
+	// the upstream sees the original paths
+	assert.Equal(t, []string{"/users/123", "/users/124"}, proxied)
+	// while both are limited under the same template
+	assert.Equal(t, map[string]int{"/users/{id}": 2}, acquirer.counts)
+}
@@ -0,0 +1,94 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrStatusNotSupported is returned by StatusOf when the Acquirer
+// does not implement Reporter
+var ErrStatusNotSupported = errors.New("acquirer does not support reporting status")
+
+// Status describes the budget of a key at a point in time
+type Status struct {
+	// Limit is the most tokens the key may hold at once
+	Limit int
+	// Remaining is the number of tokens which can currently be acquired
+	Remaining int
+	// Reset is how long until the key regains its full budget
+	Reset time.Duration
+}
+
+// Reporter is an Acquirer which can report the budget of a key
+// without consuming any of it
+type Reporter interface {
+	Status(ctxt context.Context, key string) (Status, error)
+}
+
+// StatusOf returns the budget of key as reported by the provided
+// Acquirer given it implements Reporter
+// ErrStatusNotSupported is returned otherwise
+func StatusOf(ctxt context.Context, acquirer Acquirer, key string) (Status, error) {
+	if reporter, ok := acquirer.(Reporter); ok {
+		return reporter.Status(ctxt, key)
+	}
+
+	return Status{}, ErrStatusNotSupported
+}
+
+// Min returns the more restrictive of the two statuses
+// The status with the fewest remaining tokens is the more restrictive
+// and the longest reset is preferred between equals
+func (s Status) Min(o Status) Status {
+	if o.Remaining < s.Remaining || (o.Remaining == s.Remaining && o.Reset > s.Reset) {
+		return o
+	}
+
+	return s
+}
+
+// SetHeaders sets the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers as described by the IETF RateLimit header
+// fields draft, along with their legacy X-RateLimit-* equivalents
+// RateLimit-Reset is the number of seconds until the reset whereas
+// X-RateLimit-Reset is the unix time of the reset
+func (s Status) SetHeaders(header http.Header) {
+	var (
+		limit     = strconv.Itoa(s.Limit)
+		remaining = strconv.Itoa(s.Remaining)
+		seconds   = int64(math.Ceil(s.Reset.Seconds()))
+	)
+
+	header.Set("RateLimit-Limit", limit)
+	header.Set("RateLimit-Remaining", remaining)
+	header.Set("RateLimit-Reset", strconv.FormatInt(seconds, 10))
+
+	header.Set("X-RateLimit-Limit", limit)
+	header.Set("X-RateLimit-Remaining", remaining)
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(s.Reset).Unix(), 10))
+}
+
+// ParseStatus parses the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers set by SetHeaders
+// false is returned when any of them are missing or malformed
+func ParseStatus(header http.Header) (Status, bool) {
+	var values [3]int
+	for i, name := range []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"} {
+		value, err := strconv.Atoi(header.Get(name))
+		if err != nil || value < 0 {
+			return Status{}, false
+		}
+
+		values[i] = value
+	}
+
+	return Status{
+		Limit:     values[0],
+		Remaining: values[1],
+		Reset:     time.Duration(values[2]) * time.Second,
+	}, true
+}
@@ -0,0 +1,198 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Status_SetHeaders(t *testing.T) {
+	var (
+		header = http.Header{}
+		before = time.Now()
+	)
+
+	Status{Limit: 10, Remaining: 3, Reset: 1500 * time.Millisecond}.SetHeaders(header)
+
+	assert.Equal(t, "10", header.Get("RateLimit-Limit"))
+	assert.Equal(t, "3", header.Get("RateLimit-Remaining"))
+	// partial seconds are rounded up
+	assert.Equal(t, "2", header.Get("RateLimit-Reset"))
+
+	assert.Equal(t, "10", header.Get("X-RateLimit-Limit"))
+	assert.Equal(t, "3", header.Get("X-RateLimit-Remaining"))
+
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	require.Nil(t, err)
+	assert.InDelta(t, before.Add(1500*time.Millisecond).Unix(), reset, 1)
+}
+
+func Test_ParseStatus(t *testing.T) {
+	header := http.Header{}
+	Status{Limit: 10, Remaining: 3, Reset: 1500 * time.Millisecond}.SetHeaders(header)
+
+	status, ok := ParseStatus(header)
+	require.True(t, ok)
+	assert.Equal(t, Status{Limit: 10, Remaining: 3, Reset: 2 * time.Second}, status)
+
+	header.Del("RateLimit-Remaining")
+
+	_, ok = ParseStatus(header)
+	assert.False(t, ok)
+
+	header.Set("RateLimit-Remaining", "-1")
+
+	_, ok = ParseStatus(header)
+	assert.False(t, ok)
+}
+
+func Test_Status_Min(t *testing.T) {
+	var (
+		fewer  = Status{Limit: 10, Remaining: 1, Reset: time.Second}
+		more   = Status{Limit: 5, Remaining: 4, Reset: time.Minute}
+		longer = Status{Limit: 10, Remaining: 1, Reset: time.Minute}
+	)
+
+	assert.Equal(t, fewer, fewer.Min(more))
+	assert.Equal(t, fewer, more.Min(fewer))
+	assert.Equal(t, longer, fewer.Min(longer))
+}
+
+func Test_StatusOf(t *testing.T) {
+	var (
+		ctxt     = context.Background()
+		acquirer = newReportingAcquirer(3)
+	)
+
+	_, err := StatusOf(ctxt, newLocalAcquirer(3), "/foo")
+	assert.Equal(t, ErrStatusNotSupported, err)
+
+	acquired, err := acquirer.AcquireN(ctxt, "/foo", 2)
+	require.Nil(t, err)
+	require.True(t, acquired)
+
+	status, err := StatusOf(ctxt, acquirer, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, Status{Limit: 3, Remaining: 1, Reset: time.Minute}, status)
+}
+
+func Test_Combinators_Status(t *testing.T) {
+	var (
+		ctxt   = context.Background()
+		first  = newReportingAcquirer(3)
+		second = newReportingAcquirer(5)
+	)
+
+	_, err := first.AcquireN(ctxt, "/foo", 1)
+	require.Nil(t, err)
+
+	_, err = second.AcquireN(ctxt, "/foo", 4)
+	require.Nil(t, err)
+
+	t.Run("all reports the most restrictive child", func(t *testing.T) {
+		status, err := StatusOf(ctxt, All(first, newLocalAcquirer(1), second), "/foo")
+		require.Nil(t, err)
+		assert.Equal(t, 1, status.Remaining)
+		assert.Equal(t, 5, status.Limit)
+	})
+
+	t.Run("all without reporters is not supported", func(t *testing.T) {
+		_, err := StatusOf(ctxt, All(newLocalAcquirer(1)), "/foo")
+		assert.Equal(t, ErrStatusNotSupported, err)
+	})
+
+	t.Run("fallback reports the secondary when the primary errors", func(t *testing.T) {
+		failing := All(first, erroringReporter{errors.New("unavailable")})
+
+		status, err := StatusOf(ctxt, Fallback(failing, second), "/foo")
+		require.Nil(t, err)
+		assert.Equal(t, 5, status.Limit)
+	})
+
+	t.Run("keyed reports the derived key", func(t *testing.T) {
+		acquirer := Keyed(first, func(*http.Request) string { return "/foo" })
+
+		status, err := StatusOf(ContextWithRequest(ctxt, request(t, "/bar")), acquirer, "/bar")
+		require.Nil(t, err)
+		assert.Equal(t, 2, status.Remaining)
+	})
+}
+
+func Test_Limiter_Status(t *testing.T) {
+	var (
+		forwarded = make(chan http.Header, 3)
+		proxy     = http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			forwarded <- r.Header
+		})
+		acquirer = newReportingAcquirer(2)
+		limiter  = NewLimiter(proxy, acquirer, WithReject(), WithUpstreamHeaders("X-Limit-Key", "X-Limit-Remaining"))
+	)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		limiter.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(1-i), w.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+
+		header := <-forwarded
+		assert.Equal(t, "/foo", header.Get("X-Limit-Key"))
+		assert.Equal(t, strconv.Itoa(1-i), header.Get("X-Limit-Remaining"))
+	}
+
+	w := httptest.NewRecorder()
+	limiter.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+
+	// rejected requests report an exhausted budget
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Len(t, forwarded, 0)
+
+	t.Run("headers can be hidden", func(t *testing.T) {
+		limiter := NewLimiter(proxy, newReportingAcquirer(2), WithoutStatusHeaders(), WithUpstreamHeaders("", "X-Limit-Remaining"))
+
+		w := httptest.NewRecorder()
+		limiter.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+
+		assert.Empty(t, w.Header().Get("RateLimit-Remaining"))
+
+		header := <-forwarded
+		assert.Empty(t, header.Get("X-Limit-Key"))
+		assert.Equal(t, "1", header.Get("X-Limit-Remaining"))
+	})
+
+	t.Run("requests without headers", func(t *testing.T) {
+		limiter := NewLimiter(proxy, newReportingAcquirer(2), WithUpstreamHeaders("X-Limit-Key", "X-Limit-Remaining"))
+
+		// requests constructed by hand may have a nil header
+		limiter.ServeHTTP(httptest.NewRecorder(), request(t, "/foo"))
+
+		header := <-forwarded
+		assert.Equal(t, "/foo", header.Get("X-Limit-Key"))
+		assert.Equal(t, "1", header.Get("X-Limit-Remaining"))
+	})
+}
+
+func Test_headerValue(t *testing.T) {
+	assert.Equal(t, "acme:/foo", headerValue(JoinHierarchy("acme", "/foo")))
+	assert.Equal(t, "/foobar", headerValue("/foo\nbar"))
+}
+
+// erroringReporter is an Acquirer which fails to report its status
+type erroringReporter struct {
+	err error
+}
+
+func (e erroringReporter) Acquire(context.Context, string) (bool, error) { return false, e.err }
+
+func (e erroringReporter) Status(context.Context, string) (Status, error) { return Status{}, e.err }
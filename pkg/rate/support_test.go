@@ -2,10 +2,12 @@ package rate
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/url"
 	"sync"
 	"testing"
+	"time"
 )
 
 type localAcquirer struct {
@@ -27,23 +29,51 @@ func (a *localAcquirer) clear() {
 	a.counts = map[string]int{}
 }
 
-func (a *localAcquirer) Acquire(_ context.Context, key string) (bool, error) {
+func (a *localAcquirer) Acquire(ctxt context.Context, key string) (bool, error) {
+	return a.AcquireN(ctxt, key, 1)
+}
+
+func (a *localAcquirer) AcquireN(_ context.Context, key string, n int) (bool, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if count, ok := a.counts[key]; ok {
-		if count >= a.count {
-			return false, nil
-		}
+	if a.counts[key]+n > a.count {
+		return false, nil
+	}
+
+	a.counts[key] += n
 
-		a.counts[key] = count + 1
+	return true, nil
+}
+
+func (a *localAcquirer) Refund(_ context.Context, key string, n int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-		return true, nil
+	if count, ok := a.counts[key]; ok && count >= n {
+		a.counts[key] = count - n
 	}
 
-	a.counts[key] = 1
+	return nil
+}
 
-	return true, nil
+func (a *localAcquirer) countFor(key string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.counts[key]
+}
+
+// staticAcquirer always returns the configured result
+type staticAcquirer struct {
+	acquired bool
+	err      error
+	calls    int
+}
+
+func (a *staticAcquirer) Acquire(context.Context, string) (bool, error) {
+	a.calls++
+	return a.acquired, a.err
 }
 
 type waiter struct {
@@ -77,3 +107,112 @@ func request(t *testing.T, path string) *http.Request {
 
 	return &http.Request{URL: url}
 }
+
+// leasingAcquirer is a localAcquirer whose tokens are
+// returned when the lease is released
+type leasingAcquirer struct {
+	*localAcquirer
+}
+
+func newLeasingAcquirer(count int) leasingAcquirer {
+	return leasingAcquirer{newLocalAcquirer(count)}
+}
+
+func (a leasingAcquirer) Lease(ctxt context.Context, key string, n int) (Lease, bool, error) {
+	acquired, err := a.AcquireN(ctxt, key, n)
+	if err != nil || !acquired {
+		return nil, acquired, err
+	}
+
+	return ReleaseOnce(func(ctxt context.Context) error {
+		return a.Refund(ctxt, key, n)
+	}), true, nil
+}
+
+// claimingAcquirer is a localAcquirer which counts the tokens of each
+// key per interval and refunds claims to the interval they were made in
+type claimingAcquirer struct {
+	*localAcquirer
+
+	interval int
+}
+
+func newClaimingAcquirer(count int) *claimingAcquirer {
+	return &claimingAcquirer{localAcquirer: newLocalAcquirer(count)}
+}
+
+func (a *claimingAcquirer) intervalKey(key string, interval int) string {
+	return fmt.Sprintf("%s/%d", key, interval)
+}
+
+func (a *claimingAcquirer) Acquire(ctxt context.Context, key string) (bool, error) {
+	return a.AcquireN(ctxt, key, 1)
+}
+
+func (a *claimingAcquirer) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	return a.localAcquirer.AcquireN(ctxt, a.intervalKey(key, a.interval), n)
+}
+
+func (a *claimingAcquirer) ClaimN(ctxt context.Context, key string, n int) (RefundFunc, bool, error) {
+	interval := a.interval
+	acquired, err := a.localAcquirer.AcquireN(ctxt, a.intervalKey(key, interval), n)
+
+	return func(ctxt context.Context) error {
+		return a.localAcquirer.Refund(ctxt, a.intervalKey(key, interval), n)
+	}, acquired, err
+}
+
+// waitingAcquirer hands a token to a waiting caller
+// each time a value is sent on handoff
+type waitingAcquirer struct {
+	*localAcquirer
+
+	handoff chan struct{}
+}
+
+func newWaitingAcquirer() waitingAcquirer {
+	return waitingAcquirer{newLocalAcquirer(0), make(chan struct{})}
+}
+
+func (a waitingAcquirer) WaitN(ctxt context.Context, _ string, _ int) error {
+	select {
+	case <-a.handoff:
+		return nil
+	case <-ctxt.Done():
+		return ctxt.Err()
+	}
+}
+
+// reportingAcquirer is a localAcquirer which reports the
+// tokens remaining for each key which reset in a minute
+type reportingAcquirer struct {
+	*localAcquirer
+}
+
+func newReportingAcquirer(count int) reportingAcquirer {
+	return reportingAcquirer{newLocalAcquirer(count)}
+}
+
+func (a reportingAcquirer) Status(_ context.Context, key string) (Status, error) {
+	return Status{Limit: a.count, Remaining: a.count - a.countFor(key), Reset: time.Minute}, nil
+}
+
+// reservingAcquirer denies every acquisition but reserves
+// tokens which may be used after delay
+type reservingAcquirer struct {
+	*localAcquirer
+
+	delay     time.Duration
+	cancelled chan string
+}
+
+func newReservingAcquirer(delay time.Duration) reservingAcquirer {
+	return reservingAcquirer{newLocalAcquirer(0), delay, make(chan string, 1)}
+}
+
+func (a reservingAcquirer) ReserveN(_ context.Context, key string, _ int) (Reservation, error) {
+	return NewReservation(time.Now().Add(a.delay), func(context.Context) error {
+		a.cancelled <- key
+		return nil
+	}), nil
+}
@@ -0,0 +1,29 @@
+package rate
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrWaitNotSupported is returned by WaitN when the Acquirer
+// does not implement WaitAcquirer
+var ErrWaitNotSupported = errors.New("acquirer does not support waiting for tokens")
+
+// WaitAcquirer is an Acquirer which can block callers until tokens
+// are handed to them, rather than being polled
+// It should return nil only once all n tokens have been consumed
+// and the error of the context if it is cancelled while waiting
+type WaitAcquirer interface {
+	WaitN(ctxt context.Context, key string, n int) error
+}
+
+// WaitN blocks until n tokens for key are handed over by the provided
+// Acquirer given it implements WaitAcquirer
+// ErrWaitNotSupported is returned otherwise
+func WaitN(ctxt context.Context, acquirer Acquirer, key string, n int) error {
+	if wacquirer, ok := acquirer.(WaitAcquirer); ok {
+		return wacquirer.WaitN(ctxt, key, n)
+	}
+
+	return ErrWaitNotSupported
+}
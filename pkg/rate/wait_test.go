@@ -0,0 +1,61 @@
+package rate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Limiter_Wait(t *testing.T) {
+	var (
+		proxy    = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+		acquirer = newWaitingAcquirer()
+		// the waiter is never woken so polling would block forever
+		limiter = NewLimiter(proxy, acquirer, WithWaiter(newWaiter()))
+		done    = make(chan int)
+	)
+
+	go func() {
+		rec := httptest.NewRecorder()
+		limiter.ServeHTTP(rec, request(t, "/foo"))
+		done <- rec.Code
+	}()
+
+	// the request is served once handed a token
+	acquirer.handoff <- struct{}{}
+	assert.Equal(t, http.StatusOK, <-done)
+}
+
+func Test_Limiter_Wait_Cancelled(t *testing.T) {
+	var (
+		proxy = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			t.Error("request should not be proxied")
+		})
+		limiter      = NewLimiter(proxy, newWaitingAcquirer(), WithWaiter(newWaiter()))
+		ctxt, cancel = context.WithCancel(context.Background())
+		rec          = httptest.NewRecorder()
+	)
+
+	cancel()
+
+	limiter.ServeHTTP(rec, request(t, "/foo").WithContext(ctxt))
+
+	// nothing is written to a client which has gone away
+	assert.False(t, rec.Flushed)
+	assert.Equal(t, 0, rec.Body.Len())
+}
+
+func Test_Limiter_Wait_Reject(t *testing.T) {
+	var (
+		proxy   = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+		limiter = NewLimiter(proxy, newWaitingAcquirer(), WithReject())
+		rec     = httptest.NewRecorder()
+	)
+
+	// rejecting limiters never wait
+	limiter.ServeHTTP(rec, request(t, "/foo"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
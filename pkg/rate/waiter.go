@@ -34,3 +34,19 @@ func NextIntervalWaiter(interval time.Duration) Waiter {
 		}
 	})
 }
+
+// DelayWaiter returns a Waiter which blocks for the provided
+// delay or until the provided context.Context is cancelled
+// It suits acquirers which regain capacity continuously, where
+// waiting for the time taken to regain a single token avoids
+// waking every blocked request at once
+func DelayWaiter(delay time.Duration) Waiter {
+	return WaiterFunc(func(ctxt context.Context) {
+		select {
+		case <-time.After(delay):
+			// block until delay has passed
+		case <-ctxt.Done():
+			// unless context done is closed
+		}
+	})
+}
@@ -25,3 +25,26 @@ func Test_NextIntervalWaiter(t *testing.T) {
 
 	now.Before(<-finished)
 }
+
+func Test_DelayWaiter(t *testing.T) {
+	var (
+		waiter = DelayWaiter(50 * time.Millisecond)
+		start  = time.Now()
+	)
+
+	waiter.Wait(context.Background())
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected to wait at least 50ms, waited %v", elapsed)
+	}
+
+	ctxt, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start = time.Now()
+	DelayWaiter(time.Hour).Wait(ctxt)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected cancelled context to return immediately, waited %v", elapsed)
+	}
+}
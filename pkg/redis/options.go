@@ -0,0 +1,42 @@
+package redis
+
+// Option is a functional option for *Semaphore
+type Option func(*Semaphore)
+
+// Options is a slice of Option types
+type Options []Option
+
+// Apply calls each options from o on Semaphore s in order
+func (o Options) Apply(s *Semaphore) {
+	for _, opt := range o {
+		opt(s)
+	}
+}
+
+// WithNamespace configures a prefix for every key written to Redis, which
+// separates the keys of the Semaphore from those of other applications
+func WithNamespace(namespace string) Option {
+	return func(s *Semaphore) {
+		s.namespace = namespace
+	}
+}
+
+// WithSlidingWindow configures the Semaphore as a sliding window counter
+// The limit is enforced over a window of one period which slides with
+// time by weighting the count of the previous period by the proportion
+// of it which still overlaps the window
+func WithSlidingWindow() Option {
+	return func(s *Semaphore) {
+		s.algorithm = slidingWindow
+	}
+}
+
+// WithTokenBucket configures the Semaphore as a token bucket which holds
+// at most burst tokens and regains a token every period / limit
+// The limit is used as the burst when burst <= 0
+func WithTokenBucket(burst int) Option {
+	return func(s *Semaphore) {
+		s.algorithm = tokenBucket
+		s.burst = burst
+	}
+}
@@ -0,0 +1,110 @@
+package redis
+
+import "github.com/go-redis/redis"
+
+// The scripts below are run with EVALSHA, falling back to EVAL the first
+// time a server has not seen them, so each check is a single atomic round trip
+// Time is provided by the caller rather than read with TIME, as scripts
+// which write after calling TIME cannot be replicated verbatim
+
+// fixedWindowScript claims tokens from the count of the current interval
+// KEYS[1] is the count of the current interval
+// ARGV[1] is the limit, ARGV[2] the number of tokens requested and
+// ARGV[3] the number of milliseconds until the interval ends
+// It returns 1 if the tokens were claimed and 0 otherwise
+var fixedWindowScript = redis.NewScript(`
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+if count + tonumber(ARGV[2]) > tonumber(ARGV[1]) then
+	return 0
+end
+
+redis.call("INCRBY", KEYS[1], ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+// slidingWindowScript claims tokens from the count of the current interval
+// given the estimate of the sliding window is within the limit
+// KEYS[1] is the count of the current interval and KEYS[2] the count
+// of the previous interval
+// ARGV[1] is the limit, ARGV[2] the number of tokens requested, ARGV[3]
+// the proportion of the previous interval which overlaps the window and
+// ARGV[4] the number of milliseconds the current count must be kept
+// It returns 1 if the tokens were claimed and 0 otherwise
+var slidingWindowScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local previous = tonumber(redis.call("GET", KEYS[2]) or "0")
+if previous * tonumber(ARGV[3]) + current + tonumber(ARGV[2]) > tonumber(ARGV[1]) then
+	return 0
+end
+
+redis.call("INCRBY", KEYS[1], ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+return 1
+`)
+
+// refundCountScript returns tokens to a count without taking it below zero
+// KEYS[1] is the count and ARGV[1] the number of tokens returned
+// It returns the number of tokens which were returned
+var refundCountScript = redis.NewScript(`
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+local n = math.min(count, tonumber(ARGV[1]))
+if n > 0 then
+	redis.call("DECRBY", KEYS[1], n)
+end
+
+return n
+`)
+
+// tokenBucketScript refills the bucket for the time passed since it was
+// last updated and takes tokens from it given it holds enough
+// KEYS[1] is the bucket, a hash of its tokens and when it was last updated
+// ARGV[1] is the burst, ARGV[2] the number of microseconds it takes to
+// regain a token, ARGV[3] the number of tokens requested and ARGV[4]
+// the current unix time in microseconds
+// The bucket expires once it would be full again, as a missing bucket is full
+// It returns 1 if the tokens were taken and 0 otherwise
+var tokenBucketScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[4])
+local tokens, last = burst, now
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "last")
+if bucket[1] then
+	tokens = tonumber(bucket[1])
+	last = tonumber(bucket[2])
+end
+
+if now > last then
+	tokens = math.min(burst, tokens + (now - last) / interval)
+	last = now
+end
+
+local taken = 0
+if tokens >= tonumber(ARGV[3]) then
+	tokens = tokens - tonumber(ARGV[3])
+	taken = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tokens, "last", last)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) * interval / 1000) + 1)
+return taken
+`)
+
+// refundBucketScript returns tokens to a bucket without exceeding its burst
+// KEYS[1] is the bucket and ARGV[1], ARGV[2] and ARGV[3] are the burst,
+// the number of microseconds it takes to regain a token and the number
+// of tokens returned
+// It returns 1 if the bucket exists and 0 otherwise
+var refundBucketScript = redis.NewScript(`
+local bucket = redis.call("HMGET", KEYS[1], "tokens")
+if not bucket[1] then
+	return 0
+end
+
+local tokens = math.min(tonumber(ARGV[1]), tonumber(bucket[1]) + tonumber(ARGV[3]))
+redis.call("HSET", KEYS[1], "tokens", tokens)
+redis.call("PEXPIRE", KEYS[1], math.ceil((tonumber(ARGV[1]) - tokens) * tonumber(ARGV[2]) / 1000) + 1)
+return 1
+`)
@@ -0,0 +1,328 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/go-redis/redis"
+)
+
+var (
+	now = func() time.Time { return time.Now().UTC() }
+
+	// ErrRateNotPermitted is returned by NewSemaphore when the
+	// limit or period are <= 0
+	ErrRateNotPermitted = errors.New("limit and period must be > 0")
+
+	// ErrCostExceedsLimit is returned by AcquireN when more tokens are
+	// requested at once than the limit would ever permit
+	ErrCostExceedsLimit = rate.ErrCostExceedsLimit
+)
+
+// algorithm identifies the script a Semaphore limits keys with
+type algorithm int
+
+const (
+	fixedWindow algorithm = iota
+	slidingWindow
+	tokenBucket
+)
+
+// Semaphore is a type which is backed by Redis
+// it enforces a certain limit of acquisitions for a provided key
+// per period, as a fixed window counter by default
+// Every acquisition is a single script evaluated atomically by Redis
+// so that any number of instances can share the same limits
+// Keys are wrapped in a hash tag so that every key a script touches
+// belongs to the same slot of a Redis Cluster
+type Semaphore struct {
+	client redis.Cmdable
+	// namespace prefixes every key (see WithNamespace)
+	namespace string
+
+	limit  int
+	period time.Duration
+
+	algorithm algorithm
+	// burst is the capacity of each key when operating as a token bucket
+	burst int
+}
+
+// NewSemaphore returns a configured Redis backed Semaphore which implements rate.Acquirer
+// The client may be any of the go-redis clients e.g. *redis.Client or *redis.ClusterClient
+func NewSemaphore(client redis.Cmdable, limit int, period time.Duration, opts ...Option) (*Semaphore, error) {
+	s := &Semaphore{client: client, limit: limit, period: period}
+
+	Options(opts).Apply(s)
+
+	if limit <= 0 || period <= 0 {
+		return nil, ErrRateNotPermitted
+	}
+
+	if s.burst <= 0 {
+		s.burst = limit
+	}
+
+	return s, nil
+}
+
+// Acquire attempts to acquire a "token" within Redis for the provided key
+// If successful is returns true and the caller can proceed safely
+// otherwise it returns false and the caller should try again later
+func (s *Semaphore) Acquire(ctxt context.Context, key string) (bool, error) {
+	return s.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN attempts to acquire n "tokens" within Redis for the provided key
+// Either all n are acquired and true is returned, or none are and false is returned
+func (s *Semaphore) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	_, acquired, err := s.ClaimN(ctxt, key, n)
+	return acquired, err
+}
+
+// ClaimN attempts to acquire n "tokens" within Redis for the provided key (see AcquireN)
+// The returned rate.RefundFunc returns the tokens to the count of the
+// interval they were acquired from rather than that of the current
+// interval, and does nothing once that count has expired
+// Token buckets have no intervals so their tokens are returned to the bucket
+func (s *Semaphore) ClaimN(ctxt context.Context, key string, n int) (rate.RefundFunc, bool, error) {
+	var (
+		now    = now()
+		cmd    *redis.Cmd
+		refund = func(ctxt context.Context) error {
+			return s.refund(ctxt, key, now, n)
+		}
+	)
+
+	if n > s.capacity() {
+		return refund, false, ErrCostExceedsLimit
+	}
+
+	select {
+	case <-ctxt.Done():
+		return refund, false, ctxt.Err()
+	default:
+	}
+
+	switch s.algorithm {
+	case slidingWindow:
+		var (
+			start = now.Truncate(s.period)
+			// proportion of the previous interval which overlaps the window
+			weight = 1 - float64(now.Sub(start))/float64(s.period)
+		)
+
+		// the current count is read as the previous count
+		// throughout the next interval so it must outlive it
+		cmd = slidingWindowScript.Run(s.client,
+			[]string{s.intervalKey(key, start), s.intervalKey(key, start.Add(-s.period))},
+			s.limit, n, weight, milliseconds(start.Add(2*s.period).Sub(now)))
+	case tokenBucket:
+		cmd = tokenBucketScript.Run(s.client, []string{s.bucketKey(key)},
+			s.burst, s.tokenInterval(), n, now.UnixNano()/int64(time.Microsecond))
+	default:
+		start := now.Truncate(s.period)
+
+		cmd = fixedWindowScript.Run(s.client, []string{s.intervalKey(key, start)},
+			s.limit, n, milliseconds(start.Add(s.period).Sub(now)))
+	}
+
+	acquired, err := cmd.Int64()
+	if err != nil {
+		return refund, false, err
+	}
+
+	return refund, acquired == 1, nil
+}
+
+// Refund returns n previously acquired "tokens" for the provided key
+// The tokens are returned to the count of the current interval, or
+// to the bucket of the key when operating as a token bucket
+// Tokens acquired from an interval which has since passed are returned
+// to the current interval instead, so ClaimN should be preferred
+func (s *Semaphore) Refund(ctxt context.Context, key string, n int) error {
+	return s.refund(ctxt, key, now(), n)
+}
+
+// refund returns n tokens for key to the count of the interval which
+// includes at given the count has yet to expire, or to the bucket of
+// the key when operating as a token bucket
+func (s *Semaphore) refund(ctxt context.Context, key string, at time.Time, n int) error {
+	select {
+	case <-ctxt.Done():
+		return ctxt.Err()
+	default:
+	}
+
+	if s.algorithm == tokenBucket {
+		return refundBucketScript.Run(s.client, []string{s.bucketKey(key)}, s.burst, s.tokenInterval(), n).Err()
+	}
+
+	var (
+		start   = at.Truncate(s.period)
+		expires = start.Add(s.period)
+	)
+
+	if s.algorithm == slidingWindow {
+		// the count is read as the previous count
+		// throughout the next interval
+		expires = expires.Add(s.period)
+	}
+
+	if !now().Before(expires) {
+		// the tokens expired along with the count
+		return nil
+	}
+
+	return refundCountScript.Run(s.client, []string{s.intervalKey(key, start)}, n).Err()
+}
+
+// Status returns the budget of the provided key without consuming any of it
+func (s *Semaphore) Status(ctxt context.Context, key string) (rate.Status, error) {
+	select {
+	case <-ctxt.Done():
+		return rate.Status{}, ctxt.Err()
+	default:
+	}
+
+	var (
+		now   = now()
+		start = now.Truncate(s.period)
+	)
+
+	switch s.algorithm {
+	case slidingWindow:
+		counts, err := floats(s.client.MGet(s.intervalKey(key, start), s.intervalKey(key, start.Add(-s.period))))
+		if err != nil {
+			return rate.Status{}, err
+		}
+
+		var (
+			// proportion of the previous interval which overlaps the window
+			weight   = 1 - float64(now.Sub(start))/float64(s.period)
+			estimate = counts[1]*weight + counts[0]
+			reset    time.Duration
+		)
+
+		switch {
+		case counts[0] > 0:
+			reset = start.Add(2 * s.period).Sub(now)
+		case counts[1] > 0:
+			reset = start.Add(s.period).Sub(now)
+		}
+
+		return status(s.limit, estimate, reset), nil
+	case tokenBucket:
+		bucket, err := floats(s.client.HMGet(s.bucketKey(key), "tokens", "last"))
+		if err != nil {
+			return rate.Status{}, err
+		}
+
+		var (
+			interval = time.Duration(s.tokenInterval()) * time.Microsecond
+			tokens   = float64(s.burst)
+		)
+
+		if bucket[0] > 0 || bucket[1] > 0 {
+			elapsed := float64(now.UnixNano()/int64(time.Microsecond)) - bucket[1]
+			tokens = math.Min(tokens, bucket[0]+math.Max(elapsed, 0)/float64(s.tokenInterval()))
+		}
+
+		return rate.Status{
+			Limit:     s.burst,
+			Remaining: int(tokens),
+			Reset:     time.Duration((float64(s.burst) - tokens) * float64(interval)),
+		}, nil
+	}
+
+	counts, err := floats(s.client.MGet(s.intervalKey(key, start)))
+	if err != nil {
+		return rate.Status{}, err
+	}
+
+	return status(s.limit, counts[0], start.Add(s.period).Sub(now)), nil
+}
+
+// capacity returns the most tokens a key can be granted at once
+func (s *Semaphore) capacity() int {
+	if s.algorithm == tokenBucket {
+		return s.burst
+	}
+
+	return s.limit
+}
+
+// tokenInterval returns the number of microseconds
+// a token bucket takes to regain a single token
+func (s *Semaphore) tokenInterval() int64 {
+	if interval := int64(s.period/time.Microsecond) / int64(s.limit); interval > 0 {
+		return interval
+	}
+
+	return 1
+}
+
+// intervalKey returns the key of the count of the interval beginning at when
+// The namespace is placed outside the hash tag so that it never
+// changes the slot the key belongs to
+func (s *Semaphore) intervalKey(key string, when time.Time) string {
+	return fmt.Sprintf("%s{%s}/%s", s.namespace, key, when.Format("2006-01-02T15:04:05.999999999"))
+}
+
+// bucketKey returns the key of the token bucket of key
+func (s *Semaphore) bucketKey(key string) string {
+	return fmt.Sprintf("%s{%s}/bucket", s.namespace, key)
+}
+
+// milliseconds returns d in whole milliseconds, which is at least
+// one as Redis deletes keys given an expiry <= 0
+func milliseconds(d time.Duration) int64 {
+	if ms := int64(d / time.Millisecond); ms > 0 {
+		return ms
+	}
+
+	return 1
+}
+
+// floats parses the values returned by MGET or HMGET
+// Missing values are treated as zero
+func floats(cmd *redis.SliceCmd) ([]float64, error) {
+	values, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make([]float64, len(values))
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		if parsed[i], err = strconv.ParseFloat(str, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	return parsed, nil
+}
+
+// status returns the status of a key of which count tokens
+// have been acquired of limit, which resets in reset
+func status(limit int, count float64, reset time.Duration) rate.Status {
+	remaining := limit - int(math.Ceil(count))
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	if reset < 0 {
+		reset = 0
+	}
+
+	return rate.Status{Limit: limit, Remaining: remaining, Reset: reset}
+}
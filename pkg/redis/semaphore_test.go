@@ -0,0 +1,293 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ rate.Acquirer = (*Semaphore)(nil)
+
+func setNow(t *testing.T, when *time.Time) func() {
+	t.Helper()
+
+	now = func() time.Time { return *when }
+	return func() { now = func() time.Time { return time.Now().UTC() } }
+}
+
+// newClient starts an in-process Redis and returns a client
+// connected to it, along with a func which closes both
+func newClient(t *testing.T) (*redis.Client, *miniredis.Miniredis, func()) {
+	t.Helper()
+
+	server, err := miniredis.Run()
+	require.Nil(t, err)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	return client, server, func() {
+		client.Close()
+		server.Close()
+	}
+}
+
+func attempter(t *testing.T, acquirer rate.Acquirer, key string) func(bool) {
+	return func(expected bool) {
+		t.Helper()
+
+		acquired, err := acquirer.Acquire(context.Background(), key)
+		require.Nil(t, err)
+		assert.Equal(t, expected, acquired)
+	}
+}
+
+func Test_Semaphore_FixedWindow(t *testing.T) {
+	var (
+		when                     = time.Date(2019, 5, 3, 12, 0, 30, 0, time.UTC)
+		reset                    = setNow(t, &when)
+		ctxt                     = context.Background()
+		client, server, closeAll = newClient(t)
+	)
+
+	defer reset()
+	defer closeAll()
+
+	sem, err := NewSemaphore(client, 2, time.Minute)
+	require.Nil(t, err)
+
+	attempt := attempter(t, sem, "/foo")
+	attempt(true)
+	attempt(true)
+	attempt(false)
+
+	// keys are limited independently
+	attempter(t, sem, "/bar")(true)
+
+	status, err := sem.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 2, Remaining: 0, Reset: 30 * time.Second}, status)
+
+	// the count expires with the interval
+	ttl := server.TTL(sem.intervalKey("/foo", when.Truncate(time.Minute)))
+	assert.Equal(t, 30*time.Second, ttl)
+
+	require.Nil(t, sem.Refund(ctxt, "/foo", 1))
+	attempt(true)
+	attempt(false)
+
+	_, err = sem.AcquireN(ctxt, "/foo", 3)
+	assert.Equal(t, ErrCostExceedsLimit, err)
+
+	// the next interval has a fresh count
+	when = when.Add(30 * time.Second)
+	attempt(true)
+
+	// refunds never take the count below zero
+	require.Nil(t, sem.Refund(ctxt, "/foo", 5))
+	attempt(true)
+	attempt(true)
+	attempt(false)
+}
+
+func Test_Semaphore_ClaimN(t *testing.T) {
+	var (
+		when                = time.Date(2019, 5, 3, 12, 0, 59, 0, time.UTC)
+		reset               = setNow(t, &when)
+		ctxt                = context.Background()
+		client, _, closeAll = newClient(t)
+	)
+
+	defer reset()
+	defer closeAll()
+
+	sem, err := NewSemaphore(client, 1, time.Minute)
+	require.Nil(t, err)
+
+	attempt := attempter(t, sem, "/foo")
+
+	refund, acquired, err := sem.ClaimN(ctxt, "/foo", 1)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// refunds within the interval return the tokens to it
+	require.Nil(t, refund(ctxt))
+	attempt(true)
+
+	refund, acquired, err = sem.ClaimN(ctxt, "/bar", 1)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// the next interval begins
+	when = when.Add(time.Second)
+	attempt = attempter(t, sem, "/bar")
+	attempt(true)
+	attempt(false)
+
+	// the count of the previous interval expired along with its
+	// tokens, so they are not returned to the current interval
+	require.Nil(t, refund(ctxt))
+	attempt(false)
+}
+
+func Test_Semaphore_SlidingWindow(t *testing.T) {
+	var (
+		when                = time.Date(2019, 5, 3, 12, 0, 59, 0, time.UTC)
+		reset               = setNow(t, &when)
+		ctxt                = context.Background()
+		client, _, closeAll = newClient(t)
+	)
+
+	defer reset()
+	defer closeAll()
+
+	sem, err := NewSemaphore(client, 4, time.Minute, WithSlidingWindow())
+	require.Nil(t, err)
+
+	attempt := attempter(t, sem, "/foo")
+	for i := 0; i < 4; i++ {
+		attempt(true)
+	}
+
+	attempt(false)
+
+	// half way through the next interval half of the
+	// previous count still overlaps the window
+	when = time.Date(2019, 5, 3, 12, 1, 30, 0, time.UTC)
+
+	status, err := sem.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 4, Remaining: 2, Reset: 30 * time.Second}, status)
+
+	attempt(true)
+	attempt(true)
+	attempt(false)
+}
+
+func Test_Semaphore_TokenBucket(t *testing.T) {
+	var (
+		when                = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset               = setNow(t, &when)
+		ctxt                = context.Background()
+		client, _, closeAll = newClient(t)
+	)
+
+	defer reset()
+	defer closeAll()
+
+	// one per second with bursts of 2
+	sem, err := NewSemaphore(client, 60, time.Minute, WithTokenBucket(2))
+	require.Nil(t, err)
+
+	attempt := attempter(t, sem, "/foo")
+	attempt(true)
+	attempt(true)
+	attempt(false)
+
+	// tokens are regained continuously
+	when = when.Add(500 * time.Millisecond)
+	attempt(false)
+
+	status, err := sem.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 2, Remaining: 0, Reset: 1500 * time.Millisecond}, status)
+
+	when = when.Add(500 * time.Millisecond)
+	attempt(true)
+	attempt(false)
+
+	require.Nil(t, sem.Refund(ctxt, "/foo", 1))
+	attempt(true)
+
+	_, err = sem.AcquireN(ctxt, "/foo", 3)
+	assert.Equal(t, ErrCostExceedsLimit, err)
+
+	// the bucket never holds more than the burst
+	when = when.Add(time.Minute)
+
+	acquired, err := sem.AcquireN(ctxt, "/foo", 2)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+	attempt(false)
+
+	// refunds never exceed the burst
+	require.Nil(t, sem.Refund(ctxt, "/foo", 5))
+
+	status, err = sem.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, 2, status.Remaining)
+}
+
+func Test_Semaphore_Namespace(t *testing.T) {
+	var (
+		when                     = time.Date(2019, 5, 3, 12, 0, 30, 0, time.UTC)
+		reset                    = setNow(t, &when)
+		client, server, closeAll = newClient(t)
+	)
+
+	defer reset()
+	defer closeAll()
+
+	sem, err := NewSemaphore(client, 1, time.Minute, WithNamespace("rate/"))
+	require.Nil(t, err)
+
+	other, err := NewSemaphore(client, 1, time.Minute)
+	require.Nil(t, err)
+
+	attempter(t, sem, "/foo")(true)
+	attempter(t, sem, "/foo")(false)
+
+	// the namespace is outside the hash tag so the slot is unchanged
+	assert.True(t, server.Exists("rate/{/foo}/2019-05-03T12:00:00"))
+
+	// semaphores in other namespaces keep their own counts
+	attempter(t, other, "/foo")(true)
+}
+
+func Test_Semaphore_SingleRoundTrip(t *testing.T) {
+	client, _, closeAll := newClient(t)
+	defer closeAll()
+
+	var sent []string
+	client.WrapProcess(func(process func(redis.Cmder) error) func(redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			sent = append(sent, cmd.Name())
+			return process(cmd)
+		}
+	})
+
+	for _, opts := range [][]Option{nil, {WithSlidingWindow()}, {WithTokenBucket(2)}} {
+		sem, err := NewSemaphore(client, 2, time.Minute, opts...)
+		require.Nil(t, err)
+
+		// acquisitions fall back to EVAL when
+		// the server has not seen the script
+		sent = nil
+		attempter(t, sem, "/foo")(true)
+		assert.Equal(t, []string{"evalsha", "eval"}, sent)
+
+		for _, script := range []*redis.Script{fixedWindowScript, slidingWindowScript, tokenBucketScript} {
+			require.Nil(t, script.Load(client).Err())
+		}
+
+		// once it has, each acquisition is a single EVALSHA
+		sent = nil
+		attempter(t, sem, "/bar")(true)
+		assert.Equal(t, []string{"evalsha"}, sent)
+
+		require.Nil(t, client.ScriptFlush().Err())
+	}
+}
+
+func Test_NewSemaphore_BadRate(t *testing.T) {
+	_, err := NewSemaphore(nil, 0, time.Minute)
+	assert.Equal(t, ErrRateNotPermitted, err)
+
+	_, err = NewSemaphore(nil, 1, 0)
+	assert.Equal(t, ErrRateNotPermitted, err)
+}
@@ -0,0 +1,175 @@
+package rls
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/georgemac/rate/pkg/policy"
+)
+
+// units are the units a limit may be expressed in
+// mapped to their period and the unit reported to Envoy
+var units = map[string]struct {
+	period time.Duration
+	unit   RateLimitResponse_RateLimit_Unit
+}{
+	"second": {time.Second, RateLimitResponse_RateLimit_SECOND},
+	"minute": {time.Minute, RateLimitResponse_RateLimit_MINUTE},
+	"hour":   {time.Hour, RateLimitResponse_RateLimit_HOUR},
+	"day":    {24 * time.Hour, RateLimitResponse_RateLimit_DAY},
+}
+
+// Config is a set of limits which are matched against the
+// descriptors of each rate limit request
+type Config struct {
+	Limits []Limit `json:"limits"`
+}
+
+// Limit describes a limit and the descriptors it applies to
+// Descriptors which match no limit are not limited
+type Limit struct {
+	// Name identifies the limit and namespaces its keys
+	Name string `json:"name"`
+	// Domain must equal the domain of the request
+	Domain string `json:"domain"`
+	// Entries must match the entries of a descriptor in order
+	Entries []Entry `json:"entries"`
+	// RequestsPerUnit is the number of requests permitted
+	// per distinct descriptor each unit
+	RequestsPerUnit int `json:"requests_per_unit"`
+	// Unit is one of second, minute, hour or day
+	Unit string `json:"unit"`
+}
+
+// Entry matches a single entry of a descriptor
+type Entry struct {
+	// Key must equal the key of the entry
+	Key string `json:"key"`
+	// Value must equal the value of the entry given it is set
+	// otherwise every value matches and is limited separately
+	Value string `json:"value"`
+}
+
+// Matches returns true if the entries of the descriptor
+// match the entries of the limit in order
+func (l Limit) Matches(descriptor *RateLimitDescriptor) bool {
+	if len(descriptor.Entries) != len(l.Entries) {
+		return false
+	}
+
+	for i, entry := range descriptor.Entries {
+		expected := l.Entries[i]
+		if entry.Key != expected.Key {
+			return false
+		}
+
+		if expected.Value != "" && entry.Value != expected.Value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Load decodes a JSON encoded Config from the provided reader
+// and validates the result
+func Load(r io.Reader) (Config, error) {
+	var config Config
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("decoding rate limit service config: %v", err)
+	}
+
+	return config, config.Validate()
+}
+
+// LoadFile opens and loads the Config found at the provided path
+func LoadFile(path string) (Config, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	defer fi.Close()
+
+	return Load(fi)
+}
+
+// Validate returns a policy.Errors containing every problem
+// found with the configured limits, or nil if there are none
+func (c Config) Validate() error {
+	var (
+		errs  policy.Errors
+		names = map[string]struct{}{}
+	)
+
+	if len(c.Limits) == 0 {
+		errs = append(errs, fmt.Errorf("config must contain at least one limit"))
+	}
+
+	for i, limit := range c.Limits {
+		name := limit.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+			errs = append(errs, fmt.Errorf("limit %s: name is required", name))
+		} else if _, ok := names[name]; ok {
+			errs = append(errs, fmt.Errorf("limit %q: name is not unique", name))
+		}
+
+		names[name] = struct{}{}
+
+		for _, err := range limit.validate() {
+			errs = append(errs, fmt.Errorf("limit %q: %v", name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (l Limit) validate() (errs []error) {
+	if l.Domain == "" {
+		errs = append(errs, fmt.Errorf("domain is required"))
+	}
+
+	if len(l.Entries) == 0 {
+		errs = append(errs, fmt.Errorf("at least one entry is required"))
+	}
+
+	for i, entry := range l.Entries {
+		if entry.Key == "" {
+			errs = append(errs, fmt.Errorf("entry #%d: key is required", i))
+		}
+	}
+
+	if l.RequestsPerUnit <= 0 {
+		errs = append(errs, fmt.Errorf("requests_per_unit must be > 0"))
+	}
+
+	if _, ok := units[l.Unit]; !ok {
+		errs = append(errs, fmt.Errorf("unit %q must be one of second, minute, hour or day", l.Unit))
+	}
+
+	return
+}
+
+// key returns the key the descriptor is limited by
+// which is namespaced by the name of the limit
+func (l Limit) key(descriptor *RateLimitDescriptor) string {
+	entries := make([]string, 0, len(descriptor.Entries))
+	for _, entry := range descriptor.Entries {
+		entries = append(entries, entry.Key+"="+entry.Value)
+	}
+
+	return l.Name + ":" + strings.Join(entries, ",")
+}
@@ -0,0 +1,83 @@
+package rls
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadFile_Example(t *testing.T) {
+	config, err := LoadFile("../../hack/rls.example.json")
+	require.Nil(t, err)
+
+	require.Len(t, config.Limits, 3)
+
+	login := config.Limits[1]
+	assert.Equal(t, "login", login.Name)
+	assert.Equal(t, "edge", login.Domain)
+	assert.Equal(t, []Entry{{Key: "remote_address"}, {Key: "path", Value: "/login"}}, login.Entries)
+	assert.Equal(t, 5, login.RequestsPerUnit)
+	assert.Equal(t, "minute", login.Unit)
+}
+
+func Test_Load_Invalid(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config string
+		errors []string
+	}{
+		{"malformed", `{"limits": [`, []string{"decoding rate limit service config"}},
+		{"unknown field", `{"limits": [{"name": "foo", "burst": 2}]}`, []string{"unknown field"}},
+		{"no limits", `{"limits": []}`, []string{"at least one limit"}},
+		{
+			"invalid limits",
+			`{"limits": [
+				{"domain": "edge", "entries": [{"key": "a"}], "requests_per_unit": 1, "unit": "second"},
+				{"name": "foo", "requests_per_unit": 0, "unit": "fortnight"},
+				{"name": "foo", "domain": "edge", "entries": [{"value": "b"}], "requests_per_unit": 1, "unit": "day"}
+			]}`,
+			[]string{
+				`limit #0: name is required`,
+				`limit "foo": domain is required`,
+				`limit "foo": at least one entry is required`,
+				`limit "foo": requests_per_unit must be > 0`,
+				`limit "foo": unit "fortnight" must be one of second, minute, hour or day`,
+				`limit "foo": name is not unique`,
+				`limit "foo": entry #0: key is required`,
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(test.config))
+			require.Error(t, err)
+
+			for _, msg := range test.errors {
+				assert.Contains(t, err.Error(), msg)
+			}
+		})
+	}
+}
+
+func Test_Limit_Matches(t *testing.T) {
+	limit := Limit{Entries: []Entry{{Key: "remote_address"}, {Key: "path", Value: "/login"}}}
+
+	descriptor := func(entries ...string) *RateLimitDescriptor {
+		d := &RateLimitDescriptor{}
+		for i := 0; i < len(entries); i += 2 {
+			d.Entries = append(d.Entries, &RateLimitDescriptor_Entry{Key: entries[i], Value: entries[i+1]})
+		}
+
+		return d
+	}
+
+	assert.True(t, limit.Matches(descriptor("remote_address", "10.0.0.1", "path", "/login")))
+	assert.True(t, limit.Matches(descriptor("remote_address", "10.0.0.2", "path", "/login")))
+
+	assert.False(t, limit.Matches(descriptor("remote_address", "10.0.0.1", "path", "/logout")))
+	assert.False(t, limit.Matches(descriptor("path", "/login", "remote_address", "10.0.0.1")))
+	assert.False(t, limit.Matches(descriptor("remote_address", "10.0.0.1")))
+
+	assert.Equal(t, ":remote_address=10.0.0.1,path=/login", limit.key(descriptor("remote_address", "10.0.0.1", "path", "/login")))
+}
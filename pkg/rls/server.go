@@ -0,0 +1,147 @@
+package rls
+
+import (
+	"context"
+	"time"
+
+	"github.com/georgemac/rate/pkg/policy"
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// refundTimeout bounds how long returning the tokens of a
+// request which is over the limit may take
+const refundTimeout = 5 * time.Second
+
+// Server implements the ShouldRateLimit RPC of the Envoy rate limit
+// service using a rate.Acquirer per configured limit
+// Every descriptor of a request must be within its limit for the
+// request to be permitted, in which case a token is consumed for
+// each descriptor, otherwise the tokens are refunded given the
+// acquirers are rate.Refunders
+type Server struct {
+	domains map[string][]limiter
+}
+
+type limiter struct {
+	limit    Limit
+	acquirer rate.Acquirer
+	current  *RateLimitResponse_RateLimit
+}
+
+// NewServer validates the provided config and constructs a Server
+// with an Acquirer per limit constructed by the provided factory
+func NewServer(config Config, factory policy.AcquirerFactory) (*Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	server := &Server{domains: map[string][]limiter{}}
+	for _, limit := range config.Limits {
+		unit := units[limit.Unit]
+
+		acquirer, err := factory(limit.RequestsPerUnit, unit.period)
+		if err != nil {
+			return nil, err
+		}
+
+		server.domains[limit.Domain] = append(server.domains[limit.Domain], limiter{
+			limit:    limit,
+			acquirer: acquirer,
+			current: &RateLimitResponse_RateLimit{
+				Name:            limit.Name,
+				RequestsPerUnit: uint32(limit.RequestsPerUnit),
+				Unit:            unit.unit,
+			},
+		})
+	}
+
+	return server, nil
+}
+
+// ShouldRateLimit decides whether every descriptor of the request is
+// within the first limit it matches
+// The response contains a status per descriptor, in the order they
+// were requested, describing the limit applied and the budget remaining
+// An Unavailable error is returned if any acquirer errors
+func (s *Server) ShouldRateLimit(ctxt context.Context, req *RateLimitRequest) (*RateLimitResponse, error) {
+	var (
+		hits    = int(req.HitsAddend)
+		resp    = &RateLimitResponse{OverallCode: RateLimitResponse_OK}
+		granted rate.Refunds
+	)
+
+	if hits == 0 {
+		hits = 1
+	}
+
+	for _, descriptor := range req.Descriptors {
+		limiter, ok := s.match(req.Domain, descriptor)
+		if !ok {
+			resp.Statuses = append(resp.Statuses, &RateLimitResponse_DescriptorStatus{Code: RateLimitResponse_OK})
+			continue
+		}
+
+		key := limiter.limit.key(descriptor)
+
+		refund, acquired, err := rate.ClaimN(ctxt, limiter.acquirer, key, hits)
+		if err == rate.ErrCostExceedsLimit {
+			// the limit never permits that many hits at once
+			acquired, err = false, nil
+		}
+
+		if err != nil {
+			refundAll(granted)
+			return nil, status.Errorf(codes.Unavailable, "acquiring %q: %v", key, err)
+		}
+
+		descriptorStatus := &RateLimitResponse_DescriptorStatus{
+			Code:         RateLimitResponse_OK,
+			CurrentLimit: limiter.current,
+		}
+
+		if acquired {
+			granted = append(granted, refund)
+		} else {
+			descriptorStatus.Code = RateLimitResponse_OVER_LIMIT
+			resp.OverallCode = RateLimitResponse_OVER_LIMIT
+		}
+
+		// the budget is advisory so it is omitted when not reported
+		if budget, err := rate.StatusOf(ctxt, limiter.acquirer, key); err == nil {
+			descriptorStatus.LimitRemaining = uint32(budget.Remaining)
+			descriptorStatus.DurationUntilReset = ptypes.DurationProto(budget.Reset)
+		}
+
+		resp.Statuses = append(resp.Statuses, descriptorStatus)
+	}
+
+	if resp.OverallCode == RateLimitResponse_OVER_LIMIT {
+		refundAll(granted)
+	}
+
+	return resp, nil
+}
+
+// match returns the first limit of the domain which matches the descriptor
+func (s *Server) match(domain string, descriptor *RateLimitDescriptor) (limiter, bool) {
+	for _, limiter := range s.domains[domain] {
+		if limiter.limit.Matches(descriptor) {
+			return limiter, true
+		}
+	}
+
+	return limiter{}, false
+}
+
+// refundAll returns the tokens of every grant to the interval they
+// were acquired from using a fresh context, as the context of the
+// request may already be cancelled
+func refundAll(granted rate.Refunds) {
+	ctxt, cancel := context.WithTimeout(context.Background(), refundTimeout)
+	defer cancel()
+
+	granted.Refund(ctxt)
+}
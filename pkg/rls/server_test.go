@@ -0,0 +1,194 @@
+package rls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/georgemac/rate/pkg/sync"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serve starts a gRPC server serving the rate limit service on a
+// local port and returns a client connected to it
+func serve(t *testing.T, config Config, factory func(int, time.Duration) (rate.Acquirer, error)) (RateLimitServiceClient, func()) {
+	t.Helper()
+
+	server, err := NewServer(config, factory)
+	require.Nil(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	srv := grpc.NewServer()
+	RegisterRateLimitServiceServer(srv, server)
+
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	require.Nil(t, err)
+
+	return NewRateLimitServiceClient(conn), func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func keyedSemaphore(limit int, period time.Duration) (rate.Acquirer, error) {
+	return sync.NewKeyedSemaphore(limit, period)
+}
+
+func request(domain string, descriptors ...[]string) *RateLimitRequest {
+	req := &RateLimitRequest{Domain: domain}
+	for _, entries := range descriptors {
+		descriptor := &RateLimitDescriptor{}
+		for i := 0; i < len(entries); i += 2 {
+			descriptor.Entries = append(descriptor.Entries, &RateLimitDescriptor_Entry{Key: entries[i], Value: entries[i+1]})
+		}
+
+		req.Descriptors = append(req.Descriptors, descriptor)
+	}
+
+	return req
+}
+
+func Test_Server_ShouldRateLimit(t *testing.T) {
+	config := Config{Limits: []Limit{
+		{Name: "client", Domain: "edge", Entries: []Entry{{Key: "remote_address"}}, RequestsPerUnit: 3, Unit: "minute"},
+		{Name: "login", Domain: "edge", Entries: []Entry{{Key: "path", Value: "/login"}}, RequestsPerUnit: 1, Unit: "hour"},
+	}}
+
+	client, stop := serve(t, config, keyedSemaphore)
+	defer stop()
+
+	var (
+		ctxt   = context.Background()
+		alice  = []string{"remote_address", "10.0.0.1"}
+		bob    = []string{"remote_address", "10.0.0.2"}
+		login  = []string{"path", "/login"}
+		public = []string{"path", "/public"}
+	)
+
+	should := func(req *RateLimitRequest, expected RateLimitResponse_Code) *RateLimitResponse {
+		t.Helper()
+
+		resp, err := client.ShouldRateLimit(ctxt, req)
+		require.Nil(t, err)
+		assert.Equal(t, expected, resp.OverallCode)
+		require.Len(t, resp.Statuses, len(req.Descriptors))
+
+		return resp
+	}
+
+	resp := should(request("edge", alice, public), RateLimitResponse_OK)
+
+	// the status describes the limit and the budget remaining
+	perClient := resp.Statuses[0]
+	assert.Equal(t, RateLimitResponse_OK, perClient.Code)
+	assert.Equal(t, "client", perClient.CurrentLimit.Name)
+	assert.Equal(t, uint32(3), perClient.CurrentLimit.RequestsPerUnit)
+	assert.Equal(t, RateLimitResponse_RateLimit_MINUTE, perClient.CurrentLimit.Unit)
+	assert.Equal(t, uint32(2), perClient.LimitRemaining)
+
+	reset, err := ptypes.Duration(perClient.DurationUntilReset)
+	require.Nil(t, err)
+	assert.True(t, reset > 0 && reset <= time.Minute)
+
+	// descriptors which match no limit are not limited
+	assert.Equal(t, RateLimitResponse_OK, resp.Statuses[1].Code)
+	assert.Nil(t, resp.Statuses[1].CurrentLimit)
+
+	should(request("edge", alice, login), RateLimitResponse_OK)
+
+	// login is over its limit so the token taken for alice is refunded
+	resp = should(request("edge", alice, login), RateLimitResponse_OVER_LIMIT)
+	assert.Equal(t, RateLimitResponse_OK, resp.Statuses[0].Code)
+	assert.Equal(t, RateLimitResponse_OVER_LIMIT, resp.Statuses[1].Code)
+
+	resp = should(request("edge", alice), RateLimitResponse_OK)
+	assert.Equal(t, uint32(0), resp.Statuses[0].LimitRemaining)
+
+	should(request("edge", alice), RateLimitResponse_OVER_LIMIT)
+
+	// distinct values are limited separately
+	should(request("edge", bob), RateLimitResponse_OK)
+
+	// hits are charged per request
+	req := request("edge", bob)
+	req.HitsAddend = 3
+	should(req, RateLimitResponse_OVER_LIMIT)
+
+	// hits beyond what the limit ever permits are over the limit
+	req = request("edge", []string{"remote_address", "10.0.0.3"})
+	req.HitsAddend = 4
+	should(req, RateLimitResponse_OVER_LIMIT)
+
+	// other domains are not limited
+	should(request("other", alice), RateLimitResponse_OK)
+}
+
+func Test_Server_Unavailable(t *testing.T) {
+	config := Config{Limits: []Limit{
+		{Name: "client", Domain: "edge", Entries: []Entry{{Key: "remote_address"}}, RequestsPerUnit: 1, Unit: "second"},
+	}}
+
+	client, stop := serve(t, config, func(int, time.Duration) (rate.Acquirer, error) {
+		return failingAcquirer{}, nil
+	})
+	defer stop()
+
+	_, err := client.ShouldRateLimit(context.Background(), request("edge", []string{"remote_address", "10.0.0.1"}))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// failingAcquirer always fails as though its backend were unavailable
+type failingAcquirer struct{}
+
+func (failingAcquirer) Acquire(context.Context, string) (bool, error) {
+	return false, errors.New("unavailable")
+}
+
+func Test_WireFormat(t *testing.T) {
+	// the encoding must match envoy/service/ratelimit/v3/rls.proto
+	req := request("edge", []string{"a", "b"})
+	req.HitsAddend = 3
+
+	encoded, err := proto.Marshal(req)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{
+		0x0a, 0x04, 'e', 'd', 'g', 'e', // domain = 1
+		0x12, 0x08, // descriptors = 2
+		0x0a, 0x06, // entries = 1
+		0x0a, 0x01, 'a', // key = 1
+		0x12, 0x01, 'b', // value = 2
+		0x18, 0x03, // hits_addend = 3
+	}, encoded)
+
+	encoded, err = proto.Marshal(&RateLimitResponse{
+		OverallCode: RateLimitResponse_OVER_LIMIT,
+		Statuses: []*RateLimitResponse_DescriptorStatus{{
+			Code:           RateLimitResponse_OVER_LIMIT,
+			CurrentLimit:   &RateLimitResponse_RateLimit{RequestsPerUnit: 5, Unit: RateLimitResponse_RateLimit_MINUTE},
+			LimitRemaining: 1,
+		}},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []byte{
+		0x08, 0x02, // overall_code = 1
+		0x12, 0x0a, // statuses = 2
+		0x08, 0x02, // code = 1
+		0x12, 0x04, // current_limit = 2
+		0x08, 0x05, // requests_per_unit = 1
+		0x10, 0x02, // unit = 2
+		0x18, 0x01, // limit_remaining = 3
+	}, encoded)
+}
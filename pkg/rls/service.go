@@ -0,0 +1,246 @@
+package rls
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	"google.golang.org/grpc"
+)
+
+// The types in this file mirror the messages of the Envoy rate limit
+// service as defined by envoy/service/ratelimit/v3/rls.proto
+// Each field is encoded using the field number and wire type given by
+// the proto definition so that they are understood by Envoy, without
+// depending on the generated code of go-control-plane
+// Fields which are not required to serve ShouldRateLimit are omitted
+// and are skipped when decoding
+
+// ServiceName is the fully qualified name of the rate limit service
+const ServiceName = "envoy.service.ratelimit.v3.RateLimitService"
+
+// RateLimitRequest asks whether the descriptors of a request are
+// within their limits
+type RateLimitRequest struct {
+	// Domain namespaces the descriptors e.g. the Envoy deployment
+	Domain string `protobuf:"bytes,1,opt,name=domain,proto3"`
+	// Descriptors are each limited independently
+	Descriptors []*RateLimitDescriptor `protobuf:"bytes,2,rep,name=descriptors,proto3"`
+	// HitsAddend is the cost of the request (treated as 1 when 0)
+	HitsAddend uint32 `protobuf:"varint,3,opt,name=hits_addend,json=hitsAddend,proto3"`
+}
+
+// Reset resets the request to its zero value
+func (m *RateLimitRequest) Reset() { *m = RateLimitRequest{} }
+
+// String returns the request in the protobuf text format
+func (m *RateLimitRequest) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage marks the request as a protobuf message
+func (*RateLimitRequest) ProtoMessage() {}
+
+// RateLimitDescriptor is an ordered list of entries describing
+// a request e.g. [remote_address=10.0.0.1, path=/foo]
+// It mirrors envoy.extensions.common.ratelimit.v3.RateLimitDescriptor
+type RateLimitDescriptor struct {
+	Entries []*RateLimitDescriptor_Entry `protobuf:"bytes,1,rep,name=entries,proto3"`
+}
+
+// Reset resets the descriptor to its zero value
+func (m *RateLimitDescriptor) Reset() { *m = RateLimitDescriptor{} }
+
+// String returns the descriptor in the protobuf text format
+func (m *RateLimitDescriptor) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage marks the descriptor as a protobuf message
+func (*RateLimitDescriptor) ProtoMessage() {}
+
+// RateLimitDescriptor_Entry is a single key value pair of a descriptor
+type RateLimitDescriptor_Entry struct {
+	Key   string `protobuf:"bytes,1,opt,name=key,proto3"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3"`
+}
+
+// Reset resets the entry to its zero value
+func (m *RateLimitDescriptor_Entry) Reset() { *m = RateLimitDescriptor_Entry{} }
+
+// String returns the entry in the protobuf text format
+func (m *RateLimitDescriptor_Entry) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage marks the entry as a protobuf message
+func (*RateLimitDescriptor_Entry) ProtoMessage() {}
+
+// RateLimitResponse_Code is the decision made for a request or descriptor
+type RateLimitResponse_Code int32
+
+const (
+	// RateLimitResponse_UNKNOWN is returned when no decision could be made
+	RateLimitResponse_UNKNOWN RateLimitResponse_Code = 0
+	// RateLimitResponse_OK permits the request
+	RateLimitResponse_OK RateLimitResponse_Code = 1
+	// RateLimitResponse_OVER_LIMIT limits the request
+	RateLimitResponse_OVER_LIMIT RateLimitResponse_Code = 2
+)
+
+func (c RateLimitResponse_Code) String() string {
+	switch c {
+	case RateLimitResponse_OK:
+		return "OK"
+	case RateLimitResponse_OVER_LIMIT:
+		return "OVER_LIMIT"
+	}
+
+	return "UNKNOWN"
+}
+
+// RateLimitResponse_RateLimit_Unit is the period of a limit
+type RateLimitResponse_RateLimit_Unit int32
+
+const (
+	// RateLimitResponse_RateLimit_UNKNOWN is a limit of an unknown period
+	RateLimitResponse_RateLimit_UNKNOWN RateLimitResponse_RateLimit_Unit = 0
+	// RateLimitResponse_RateLimit_SECOND is a limit per second
+	RateLimitResponse_RateLimit_SECOND RateLimitResponse_RateLimit_Unit = 1
+	// RateLimitResponse_RateLimit_MINUTE is a limit per minute
+	RateLimitResponse_RateLimit_MINUTE RateLimitResponse_RateLimit_Unit = 2
+	// RateLimitResponse_RateLimit_HOUR is a limit per hour
+	RateLimitResponse_RateLimit_HOUR RateLimitResponse_RateLimit_Unit = 3
+	// RateLimitResponse_RateLimit_DAY is a limit per day
+	RateLimitResponse_RateLimit_DAY RateLimitResponse_RateLimit_Unit = 4
+)
+
+func (u RateLimitResponse_RateLimit_Unit) String() string {
+	switch u {
+	case RateLimitResponse_RateLimit_SECOND:
+		return "SECOND"
+	case RateLimitResponse_RateLimit_MINUTE:
+		return "MINUTE"
+	case RateLimitResponse_RateLimit_HOUR:
+		return "HOUR"
+	case RateLimitResponse_RateLimit_DAY:
+		return "DAY"
+	}
+
+	return "UNKNOWN"
+}
+
+// RateLimitResponse is the decision made for a RateLimitRequest
+type RateLimitResponse struct {
+	// OverallCode is OVER_LIMIT if any descriptor is over its limit
+	OverallCode RateLimitResponse_Code `protobuf:"varint,1,opt,name=overall_code,json=overallCode,proto3,enum=envoy.service.ratelimit.v3.RateLimitResponse_Code"`
+	// Statuses are the decisions for each descriptor in the order requested
+	Statuses []*RateLimitResponse_DescriptorStatus `protobuf:"bytes,2,rep,name=statuses,proto3"`
+}
+
+// Reset resets the response to its zero value
+func (m *RateLimitResponse) Reset() { *m = RateLimitResponse{} }
+
+// String returns the response in the protobuf text format
+func (m *RateLimitResponse) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage marks the response as a protobuf message
+func (*RateLimitResponse) ProtoMessage() {}
+
+// RateLimitResponse_RateLimit describes the limit applied to a descriptor
+type RateLimitResponse_RateLimit struct {
+	RequestsPerUnit uint32                           `protobuf:"varint,1,opt,name=requests_per_unit,json=requestsPerUnit,proto3"`
+	Unit            RateLimitResponse_RateLimit_Unit `protobuf:"varint,2,opt,name=unit,proto3,enum=envoy.service.ratelimit.v3.RateLimitResponse_RateLimit_Unit"`
+	Name            string                           `protobuf:"bytes,3,opt,name=name,proto3"`
+}
+
+// Reset resets the limit to its zero value
+func (m *RateLimitResponse_RateLimit) Reset() { *m = RateLimitResponse_RateLimit{} }
+
+// String returns the limit in the protobuf text format
+func (m *RateLimitResponse_RateLimit) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage marks the limit as a protobuf message
+func (*RateLimitResponse_RateLimit) ProtoMessage() {}
+
+// RateLimitResponse_DescriptorStatus is the decision made for a descriptor
+type RateLimitResponse_DescriptorStatus struct {
+	Code RateLimitResponse_Code `protobuf:"varint,1,opt,name=code,proto3,enum=envoy.service.ratelimit.v3.RateLimitResponse_Code"`
+	// CurrentLimit is nil when no limit applies to the descriptor
+	CurrentLimit       *RateLimitResponse_RateLimit `protobuf:"bytes,2,opt,name=current_limit,json=currentLimit,proto3"`
+	LimitRemaining     uint32                       `protobuf:"varint,3,opt,name=limit_remaining,json=limitRemaining,proto3"`
+	DurationUntilReset *duration.Duration           `protobuf:"bytes,4,opt,name=duration_until_reset,json=durationUntilReset,proto3"`
+}
+
+// Reset resets the status to its zero value
+func (m *RateLimitResponse_DescriptorStatus) Reset() { *m = RateLimitResponse_DescriptorStatus{} }
+
+// String returns the status in the protobuf text format
+func (m *RateLimitResponse_DescriptorStatus) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage marks the status as a protobuf message
+func (*RateLimitResponse_DescriptorStatus) ProtoMessage() {}
+
+// RateLimitServiceServer is the server API of the rate limit service
+type RateLimitServiceServer interface {
+	ShouldRateLimit(context.Context, *RateLimitRequest) (*RateLimitResponse, error)
+}
+
+// RegisterRateLimitServiceServer registers the provided implementation
+// of the rate limit service with the gRPC server
+func RegisterRateLimitServiceServer(s *grpc.Server, srv RateLimitServiceServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*RateLimitServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ShouldRateLimit",
+			Handler:    shouldRateLimitHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "envoy/service/ratelimit/v3/rls.proto",
+}
+
+func shouldRateLimitHandler(srv interface{}, ctxt context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RateLimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+
+	if interceptor == nil {
+		return srv.(RateLimitServiceServer).ShouldRateLimit(ctxt, in)
+	}
+
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: fmt.Sprintf("/%s/ShouldRateLimit", ServiceName),
+	}
+
+	return interceptor(ctxt, in, info, func(ctxt context.Context, req interface{}) (interface{}, error) {
+		return srv.(RateLimitServiceServer).ShouldRateLimit(ctxt, req.(*RateLimitRequest))
+	})
+}
+
+// RateLimitServiceClient is the client API of the rate limit service
+type RateLimitServiceClient interface {
+	ShouldRateLimit(ctxt context.Context, in *RateLimitRequest, opts ...grpc.CallOption) (*RateLimitResponse, error)
+}
+
+// NewRateLimitServiceClient returns a client of the rate limit
+// service which calls the service over the provided connection
+func NewRateLimitServiceClient(cc *grpc.ClientConn) RateLimitServiceClient {
+	return client{cc}
+}
+
+type client struct {
+	cc *grpc.ClientConn
+}
+
+// ShouldRateLimit calls the ShouldRateLimit method of the service
+func (c client) ShouldRateLimit(ctxt context.Context, in *RateLimitRequest, opts ...grpc.CallOption) (*RateLimitResponse, error) {
+	out := new(RateLimitResponse)
+	if err := c.cc.Invoke(ctxt, fmt.Sprintf("/%s/ShouldRateLimit", ServiceName), in, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package sync
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+)
+
+// ErrorRateNotPermitted is returned by a call to NewGCRA
+// or NewTokenBucket if the limit or period is <= 0
+var ErrorRateNotPermitted = rate.ErrRateNotPermitted
+
+// GCRA limits keys using the generic cell rate algorithm (see rate.GCRA)
+// It stores a single theoretical arrival time per key which gives smooth
+// pacing at limit per period with bursts of up to burst requests
+// Keys whose theoretical arrival time has passed are equivalent to
+// unseen keys and are periodically evicted
+type GCRA struct {
+	mu   *sync.Mutex
+	tats map[string]time.Time
+
+	gcra rate.GCRA
+}
+
+// NewGCRA returns a newly configured GCRA which permits limit
+// requests per key every period with bursts of up to burst requests
+func NewGCRA(limit int, period time.Duration, burst int) (GCRA, error) {
+	g := GCRA{mu: &sync.Mutex{}, tats: map[string]time.Time{}}
+
+	gcra, err := rate.NewGCRA(limit, period, burst)
+	if err != nil {
+		return g, err
+	}
+
+	g.gcra = gcra
+
+	go g.evictLoop(period)
+
+	return g, nil
+}
+
+// Acquire returns true if a request for key conforms
+func (g GCRA) Acquire(ctxt context.Context, key string) (bool, error) {
+	return g.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN returns true if n requests for key conform at once
+func (g GCRA) AcquireN(_ context.Context, key string, n int) (bool, error) {
+	if !g.gcra.Permits(n) {
+		return false, ErrorCostNotPermitted
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tat, ok := g.gcra.Conform(g.tats[key], now(), n)
+	if ok {
+		g.tats[key] = tat
+	}
+
+	return ok, nil
+}
+
+// Reserve reserves a request for key (see ReserveN)
+func (g GCRA) Reserve(ctxt context.Context, key string) (rate.Reservation, error) {
+	return g.ReserveN(ctxt, key, 1)
+}
+
+// ReserveN reserves n requests for key and returns the time
+// from which they conform
+// Cancelling the reservation refunds the requests
+func (g GCRA) ReserveN(ctxt context.Context, key string, n int) (rate.Reservation, error) {
+	if !g.gcra.Permits(n) {
+		return rate.Reservation{}, ErrorCostNotPermitted
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tat, at := g.gcra.Reserve(g.tats[key], now(), n)
+	g.tats[key] = tat
+
+	return rate.NewReservation(at, func(ctxt context.Context) error {
+		return g.Refund(ctxt, key, n)
+	}), nil
+}
+
+// Refund returns n previously acquired requests for key by
+// moving its theoretical arrival time back
+func (g GCRA) Refund(_ context.Context, key string, n int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if tat, ok := g.tats[key]; ok {
+		g.tats[key] = tat.Add(-g.gcra.Emission * time.Duration(n))
+	}
+
+	return nil
+}
+
+// Status returns the budget of key without consuming any of it
+func (g GCRA) Status(_ context.Context, key string) (rate.Status, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.gcra.Status(g.tats[key], now()), nil
+}
+
+func (g GCRA) evictLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		g.evict()
+	}
+}
+
+// evict removes keys whose theoretical arrival time has passed
+func (g GCRA) evict() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := now()
+	for key, tat := range g.tats {
+		if tat.Before(now) {
+			delete(g.tats, key)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GCRA(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	// one per second with bursts of 2
+	gcra, err := NewGCRA(60, time.Minute, 2)
+	require.Nil(t, err)
+
+	attempt := func(expected bool) {
+		t.Helper()
+
+		acquired, err := gcra.Acquire(ctxt, "/foo")
+		require.Nil(t, err)
+		assert.Equal(t, expected, acquired)
+	}
+
+	attempt(true)
+	attempt(true)
+	attempt(false)
+
+	// requests are then paced at one per second
+	when = when.Add(500 * time.Millisecond)
+	attempt(false)
+
+	when = when.Add(500 * time.Millisecond)
+	attempt(true)
+	attempt(false)
+
+	require.Nil(t, gcra.Refund(ctxt, "/foo", 1))
+	attempt(true)
+
+	_, err = gcra.AcquireN(ctxt, "/foo", 3)
+	assert.Equal(t, ErrorCostNotPermitted, err)
+
+	// keys whose tat has passed are evicted
+	when = when.Add(time.Minute)
+	gcra.evict()
+	assert.Len(t, gcra.tats, 0)
+}
+
+func Test_GCRA_BadRate(t *testing.T) {
+	_, err := NewGCRA(0, time.Minute, 1)
+	assert.Equal(t, ErrorRateNotPermitted, err)
+
+	_, err = NewGCRA(10, 0, 1)
+	assert.Equal(t, ErrorRateNotPermitted, err)
+}
+
+func Test_GCRA_Status(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	// one per second with bursts of 3
+	gcra, err := NewGCRA(60, time.Minute, 3)
+	require.Nil(t, err)
+
+	status, err := gcra.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 3}, status)
+
+	_, err = gcra.AcquireN(ctxt, "/foo", 2)
+	require.Nil(t, err)
+
+	status, err = gcra.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 1, Reset: 2 * time.Second}, status)
+
+	// partially replenished requests do not count towards the budget
+	when = when.Add(1500 * time.Millisecond)
+
+	status, err = gcra.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond}, status)
+}
+
+func Test_GCRA_ReserveN(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	// one per second with bursts of 2
+	gcra, err := NewGCRA(60, time.Minute, 2)
+	require.Nil(t, err)
+
+	reserve := func(expected time.Time) rate.Reservation {
+		t.Helper()
+
+		reservation, err := gcra.Reserve(ctxt, "/foo")
+		require.Nil(t, err)
+		assert.Equal(t, expected, reservation.Time())
+
+		return reservation
+	}
+
+	reserve(when)
+	reserve(when)
+	reserve(when.Add(time.Second))
+	cancelled := reserve(when.Add(2 * time.Second))
+
+	require.Nil(t, cancelled.Cancel(ctxt))
+	reserve(when.Add(2 * time.Second))
+
+	_, err = gcra.ReserveN(ctxt, "/foo", 3)
+	assert.Equal(t, ErrorCostNotPermitted, err)
+}
@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+)
+
+// ErrorNoLevels is returned by a call to NewHierarchicalSemaphore
+// if no limits are provided
+var ErrorNoLevels = errors.New("at least one level limit is required")
+
+// HierarchicalSemaphore issues tokens for hierarchical keys
+// (see rate.JoinHierarchy) where every level of the key has its own limit
+// e.g. a tenant level limit which is shared by the endpoint level
+// keys beneath it
+// A token is only issued when every level in the chain has capacity,
+// in which case a token is consumed from every level
+type HierarchicalSemaphore struct {
+	levels []KeyedSemaphore
+
+	// mu serializes consumers so that the chain is checked
+	// and consumed atomically
+	mu *sync.Mutex
+}
+
+// NewHierarchicalSemaphore returns a newly configured HierarchicalSemaphore
+// The limits are ordered from root to leaf and the key of each
+// level is refilled up to its limit every refillInterval
+// Keys with more levels than limits are limited at the leaf by their full key
+func NewHierarchicalSemaphore(limits []int, refillInterval time.Duration) (HierarchicalSemaphore, error) {
+	sem := HierarchicalSemaphore{mu: &sync.Mutex{}}
+
+	if len(limits) == 0 {
+		return sem, ErrorNoLevels
+	}
+
+	for _, limit := range limits {
+		level, err := NewKeyedSemaphore(limit, refillInterval)
+		if err != nil {
+			return sem, err
+		}
+
+		sem.levels = append(sem.levels, level)
+	}
+
+	return sem, nil
+}
+
+// Acquire retrieves a token for every level of a hierarchical key
+func (s HierarchicalSemaphore) Acquire(ctxt context.Context, key string) (bool, error) {
+	return s.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN retrieves n tokens for every level of a hierarchical key
+// true is returned if every level had capacity otherwise false is
+// returned and no tokens are consumed
+func (s HierarchicalSemaphore) AcquireN(_ context.Context, key string, n int) (bool, error) {
+	chain := s.chain(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sem := range chain {
+		if n > sem.capacity() {
+			return false, ErrorCostNotPermitted
+		}
+	}
+
+	for _, sem := range chain {
+		// refills only ever add tokens so capacity observed
+		// here remains while the lock is held
+		if sem.available() < n {
+			return false, nil
+		}
+	}
+
+	for _, sem := range chain {
+		if _, err := sem.AcquireN(n); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Refund returns n previously acquired tokens to every level of the key
+func (s HierarchicalSemaphore) Refund(_ context.Context, key string, n int) error {
+	for _, sem := range s.chain(key) {
+		sem.Refund(n)
+	}
+
+	return nil
+}
+
+// Status returns the budget of the most restrictive level of the key
+func (s HierarchicalSemaphore) Status(_ context.Context, key string) (status rate.Status, _ error) {
+	for i, key := range s.keys(key) {
+		level := s.levels[i].status(key)
+		if i == 0 {
+			status = level
+			continue
+		}
+
+		status = status.Min(level)
+	}
+
+	return
+}
+
+// chain returns the semaphore for every level of the key
+func (s HierarchicalSemaphore) chain(key string) []bucket {
+	keys := s.keys(key)
+
+	chain := make([]bucket, 0, len(keys))
+	for i, key := range keys {
+		chain = append(chain, s.levels[i].semaphore(key))
+	}
+
+	return chain
+}
+
+// keys returns the key of every level of key
+func (s HierarchicalSemaphore) keys(key string) []string {
+	keys := rate.SplitHierarchy(key)
+	if len(keys) > len(s.levels) {
+		// limit the leaf level by the full key
+		keys = append(keys[:len(s.levels)-1], key)
+	}
+
+	return keys
+}
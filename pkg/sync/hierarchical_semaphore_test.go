@@ -0,0 +1,104 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HierarchicalSemaphore(t *testing.T) {
+	sem, err := NewHierarchicalSemaphore([]int{5, 3}, time.Hour)
+	require.Nil(t, err)
+
+	var (
+		ctxt    = context.Background()
+		users   = rate.JoinHierarchy("acme", "/users")
+		orders  = rate.JoinHierarchy("acme", "/orders")
+		other   = rate.JoinHierarchy("globex", "/users")
+		attempt = func(key string, n int, expected bool) {
+			t.Helper()
+
+			acquired, err := sem.AcquireN(ctxt, key, n)
+			require.Nil(t, err)
+			assert.Equal(t, expected, acquired)
+		}
+	)
+
+	attempt(users, 3, true)
+	// endpoint level is exhausted
+	attempt(users, 1, false)
+
+	attempt(orders, 2, true)
+	// tenant level is exhausted even though the endpoint has capacity
+	attempt(orders, 1, false)
+
+	// the denied attempt consumed nothing from the endpoint level
+	require.Nil(t, sem.Refund(ctxt, users, 1))
+	attempt(orders, 1, true)
+
+	// other tenants have their own budget
+	attempt(other, 3, true)
+
+	_, err = sem.AcquireN(ctxt, other, 4)
+	assert.Equal(t, ErrorCostNotPermitted, err)
+}
+
+func Test_HierarchicalSemaphore_Leaf(t *testing.T) {
+	sem, err := NewHierarchicalSemaphore([]int{3, 1}, time.Hour)
+	require.Nil(t, err)
+
+	ctxt := context.Background()
+
+	// keys deeper than the configured levels are limited at the leaf by the full key
+	acquired, _ := sem.Acquire(ctxt, rate.JoinHierarchy("acme", "/users", "GET"))
+	assert.True(t, acquired)
+
+	acquired, _ = sem.Acquire(ctxt, rate.JoinHierarchy("acme", "/users", "GET"))
+	assert.False(t, acquired)
+
+	acquired, _ = sem.Acquire(ctxt, rate.JoinHierarchy("acme", "/users", "POST"))
+	assert.True(t, acquired)
+}
+
+func Test_HierarchicalSemaphore_NoLevels(t *testing.T) {
+	_, err := NewHierarchicalSemaphore(nil, time.Minute)
+	assert.Equal(t, ErrorNoLevels, err)
+}
+
+func Test_HierarchicalSemaphore_Status(t *testing.T) {
+	var (
+		ctxt   = context.Background()
+		tenant = rate.JoinHierarchy("acme")
+		foo    = rate.JoinHierarchy("acme", "/foo")
+		bar    = rate.JoinHierarchy("acme", "/bar")
+	)
+
+	sem, err := NewHierarchicalSemaphore([]int{3, 2}, time.Minute)
+	require.Nil(t, err)
+
+	_, err = sem.Acquire(ctxt, foo)
+	require.Nil(t, err)
+
+	// the endpoint is the most restrictive level
+	status, err := sem.Status(ctxt, foo)
+	require.Nil(t, err)
+	assert.Equal(t, 2, status.Limit)
+	assert.Equal(t, 1, status.Remaining)
+
+	_, err = sem.AcquireN(ctxt, bar, 2)
+	require.Nil(t, err)
+
+	// the tenant is exhausted by the keys beneath it
+	status, err = sem.Status(ctxt, foo)
+	require.Nil(t, err)
+	assert.Equal(t, 3, status.Limit)
+	assert.Equal(t, 0, status.Remaining)
+
+	status, err = sem.Status(ctxt, tenant)
+	require.Nil(t, err)
+	assert.Equal(t, 0, status.Remaining)
+}
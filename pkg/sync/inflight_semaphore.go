@@ -0,0 +1,59 @@
+package sync
+
+import (
+	"context"
+	"sync"
+
+	"github.com/georgemac/rate/pkg/rate"
+)
+
+// InflightSemaphore issues count tokens per provided key which
+// are only returned when the work they permit has finished
+// This limits the number of requests inflight per key rather
+// than the number started per interval
+// Tokens obtained via Acquire or AcquireN are held until refunded,
+// so callers should obtain tokens via Lease and release them
+type InflightSemaphore struct {
+	sem KeyedSemaphore
+}
+
+// NewInflightSemaphore returns a newly configured InflightSemaphore
+// which permits up to count tokens to be held per key at once
+func NewInflightSemaphore(count int) InflightSemaphore {
+	return InflightSemaphore{
+		// tokens are never refilled only refunded
+		sem: KeyedSemaphore{store: &sync.Map{}, count: count},
+	}
+}
+
+// Acquire retrieves a token for a specific key which is held until refunded
+func (s InflightSemaphore) Acquire(ctxt context.Context, key string) (bool, error) {
+	return s.sem.Acquire(ctxt, key)
+}
+
+// AcquireN retrieves n tokens for a specific key which are held until refunded
+func (s InflightSemaphore) AcquireN(ctxt context.Context, key string, n int) (bool, error) {
+	return s.sem.AcquireN(ctxt, key, n)
+}
+
+// Lease retrieves n tokens for a specific key
+// true is returned if all n slots are acquired in which case
+// they are returned when the provided lease is released
+func (s InflightSemaphore) Lease(ctxt context.Context, key string, n int) (rate.Lease, bool, error) {
+	sem := s.sem.semaphore(key)
+
+	acquired, err := sem.AcquireN(n)
+	if err != nil || !acquired {
+		return nil, acquired, err
+	}
+
+	return rate.ReleaseOnce(func(context.Context) error {
+		sem.Refund(n)
+		return nil
+	}), true, nil
+}
+
+// Refund returns n previously acquired tokens for a specific key
+func (s InflightSemaphore) Refund(ctxt context.Context, key string, n int) error {
+	return s.sem.Refund(ctxt, key, n)
+}
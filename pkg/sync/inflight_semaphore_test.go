@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_InflightSemaphore(t *testing.T) {
+	var (
+		sem     = NewInflightSemaphore(2)
+		ctxt    = context.Background()
+		attempt = func(key string, expected bool) func() {
+			t.Helper()
+
+			lease, acquired, err := sem.Lease(ctxt, key, 1)
+			require.Nil(t, err)
+			assert.Equal(t, expected, acquired)
+
+			if !acquired {
+				return func() {}
+			}
+
+			return func() { require.Nil(t, lease.Release(ctxt)) }
+		}
+	)
+
+	first := attempt("/foo", true)
+	second := attempt("/foo", true)
+	attempt("/foo", false)
+
+	// keys are limited independently
+	attempt("/bar", true)
+
+	// releasing returns the token
+	first()
+	third := attempt("/foo", true)
+	attempt("/foo", false)
+
+	// releasing more than once returns only a single token
+	second()
+	second()
+	third()
+
+	attempt("/foo", true)
+	attempt("/foo", true)
+	attempt("/foo", false)
+}
+
+func Test_InflightSemaphore_Limiter(t *testing.T) {
+	sem, err := NewKeyedSemaphore(10, time.Minute)
+	require.Nil(t, err)
+
+	var (
+		entered = make(chan struct{}, 2)
+		finish  = make(chan struct{})
+		proxy   = http.HandlerFunc(func(http.ResponseWriter, *http.Request) { entered <- struct{}{}; <-finish })
+		limiter = rate.NewLimiter(proxy, sem, rate.WithInflight(NewInflightSemaphore(1), rate.DelayWaiter(5*time.Millisecond)))
+		wg      gosync.WaitGroup
+		serve   = func() {
+			defer wg.Done()
+
+			rec := httptest.NewRecorder()
+			limiter.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/foo", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}
+	)
+
+	wg.Add(2)
+	go serve()
+	<-entered
+
+	// the second request is queued while the first is inflight
+	go serve()
+
+	select {
+	case <-entered:
+		t.Fatal("expected request to be queued")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// and is proxied as soon as the first request finishes
+	finish <- struct{}{}
+
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Fatal("expected queued request to be proxied")
+	}
+
+	close(finish)
+	wg.Wait()
+}
@@ -5,32 +5,78 @@ import (
 	"errors"
 	"sync"
 	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
 )
 
 // ErrorRefillIntervalNotPermitted is returned by a call to NewKeyedSemaphore
 // if the refill interval is <= 0
 var ErrorRefillIntervalNotPermitted = errors.New("refill interval must be >= 0")
 
+// bucket is the token store held for each key of a KeyedSemaphore
+type bucket interface {
+	Acquire() (bool, error)
+	AcquireN(n int) (bool, error)
+	Wait(ctxt context.Context, n int) error
+	Refund(n int)
+	capacity() int
+	available() int
+}
+
 // KeyedSemaphore issues count tokens per provided key
 // and periodically refills semaphore with tokens
 // after every refillInterval
 type KeyedSemaphore struct {
 	store *sync.Map
 
-	count int
+	count          int
+	refillInterval time.Duration
+
+	// burst is the capacity of each key when refilled continuously
+	// using a TokenBucket (see WithTokenBucket)
+	burst int
+}
+
+// Option is a functional option for a KeyedSemaphore
+type Option func(*KeyedSemaphore)
+
+// WithTokenBucket configures the KeyedSemaphore to refill the tokens
+// of each key continuously at count per refillInterval, using a
+// TokenBucket which holds at most burst tokens, rather than
+// refilling every token at each interval boundary
+func WithTokenBucket(burst int) Option {
+	return func(s *KeyedSemaphore) {
+		if burst < 1 {
+			burst = 1
+		}
+
+		s.burst = burst
+	}
 }
 
 // NewKeyedSemaphore returns a newly configured KeyedSemaphore
 // which can be used to borrow tokens for particular keys
 // up to a configured limit count, at any one time.
-func NewKeyedSemaphore(count int, refillInterval time.Duration) (KeyedSemaphore, error) {
-	sem := KeyedSemaphore{store: &sync.Map{}, count: count}
+func NewKeyedSemaphore(count int, refillInterval time.Duration, opts ...Option) (KeyedSemaphore, error) {
+	sem := KeyedSemaphore{store: &sync.Map{}, count: count, refillInterval: refillInterval}
+
+	for _, opt := range opts {
+		opt(&sem)
+	}
 
 	if refillInterval <= 0 {
 		return sem, ErrorRefillIntervalNotPermitted
 	}
 
-	go sem.refillLoop(refillInterval)
+	if sem.burst > 0 && count <= 0 {
+		// token buckets refill a token every refillInterval / count
+		return sem, ErrorRateNotPermitted
+	}
+
+	if sem.burst == 0 {
+		// token buckets are refilled lazily on use
+		go sem.refillLoop(refillInterval)
+	}
 
 	return sem, nil
 }
@@ -38,16 +84,160 @@ func NewKeyedSemaphore(count int, refillInterval time.Duration) (KeyedSemaphore,
 // Acquire retrieves a token for a specific key
 // true is returned if a slot is acquired otherwise false is returned
 func (s KeyedSemaphore) Acquire(_ context.Context, key string) (bool, error) {
+	return s.semaphore(key).Acquire()
+}
+
+// AcquireN retrieves n tokens for a specific key
+// true is returned if all n slots are acquired otherwise false is returned
+func (s KeyedSemaphore) AcquireN(_ context.Context, key string, n int) (bool, error) {
+	return s.semaphore(key).AcquireN(n)
+}
+
+// ClaimN retrieves n tokens for a specific key (see AcquireN)
+// The returned rate.RefundFunc returns the tokens given the key has
+// not been refilled since they were acquired, as the refill already
+// replaced them
+// Token buckets refill continuously so their tokens are always returned
+func (s KeyedSemaphore) ClaimN(_ context.Context, key string, n int) (rate.RefundFunc, bool, error) {
+	b := s.semaphore(key)
+
+	sem, ok := b.(*Semaphore)
+	if !ok {
+		acquired, err := b.AcquireN(n)
+
+		return func(context.Context) error {
+			b.Refund(n)
+			return nil
+		}, acquired, err
+	}
+
+	refills, acquired, err := sem.claim(n)
+
+	return func(context.Context) error {
+		sem.refundTo(refills, n)
+		return nil
+	}, acquired, err
+}
+
+// Wait blocks until a token for a specific key has been handed to the
+// caller or until the provided context is cancelled
+// Callers for a key are served in the order they arrived
+func (s KeyedSemaphore) Wait(ctxt context.Context, key string) error {
+	return s.WaitN(ctxt, key, 1)
+}
+
+// WaitN blocks until n tokens for a specific key have been handed to
+// the caller or until the provided context is cancelled
+func (s KeyedSemaphore) WaitN(ctxt context.Context, key string, n int) error {
+	return s.semaphore(key).Wait(ctxt, n)
+}
+
+// Reserve reserves a token for a specific key (see ReserveN)
+func (s KeyedSemaphore) Reserve(ctxt context.Context, key string) (rate.Reservation, error) {
+	return s.ReserveN(ctxt, key, 1)
+}
+
+// ReserveN reserves n tokens for a specific key and returns when they
+// may be used, which is straight away given they are available
+// Otherwise the tokens are taken from the next interval boundary at
+// which they are refilled, or from the continuous refill of the key
+// when configured with a TokenBucket
+func (s KeyedSemaphore) ReserveN(_ context.Context, key string, n int) (rate.Reservation, error) {
+	var (
+		b   = s.semaphore(key)
+		now = now()
+	)
+
+	if tb, ok := b.(*TokenBucket); ok {
+		delay, err := tb.reserve(n)
+		if err != nil {
+			return rate.Reservation{}, err
+		}
+
+		return rate.NewReservation(now.Add(delay), func(context.Context) error {
+			tb.Refund(n)
+			return nil
+		}), nil
+	}
+
+	sem := b.(*Semaphore)
+
+	refills, err := sem.reserve(n)
+	if err != nil {
+		return rate.Reservation{}, err
+	}
+
+	at := now
+	if refills > 0 {
+		at = now.Truncate(s.refillInterval).Add(time.Duration(refills) * s.refillInterval)
+	}
+
+	return rate.NewReservation(at, func(context.Context) error {
+		sem.unreserve(n)
+		return nil
+	}), nil
+}
+
+// Refund returns n previously acquired tokens for a specific key
+// Tokens acquired before the key was last refilled are returned to the
+// current refill instead, so ClaimN should be preferred
+func (s KeyedSemaphore) Refund(_ context.Context, key string, n int) error {
+	if v, ok := s.store.Load(key); ok {
+		v.(bucket).Refund(n)
+	}
+
+	return nil
+}
+
+// Status returns the budget of a specific key without consuming any of it
+// Keys which have not been acquired have their full budget
+func (s KeyedSemaphore) Status(_ context.Context, key string) (rate.Status, error) {
+	return s.status(key), nil
+}
+
+func (s KeyedSemaphore) status(key string) rate.Status {
+	b := s.newBucket()
+	if v, ok := s.store.Load(key); ok {
+		b = v.(bucket)
+	}
+
+	status := rate.Status{Limit: b.capacity(), Remaining: b.available()}
+	if status.Remaining < 0 {
+		// callers are waiting on tokens yet to be refilled
+		status.Remaining = 0
+	}
+
+	if tb, ok := b.(*TokenBucket); ok {
+		status.Reset = tb.untilFull()
+	} else {
+		now := now()
+		status.Reset = now.Add(s.refillInterval).Truncate(s.refillInterval).Sub(now)
+	}
+
+	return status
+}
+
+func (s KeyedSemaphore) semaphore(key string) bucket {
 	var (
 		v  interface{}
 		ok bool
 	)
 
 	if v, ok = s.store.Load(key); !ok {
-		v, _ = s.store.LoadOrStore(key, NewSemaphore(s.count))
+		v, _ = s.store.LoadOrStore(key, s.newBucket())
+	}
+
+	return v.(bucket)
+}
+
+func (s KeyedSemaphore) newBucket() bucket {
+	if s.burst > 0 {
+		// the rate is validated by NewKeyedSemaphore
+		bucket, _ := NewTokenBucket(s.count, s.refillInterval, s.burst)
+		return bucket
 	}
 
-	return v.(*Semaphore).Acquire()
+	return NewSemaphore(s.count)
 }
 
 func (s KeyedSemaphore) refillLoop(refillInterval time.Duration) {
@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LeakyBucket schedules requests per key so that they are released
+// evenly, one every period / limit, which implements rate.Scheduler
+// (see rate.Pace)
+// Up to capacity requests may be scheduled ahead for a key at once,
+// further requests are not scheduled until the queue drains
+// Keys whose schedule has passed are periodically evicted
+type LeakyBucket struct {
+	mu   *sync.Mutex
+	next map[string]time.Time
+
+	interval time.Duration
+	capacity int
+}
+
+// NewLeakyBucket returns a newly configured LeakyBucket which releases
+// limit requests per key every period with up to capacity queued
+// A capacity less than 1 is treated as 1
+func NewLeakyBucket(limit int, period time.Duration, capacity int) (LeakyBucket, error) {
+	b := LeakyBucket{mu: &sync.Mutex{}, next: map[string]time.Time{}}
+
+	if limit <= 0 || period <= 0 {
+		return b, ErrorRateNotPermitted
+	}
+
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	b.interval, b.capacity = period/time.Duration(limit), capacity
+
+	go b.evictLoop(period)
+
+	return b, nil
+}
+
+// Schedule returns the time at which n requests for key may proceed
+// false is returned if capacity requests are already queued for key
+func (b LeakyBucket) Schedule(_ context.Context, key string, n int) (time.Time, bool, error) {
+	if n > b.capacity {
+		return time.Time{}, false, ErrorCostNotPermitted
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var (
+		now   = now()
+		start = b.next[key]
+	)
+
+	if start.Before(now) {
+		start = now
+	}
+
+	if start.Sub(now) >= b.interval*time.Duration(b.capacity) {
+		return time.Time{}, false, nil
+	}
+
+	b.next[key] = start.Add(b.interval * time.Duration(n))
+
+	return start, true, nil
+}
+
+// Refund returns n previously scheduled slots for key
+func (b LeakyBucket) Refund(_ context.Context, key string, n int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if next, ok := b.next[key]; ok {
+		b.next[key] = next.Add(-b.interval * time.Duration(n))
+	}
+
+	return nil
+}
+
+func (b LeakyBucket) evictLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		b.evict()
+	}
+}
+
+// evict removes keys whose schedule has passed
+func (b LeakyBucket) evict() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := now()
+	for key, next := range b.next {
+		if next.Before(now) {
+			delete(b.next, key)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LeakyBucket(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	// one request every 6 seconds with up to 3 queued
+	bucket, err := NewLeakyBucket(10, time.Minute, 3)
+	require.Nil(t, err)
+
+	schedule := func(key string, expected time.Time, ok bool) {
+		t.Helper()
+
+		at, scheduled, err := bucket.Schedule(ctxt, key, 1)
+		require.Nil(t, err)
+		assert.Equal(t, ok, scheduled)
+		assert.Equal(t, expected, at)
+	}
+
+	// requests arriving at once are spaced evenly
+	schedule("/foo", when, true)
+	schedule("/foo", when.Add(6*time.Second), true)
+	schedule("/foo", when.Add(12*time.Second), true)
+	schedule("/foo", time.Time{}, false)
+
+	// keys are scheduled independently
+	schedule("/bar", when, true)
+
+	// the queue drains as time passes
+	when = when.Add(6 * time.Second)
+	schedule("/foo", when.Add(12*time.Second), true)
+
+	// refunding returns the last slot
+	require.Nil(t, bucket.Refund(ctxt, "/foo", 1))
+	schedule("/foo", when.Add(12*time.Second), true)
+
+	_, _, err = bucket.Schedule(ctxt, "/foo", 4)
+	assert.Equal(t, ErrorCostNotPermitted, err)
+}
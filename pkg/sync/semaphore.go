@@ -1,61 +1,266 @@
 package sync
 
-import "sync"
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/georgemac/rate/pkg/rate"
+)
+
+// ErrorCostNotPermitted is returned when more tokens are requested
+// at once than the semaphore could ever hold
+var ErrorCostNotPermitted = rate.ErrCostExceedsLimit
 
 // Semaphore is a concurrency construct used to issue a bound
-// number of tokens to callers. Blocking calls to Get until
-// a token becomes available.
+// number of tokens to callers. Blocking calls to Wait until
+// enough tokens become available.
+// Blocked callers are queued in FIFO order and tokens are handed
+// directly to the head of the queue as they are refilled or refunded
 type Semaphore struct {
-	tokens chan struct{}
+	mu *sync.Mutex
+
+	tokens  int
+	waiters *list.List
 
-	mu *sync.RWMutex
+	// debt is the number of tokens reserved from future refills
+	debt int
+
+	// refills counts the refills of the semaphore so that tokens are
+	// only refunded to the refill they were acquired from
+	refills int
 
 	count int
 }
 
+// waiter is a caller blocked in Wait for n tokens
+// ready is closed once the tokens have been handed to it
+type waiter struct {
+	n     int
+	ready chan struct{}
+}
+
 // NewSemaphore constructs a newly configured semaphore with
 // the provided token count
 func NewSemaphore(count int) *Semaphore {
-	sem := &Semaphore{
-		tokens: make(chan struct{}, count),
-		count:  count,
-		mu:     &sync.RWMutex{},
+	return &Semaphore{
+		mu:      &sync.Mutex{},
+		tokens:  count,
+		waiters: list.New(),
+		count:   count,
 	}
-
-	sem.Refill()
-
-	return sem
 }
 
 // Acquire returns true if the current semaphore has capacity
 // The act of call Acquire removes one token from the bucket
 func (s *Semaphore) Acquire() (bool, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	return s.AcquireN(1)
+}
+
+// AcquireN returns true if the current semaphore has capacity
+// for n tokens, in which case all n tokens are removed from the bucket
+// Capacity is not available while callers are blocked in Wait so
+// that they cannot be overtaken
+func (s *Semaphore) AcquireN(n int) (bool, error) {
+	_, acquired, err := s.claim(n)
+	return acquired, err
+}
+
+// claim acquires n tokens (see AcquireN) and returns the number of
+// refills which preceded them, which refundTo binds refunds to
+func (s *Semaphore) claim(n int) (int, bool, error) {
+	if n > s.count {
+		return 0, false, ErrorCostNotPermitted
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.waiters.Len() > 0 || s.tokens < n {
+		return s.refills, false, nil
+	}
+
+	s.tokens -= n
+
+	return s.refills, true, nil
+}
+
+// Wait blocks until n tokens have been handed to the caller or
+// until the provided context is cancelled, in which case the
+// error of the context is returned and no tokens are taken
+func (s *Semaphore) Wait(ctxt context.Context, n int) error {
+	if n > s.count {
+		return ErrorCostNotPermitted
+	}
+
+	s.mu.Lock()
+
+	if s.waiters.Len() == 0 && s.tokens >= n {
+		s.tokens -= n
+		s.mu.Unlock()
+
+		return nil
+	}
+
+	var (
+		w    = &waiter{n: n, ready: make(chan struct{})}
+		elem = s.waiters.PushBack(w)
+	)
+
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctxt.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	select {
-	case <-s.tokens:
-		return true, nil
+	case <-w.ready:
+		// handed the tokens while giving up so return them
+		s.tokens += n
 	default:
-		// if token currently unavailable
-		return false, nil
+		s.waiters.Remove(elem)
+	}
+
+	// waiters behind this one may now be served
+	s.notify()
+
+	return ctxt.Err()
+}
+
+// Refund returns n previously acquired tokens to the semaphore
+// Tokens in excess of the semaphores count are thrown away
+func (s *Semaphore) Refund(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refund(n)
+}
+
+// refundTo returns n tokens claimed after refills refills to the
+// semaphore (see Refund), given it has not been refilled since
+// Otherwise the tokens were replaced by the refill and are thrown away
+func (s *Semaphore) refundTo(refills, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.refills == refills {
+		s.refund(n)
+	}
+}
+
+// refund returns n tokens up to the count of the semaphore and hands
+// them to any waiting callers
+// It must be called while holding the lock
+func (s *Semaphore) refund(n int) {
+	s.tokens += n
+	if s.tokens > s.count {
+		s.tokens = s.count
+	}
+
+	s.notify()
+}
+
+// reserve takes n tokens from the semaphore given they are available
+// and nobody is waiting, in which case 0 is returned
+// Otherwise the tokens are reserved from future refills and the number
+// of refills until they may be used is returned
+func (s *Semaphore) reserve(n int) (int, error) {
+	if n > s.count {
+		return 0, ErrorCostNotPermitted
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.waiters.Len() == 0 && s.debt == 0 && s.tokens >= n {
+		s.tokens -= n
+		return 0, nil
+	}
+
+	s.debt += n
+
+	// round up to the refill which covers the whole debt
+	return (s.debt + s.count - 1) / s.count, nil
+}
+
+// unreserve hands back n tokens which were reserved, forgiving any
+// debt which has yet to be paid before refunding the remainder
+func (s *Semaphore) unreserve(n int) {
+	s.mu.Lock()
+
+	forgiven := n
+	if forgiven > s.debt {
+		forgiven = s.debt
+	}
+
+	s.debt -= forgiven
+
+	s.mu.Unlock()
+
+	if n -= forgiven; n > 0 {
+		s.Refund(n)
+	}
+}
+
+// capacity returns the most tokens the semaphore can hold
+func (s *Semaphore) capacity() int {
+	return s.count
+}
+
+// available returns the number of tokens currently available
+// to callers which are not waiting
+func (s *Semaphore) available() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.waiters.Len() > 0 {
+		return 0
 	}
+
+	return s.tokens
 }
 
-// Refill obtains a write lock and refills the token channel
-// for more consumers
-// Once refilled it broadcasts to any waiting goroutines to
-// re-obtain their locks
+// Refill refills the semaphore to its count and hands
+// tokens to any waiting callers in the order they arrived
+// Tokens which have been reserved from the refill are
+// withheld from the count
 func (s *Semaphore) Refill() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for i := 0; i < s.count; i++ {
-		select {
-		case s.tokens <- struct{}{}:
-			// attempt to fill up to count tokens
-		default:
-			// otherwise throw extras away
+	paid := s.debt
+	if paid > s.count {
+		paid = s.count
+	}
+
+	s.debt -= paid
+	s.tokens = s.count - paid
+	s.refills++
+
+	s.notify()
+}
+
+// notify hands tokens to waiters from the head of the queue
+// until the head requires more tokens than remain
+// It must be called while holding the lock
+func (s *Semaphore) notify() {
+	for s.waiters.Len() > 0 {
+		var (
+			front = s.waiters.Front()
+			w     = front.Value.(*waiter)
+		)
+
+		if w.n > s.tokens {
+			return
 		}
+
+		s.tokens -= w.n
+		s.waiters.Remove(front)
+		close(w.ready)
 	}
 }
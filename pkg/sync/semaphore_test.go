@@ -1,12 +1,14 @@
 package sync
 
 import (
+	"context"
 	"math/rand"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/georgemac/rate/pkg/rate"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -70,3 +72,285 @@ func Test_KeyedSemaphore_BadInterval(t *testing.T) {
 	_, err := NewKeyedSemaphore(10, 0)
 	require.Error(t, err, ErrorRefillIntervalNotPermitted)
 }
+
+func Test_Semaphore_Refund(t *testing.T) {
+	semaphore := NewSemaphore(1)
+
+	acquired, err := semaphore.Acquire()
+	require.Nil(t, err)
+	require.True(t, acquired)
+
+	semaphore.Refund(1)
+
+	acquired, err = semaphore.Acquire()
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// refunds never take the semaphore beyond its count
+	semaphore.Refund(1)
+	semaphore.Refund(1)
+
+	acquired, _ = semaphore.Acquire()
+	assert.True(t, acquired)
+
+	acquired, _ = semaphore.Acquire()
+	assert.False(t, acquired)
+}
+
+func Test_KeyedSemaphore_ClaimN(t *testing.T) {
+	var (
+		ctxt     = context.Background()
+		sem, err = NewKeyedSemaphore(2, time.Minute)
+	)
+	require.Nil(t, err)
+
+	refund, acquired, err := sem.ClaimN(ctxt, "foo", 2)
+	require.Nil(t, err)
+	require.True(t, acquired)
+
+	// tokens refunded before the key is refilled are returned
+	require.Nil(t, refund(ctxt))
+	assert.Equal(t, 2, sem.status("foo").Remaining)
+
+	refund, acquired, err = sem.ClaimN(ctxt, "foo", 2)
+	require.Nil(t, err)
+	require.True(t, acquired)
+
+	sem.refillAll()
+
+	acquired, err = sem.AcquireN(ctxt, "foo", 1)
+	require.Nil(t, err)
+	require.True(t, acquired)
+
+	// whereas those acquired before the refill are not, as the
+	// refill replaced them
+	require.Nil(t, refund(ctxt))
+	assert.Equal(t, 1, sem.status("foo").Remaining)
+}
+
+func Test_Semaphore_AcquireN(t *testing.T) {
+	semaphore := NewSemaphore(10)
+
+	acquired, err := semaphore.AcquireN(7)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// not enough tokens remain so none are taken
+	acquired, err = semaphore.AcquireN(4)
+	require.Nil(t, err)
+	assert.False(t, acquired)
+
+	acquired, err = semaphore.AcquireN(3)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	// more than the semaphore could ever hold
+	_, err = semaphore.AcquireN(11)
+	assert.Equal(t, ErrorCostNotPermitted, err)
+
+	semaphore.Refund(5)
+
+	acquired, _ = semaphore.AcquireN(5)
+	assert.True(t, acquired)
+}
+
+func Test_Semaphore_Wait(t *testing.T) {
+	var (
+		semaphore = NewSemaphore(2)
+		ctxt      = context.Background()
+		order     = make(chan int, 3)
+		wg        sync.WaitGroup
+	)
+
+	// exhaust the semaphore
+	require.Nil(t, semaphore.Wait(ctxt, 2))
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			require.Nil(t, semaphore.Wait(ctxt, 1))
+			order <- i
+		}(i)
+
+		// ensure waiters are queued in order
+		waitForWaiters(t, semaphore, i+1)
+	}
+
+	// refunded tokens are handed to the head of the queue
+	for i := 0; i < 2; i++ {
+		semaphore.Refund(1)
+		assert.Equal(t, i, <-order)
+	}
+
+	// refilling serves the remaining waiter
+	semaphore.Refill()
+	assert.Equal(t, 2, <-order)
+
+	wg.Wait()
+
+	// the remaining token is available once nobody is waiting
+	acquired, err := semaphore.Acquire()
+	require.Nil(t, err)
+	assert.True(t, acquired)
+}
+
+func Test_Semaphore_Wait_NoOvertaking(t *testing.T) {
+	var (
+		semaphore = NewSemaphore(2)
+		done      = make(chan error)
+	)
+
+	acquired, _ := semaphore.AcquireN(2)
+	require.True(t, acquired)
+
+	go func() { done <- semaphore.Wait(context.Background(), 2) }()
+	waitForWaiters(t, semaphore, 1)
+
+	// a single token is not enough for the waiter and
+	// cannot be taken by a caller which is not waiting
+	semaphore.Refund(1)
+
+	acquired, err := semaphore.Acquire()
+	require.Nil(t, err)
+	assert.False(t, acquired)
+
+	semaphore.Refund(1)
+	assert.Nil(t, <-done)
+}
+
+func Test_Semaphore_Wait_Cancelled(t *testing.T) {
+	var (
+		semaphore    = NewSemaphore(2)
+		ctxt, cancel = context.WithCancel(context.Background())
+		done         = make(chan error)
+	)
+
+	acquired, _ := semaphore.AcquireN(2)
+	require.True(t, acquired)
+
+	// a waiter for both tokens blocks a waiter for one behind it
+	go func() { done <- semaphore.Wait(ctxt, 2) }()
+	waitForWaiters(t, semaphore, 1)
+
+	go func() { done <- semaphore.Wait(context.Background(), 1) }()
+	waitForWaiters(t, semaphore, 2)
+
+	semaphore.Refund(1)
+
+	// giving up at the head of the queue serves the next waiter
+	cancel()
+
+	errs := []error{<-done, <-done}
+	assert.Contains(t, errs, context.Canceled)
+	assert.Contains(t, errs, nil)
+
+	assert.Equal(t, 0, semaphore.available())
+}
+
+func Test_KeyedSemaphore_Wait(t *testing.T) {
+	sem, err := NewKeyedSemaphore(1, 50*time.Millisecond)
+	require.Nil(t, err)
+
+	ctxt := context.Background()
+
+	require.Nil(t, sem.Wait(ctxt, "/foo"))
+
+	// the next token is handed over on refill
+	require.Nil(t, sem.Wait(ctxt, "/foo"))
+
+	// cancelled waiters do not consume the token
+	cctxt, cancel := context.WithCancel(ctxt)
+	cancel()
+
+	assert.Equal(t, context.Canceled, sem.Wait(cctxt, "/foo"))
+}
+
+func waitForWaiters(t *testing.T, semaphore *Semaphore, n int) {
+	t.Helper()
+
+	for {
+		semaphore.mu.Lock()
+		waiting := semaphore.waiters.Len()
+		semaphore.mu.Unlock()
+
+		if waiting == n {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_KeyedSemaphore_Status(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 45, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	sem, err := NewKeyedSemaphore(3, time.Minute)
+	require.Nil(t, err)
+
+	// unseen keys have their full budget until the next interval
+	status, err := sem.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 3, Reset: 15 * time.Second}, status)
+
+	_, err = sem.AcquireN(ctxt, "/foo", 2)
+	require.Nil(t, err)
+
+	status, err = sem.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 1, Reset: 15 * time.Second}, status)
+}
+
+func Test_KeyedSemaphore_ReserveN(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 45, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	sem, err := NewKeyedSemaphore(3, time.Minute)
+	require.Nil(t, err)
+
+	reserve := func(n int, expected time.Time) rate.Reservation {
+		t.Helper()
+
+		reservation, err := sem.ReserveN(ctxt, "/foo", n)
+		require.Nil(t, err)
+		assert.True(t, reservation.OK())
+		assert.Equal(t, expected, reservation.Time())
+
+		return reservation
+	}
+
+	next := time.Date(2019, 5, 3, 12, 1, 0, 0, time.UTC)
+
+	// available tokens may be used straight away
+	reserve(2, when)
+
+	// then tokens are reserved from following refills
+	reserve(2, next)
+	cancelled := reserve(3, next.Add(time.Minute))
+
+	_, err = sem.ReserveN(ctxt, "/foo", 4)
+	assert.Equal(t, ErrorCostNotPermitted, err)
+
+	// cancelling forgives the debt of the reservation
+	require.Nil(t, cancelled.Cancel(ctxt))
+
+	// the next refill withholds the reserved tokens
+	semaphore := sem.semaphore("/foo").(*Semaphore)
+	semaphore.Refill()
+	assert.Equal(t, 1, semaphore.available())
+
+	semaphore.Refill()
+	assert.Equal(t, 3, semaphore.available())
+}
@@ -0,0 +1,176 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+)
+
+var (
+	now = time.Now
+
+	// ErrorWindowNotPermitted is returned by a call to NewSlidingWindowLog
+	// if the window is <= 0
+	ErrorWindowNotPermitted = errors.New("window must be > 0")
+)
+
+// SlidingWindowLog permits limit tokens per key within any rolling
+// window of time
+// Unlike KeyedSemaphore, which refills at fixed interval boundaries,
+// a caller cannot obtain twice the limit by acquiring either side of
+// a boundary
+// It keeps a log of the time each token was acquired per key
+// Each log is a ring buffer which never exceeds limit entries and the
+// logs of keys which have not been acquired within the last window
+// are periodically evicted
+type SlidingWindowLog struct {
+	mu   *sync.Mutex
+	logs map[string]*windowLog
+
+	limit  int
+	window time.Duration
+}
+
+// NewSlidingWindowLog returns a newly configured SlidingWindowLog
+// which permits limit tokens per key within any window of time
+func NewSlidingWindowLog(limit int, window time.Duration) (SlidingWindowLog, error) {
+	log := SlidingWindowLog{
+		mu:     &sync.Mutex{},
+		logs:   map[string]*windowLog{},
+		limit:  limit,
+		window: window,
+	}
+
+	if window <= 0 {
+		return log, ErrorWindowNotPermitted
+	}
+
+	go log.evictLoop()
+
+	return log, nil
+}
+
+// Acquire retrieves a token for a specific key
+// true is returned if fewer than limit tokens have been
+// acquired within the last window otherwise false is returned
+func (s SlidingWindowLog) Acquire(ctxt context.Context, key string) (bool, error) {
+	return s.AcquireN(ctxt, key, 1)
+}
+
+// AcquireN retrieves n tokens for a specific key
+// true is returned if all n tokens fit within the limit
+// for the last window otherwise false is returned
+func (s SlidingWindowLog) AcquireN(_ context.Context, key string, n int) (bool, error) {
+	if n > s.limit {
+		return false, ErrorCostNotPermitted
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log, ok := s.logs[key]
+	if !ok {
+		log = &windowLog{times: make([]time.Time, s.limit)}
+		s.logs[key] = log
+	}
+
+	now := now()
+
+	log.expire(now.Add(-s.window))
+
+	if log.size+n > s.limit {
+		return false, nil
+	}
+
+	for i := 0; i < n; i++ {
+		log.push(now)
+	}
+
+	return true, nil
+}
+
+// Refund removes the n most recently acquired tokens from the log for key
+func (s SlidingWindowLog) Refund(_ context.Context, key string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if log, ok := s.logs[key]; ok {
+		for i := 0; i < n && log.size > 0; i++ {
+			log.size--
+		}
+	}
+
+	return nil
+}
+
+// Status returns the budget of a specific key without consuming any of it
+// The key regains its full budget once its most recent token
+// has left the window
+func (s SlidingWindowLog) Status(_ context.Context, key string) (rate.Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := rate.Status{Limit: s.limit, Remaining: s.limit}
+
+	log, ok := s.logs[key]
+	if !ok {
+		return status, nil
+	}
+
+	now := now()
+
+	log.expire(now.Add(-s.window))
+
+	status.Remaining -= log.size
+	if log.size > 0 {
+		newest := log.times[(log.start+log.size-1)%len(log.times)]
+		status.Reset = newest.Add(s.window).Sub(now)
+	}
+
+	return status, nil
+}
+
+func (s SlidingWindowLog) evictLoop() {
+	for range time.Tick(s.window) {
+		s.evict()
+	}
+}
+
+// evict removes the logs of any keys which have no
+// acquisitions within the last window
+func (s SlidingWindowLog) evict() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := now().Add(-s.window)
+	for key, log := range s.logs {
+		log.expire(before)
+
+		if log.size == 0 {
+			delete(s.logs, key)
+		}
+	}
+}
+
+// windowLog is a fixed capacity ring buffer of acquisition times
+// ordered from oldest to newest
+type windowLog struct {
+	times       []time.Time
+	start, size int
+}
+
+func (l *windowLog) push(t time.Time) {
+	l.times[(l.start+l.size)%len(l.times)] = t
+	l.size++
+}
+
+// expire drops every entry which was recorded at or before t
+func (l *windowLog) expire(t time.Time) {
+	for l.size > 0 && !l.times[l.start].After(t) {
+		l.start = (l.start + 1) % len(l.times)
+		l.size--
+	}
+}
@@ -0,0 +1,197 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setNow fixes the current time returned by now until the returned func is called
+func setNow(t *testing.T, when *time.Time) func() {
+	t.Helper()
+
+	now = func() time.Time { return *when }
+	return func() { now = time.Now }
+}
+
+func Test_SlidingWindowLog_BoundaryBurst(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 59, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	log, err := NewSlidingWindowLog(10, time.Minute)
+	require.Nil(t, err)
+
+	attempt := func(expected bool) {
+		t.Helper()
+
+		acquired, err := log.Acquire(ctxt, "/foo")
+		require.Nil(t, err)
+		assert.Equal(t, expected, acquired)
+	}
+
+	// the full limit is consumed just before the minute boundary
+	for i := 0; i < 10; i++ {
+		attempt(true)
+	}
+
+	attempt(false)
+
+	// a fixed window would permit another 10 here
+	when = time.Date(2019, 5, 3, 12, 1, 0, 0, time.UTC)
+	attempt(false)
+
+	when = time.Date(2019, 5, 3, 12, 1, 58, 999999999, time.UTC)
+	attempt(false)
+
+	// a full window after the burst the tokens become available again
+	when = time.Date(2019, 5, 3, 12, 1, 59, 1, time.UTC)
+	for i := 0; i < 10; i++ {
+		attempt(true)
+	}
+
+	attempt(false)
+}
+
+func Test_SlidingWindowLog_Rolling(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	log, err := NewSlidingWindowLog(3, time.Minute)
+	require.Nil(t, err)
+
+	// one token every 20 seconds is always permitted
+	for i := 0; i < 10; i++ {
+		acquired, err := log.Acquire(ctxt, "/foo")
+		require.Nil(t, err)
+		assert.True(t, acquired)
+
+		when = when.Add(20 * time.Second)
+	}
+
+	// whereas a fourth token within any 60 seconds is not
+	for i := 0; i < 3; i++ {
+		acquired, _ := log.Acquire(ctxt, "/bar")
+		assert.True(t, acquired)
+
+		when = when.Add(15 * time.Second)
+	}
+
+	acquired, _ := log.Acquire(ctxt, "/bar")
+	assert.False(t, acquired)
+
+	when = when.Add(15 * time.Second)
+
+	acquired, _ = log.Acquire(ctxt, "/bar")
+	assert.True(t, acquired)
+}
+
+func Test_SlidingWindowLog_AcquireN_Refund(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	log, err := NewSlidingWindowLog(5, time.Minute)
+	require.Nil(t, err)
+
+	acquired, err := log.AcquireN(ctxt, "/foo", 4)
+	require.Nil(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = log.AcquireN(ctxt, "/foo", 2)
+	require.Nil(t, err)
+	assert.False(t, acquired)
+
+	require.Nil(t, log.Refund(ctxt, "/foo", 1))
+
+	acquired, _ = log.AcquireN(ctxt, "/foo", 2)
+	assert.True(t, acquired)
+
+	_, err = log.AcquireN(ctxt, "/foo", 6)
+	assert.Equal(t, ErrorCostNotPermitted, err)
+}
+
+func Test_SlidingWindowLog_Evict(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	log, err := NewSlidingWindowLog(5, time.Minute)
+	require.Nil(t, err)
+
+	log.Acquire(ctxt, "/foo")
+	when = when.Add(30 * time.Second)
+	log.Acquire(ctxt, "/bar")
+
+	when = when.Add(31 * time.Second)
+	log.evict()
+
+	// only keys with acquisitions within the last window are retained
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	assert.Len(t, log.logs, 1)
+	assert.Contains(t, log.logs, "/bar")
+}
+
+func Test_SlidingWindowLog_BadWindow(t *testing.T) {
+	_, err := NewSlidingWindowLog(10, 0)
+	assert.Equal(t, ErrorWindowNotPermitted, err)
+}
+
+func Test_SlidingWindowLog_Status(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	log, err := NewSlidingWindowLog(3, time.Minute)
+	require.Nil(t, err)
+
+	status, err := log.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 3}, status)
+
+	_, err = log.Acquire(ctxt, "/foo")
+	require.Nil(t, err)
+
+	when = when.Add(20 * time.Second)
+
+	_, err = log.Acquire(ctxt, "/foo")
+	require.Nil(t, err)
+
+	// the full budget returns once the newest token leaves the window
+	status, err = log.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 1, Reset: time.Minute}, status)
+
+	when = when.Add(45 * time.Second)
+
+	status, err = log.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 2, Reset: 15 * time.Second}, status)
+}
@@ -0,0 +1,177 @@
+package sync
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket issues tokens which are refilled continuously at
+// a rate of limit tokens per period, up to a capacity of burst
+// Unlike Semaphore, which refills every token at once, capacity is
+// returned gradually so callers are not released together at the
+// start of each interval
+// Tokens are computed lazily when the bucket is used, so no
+// refill loop is required
+type TokenBucket struct {
+	mu *sync.Mutex
+
+	// tokens is the number of tokens held as of last
+	tokens float64
+	last   time.Time
+
+	// interval is the time taken to refill a single token
+	interval time.Duration
+	burst    int
+}
+
+// NewTokenBucket constructs a newly configured full TokenBucket which
+// refills limit tokens every period and holds at most burst tokens
+// A burst less than 1 is treated as 1
+// ErrorRateNotPermitted is returned if the limit or period is <= 0
+func NewTokenBucket(limit int, period time.Duration, burst int) (*TokenBucket, error) {
+	if limit <= 0 || period <= 0 {
+		return nil, ErrorRateNotPermitted
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{
+		mu:       &sync.Mutex{},
+		tokens:   float64(burst),
+		last:     now(),
+		interval: period / time.Duration(limit),
+		burst:    burst,
+	}, nil
+}
+
+// Acquire returns true if the bucket currently holds a token
+// The act of calling Acquire removes one token from the bucket
+func (b *TokenBucket) Acquire() (bool, error) {
+	return b.AcquireN(1)
+}
+
+// AcquireN returns true if the bucket currently holds n tokens
+// in which case all n tokens are removed from the bucket
+func (b *TokenBucket) AcquireN(n int) (bool, error) {
+	if n > b.burst {
+		return false, ErrorCostNotPermitted
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+
+	if b.tokens < float64(n) {
+		return false, nil
+	}
+
+	b.tokens -= float64(n)
+
+	return true, nil
+}
+
+// Wait blocks until n tokens have been taken from the bucket or until
+// the provided context is cancelled, in which case the error of the
+// context is returned and no tokens are taken
+// The tokens are reserved up front, leaving the bucket in debt, so
+// that callers are served in the order they arrive and cannot be
+// overtaken by calls to Acquire
+func (b *TokenBucket) Wait(ctxt context.Context, n int) error {
+	delay, err := b.reserve(n)
+	if err != nil || delay <= 0 {
+		return err
+	}
+
+	// wait for the debt to be refilled
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctxt.Done():
+		b.Refund(n)
+		return ctxt.Err()
+	}
+}
+
+// reserve takes n tokens from the bucket, leaving it in debt if
+// it holds fewer than n, and returns how long until the debt
+// has been refilled
+func (b *TokenBucket) reserve(n int) (time.Duration, error) {
+	if n > b.burst {
+		return 0, ErrorCostNotPermitted
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0, nil
+	}
+
+	return time.Duration(-b.tokens * float64(b.interval)), nil
+}
+
+// Refund returns n previously acquired tokens to the bucket
+// Tokens in excess of burst are thrown away
+func (b *TokenBucket) Refund(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+
+	b.tokens += float64(n)
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+}
+
+// capacity returns the most tokens the bucket can hold
+func (b *TokenBucket) capacity() int {
+	return b.burst
+}
+
+// available returns the number of whole tokens currently in the bucket
+// which is negative while callers are waiting
+func (b *TokenBucket) available() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+
+	return int(math.Floor(b.tokens))
+}
+
+// untilFull returns how long until the bucket holds burst tokens
+func (b *TokenBucket) untilFull() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+
+	return time.Duration((float64(b.burst) - b.tokens) * float64(b.interval))
+}
+
+// refill adds the tokens accrued since the bucket was last used
+// It must be called while holding the lock
+func (b *TokenBucket) refill() {
+	now := now()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += float64(elapsed) / float64(b.interval)
+		if b.tokens > float64(b.burst) {
+			b.tokens = float64(b.burst)
+		}
+	}
+
+	b.last = now
+}
@@ -0,0 +1,190 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/georgemac/rate/pkg/rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TokenBucket(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		// one token every 6 seconds holding at most 3
+		bucket, err = NewTokenBucket(10, time.Minute, 3)
+		attempt     = func(n int, expected bool) {
+			t.Helper()
+
+			acquired, err := bucket.AcquireN(n)
+			require.Nil(t, err)
+			assert.Equal(t, expected, acquired)
+		}
+	)
+
+	defer reset()
+
+	require.Nil(t, err)
+
+	// a full bucket permits the whole burst at once
+	attempt(3, true)
+	attempt(1, false)
+
+	// tokens are refilled gradually rather than at the boundary
+	when = when.Add(3 * time.Second)
+	attempt(1, false)
+
+	when = when.Add(3 * time.Second)
+	attempt(1, true)
+	attempt(1, false)
+
+	// the bucket never holds more than burst
+	when = when.Add(time.Hour)
+	assert.Equal(t, 3, bucket.available())
+
+	// refunds are capped at burst
+	attempt(1, true)
+	bucket.Refund(5)
+	assert.Equal(t, 3, bucket.available())
+
+	_, err = bucket.AcquireN(4)
+	assert.Equal(t, ErrorCostNotPermitted, err)
+}
+
+func Test_TokenBucket_BadRate(t *testing.T) {
+	_, err := NewTokenBucket(0, time.Minute, 1)
+	assert.Equal(t, ErrorRateNotPermitted, err)
+
+	_, err = NewTokenBucket(10, 0, 1)
+	assert.Equal(t, ErrorRateNotPermitted, err)
+
+	_, err = NewKeyedSemaphore(0, time.Minute, WithTokenBucket(1))
+	assert.Equal(t, ErrorRateNotPermitted, err)
+}
+
+func Test_KeyedSemaphore_WithTokenBucket(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	sem, err := NewKeyedSemaphore(60, time.Minute, WithTokenBucket(2))
+	require.Nil(t, err)
+
+	attempt := func(key string, expected bool) {
+		t.Helper()
+
+		acquired, err := sem.Acquire(ctxt, key)
+		require.Nil(t, err)
+		assert.Equal(t, expected, acquired)
+	}
+
+	attempt("/foo", true)
+	attempt("/foo", true)
+	attempt("/foo", false)
+
+	// keys are limited independently
+	attempt("/bar", true)
+
+	// one token is refilled every second
+	when = when.Add(time.Second)
+	attempt("/foo", true)
+	attempt("/foo", false)
+}
+
+func Test_TokenBucket_Wait(t *testing.T) {
+	var (
+		// one token every 10 milliseconds holding at most 1
+		bucket, err = NewTokenBucket(100, time.Second, 1)
+		ctxt        = context.Background()
+		start       = time.Now()
+	)
+
+	require.Nil(t, err)
+
+	require.Nil(t, bucket.Wait(ctxt, 1))
+	require.Nil(t, bucket.Wait(ctxt, 1))
+	assert.True(t, time.Since(start) >= 10*time.Millisecond)
+
+	// waiters cannot be overtaken while the bucket is in debt
+	go bucket.Wait(ctxt, 1)
+	for bucket.available() >= 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	acquired, err := bucket.Acquire()
+	require.Nil(t, err)
+	assert.False(t, acquired)
+
+	// cancelled waiters return their reservation
+	cctxt, cancel := context.WithCancel(ctxt)
+	cancel()
+
+	assert.Equal(t, context.Canceled, bucket.Wait(cctxt, 1))
+}
+
+func Test_KeyedSemaphore_WithTokenBucket_Status(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	// one token every second holding at most 3
+	sem, err := NewKeyedSemaphore(60, time.Minute, WithTokenBucket(3))
+	require.Nil(t, err)
+
+	_, err = sem.AcquireN(ctxt, "/foo", 2)
+	require.Nil(t, err)
+
+	// the bucket is full once both tokens are refilled
+	status, err := sem.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 1, Reset: 2 * time.Second}, status)
+
+	when = when.Add(time.Second)
+
+	status, err = sem.Status(ctxt, "/foo")
+	require.Nil(t, err)
+	assert.Equal(t, rate.Status{Limit: 3, Remaining: 2, Reset: time.Second}, status)
+}
+
+func Test_KeyedSemaphore_WithTokenBucket_ReserveN(t *testing.T) {
+	var (
+		when  = time.Date(2019, 5, 3, 12, 0, 0, 0, time.UTC)
+		reset = setNow(t, &when)
+		ctxt  = context.Background()
+	)
+
+	defer reset()
+
+	// one token every second holding at most 2
+	sem, err := NewKeyedSemaphore(60, time.Minute, WithTokenBucket(2))
+	require.Nil(t, err)
+
+	reserve := func(n int, expected time.Time) rate.Reservation {
+		t.Helper()
+
+		reservation, err := sem.ReserveN(ctxt, "/foo", n)
+		require.Nil(t, err)
+		assert.Equal(t, expected, reservation.Time())
+
+		return reservation
+	}
+
+	reserve(2, when)
+	reserve(1, when.Add(time.Second))
+	cancelled := reserve(2, when.Add(3*time.Second))
+
+	// cancelling refunds the reserved tokens
+	require.Nil(t, cancelled.Cancel(ctxt))
+	reserve(1, when.Add(2*time.Second))
+}